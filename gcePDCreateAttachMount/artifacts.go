@@ -0,0 +1,97 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var artifactsDir = flag.String("artifacts-dir", "artifacts", "Directory failure artifacts are collected into, one subdirectory per run. Empty disables collection.")
+
+// How far before a failed step the collected journal starts.
+const artifactJournalSlack = time.Minute
+
+type artifactCommand struct {
+	name    string
+	command string
+}
+
+func artifactCommands(devPath string, since, until time.Time) []artifactCommand {
+	return []artifactCommand{
+		{"dmesg", "dmesg -T"},
+		{"journal", fmt.Sprintf("journalctl --no-pager --since=@%d --until=@%d", since.Unix(), until.Unix())},
+		{"proc-mounts", "cat /proc/mounts"},
+		{"lsblk", "lsblk -f"},
+		{"disk-by-id", "ls -l " + diskByIdPath},
+		{"udevadm", "udevadm info --query=all --name=" + devPath},
+	}
+}
+
+// collectArtifacts saves diagnostics about the device of pdName from every
+// instance into a directory for the failed step, and returns that directory.
+func collectArtifacts(ctx context.Context, pdName string, stepIndex int, stepName string, instances []string, stepStart time.Time) string {
+	if *artifactsDir == "" {
+		return ""
+	}
+
+	dir := filepath.Join(*artifactsDir, pdName, fmt.Sprintf("%02d-%s", stepIndex, artifactSlug(stepName)))
+	log.Printf("Collecting failure artifacts for step %q into %q\r\n", stepName, dir)
+
+	until := time.Now()
+	for _, instanceName := range instances {
+		// The device may not be known, e.g. when the disk is not attached.
+		// The udevadm output then records that.
+		devPath, _ := provider.DevicePath(pdName, instanceName)
+		commands := artifactCommands(devPath, stepStart.Add(-artifactJournalSlack), until)
+		instanceDir := filepath.Join(dir, instanceName)
+		if err := os.MkdirAll(instanceDir, 0755); err != nil {
+			log.Printf("Failed to create artifacts directory %q: %v\r\n", instanceDir, err)
+			return ""
+		}
+		for _, c := range commands {
+			output, err := runOnInstance(ctx, c.command, instanceName)
+			if err != nil {
+				// Keep what was collected, along with why it is incomplete.
+				output = append(output, []byte(fmt.Sprintf("\n--- %q failed: %v\n", c.command, err))...)
+			}
+			file := filepath.Join(instanceDir, c.name+".txt")
+			if err := os.WriteFile(file, output, 0644); err != nil {
+				log.Printf("Failed to write artifact %q: %v\r\n", file, err)
+			}
+		}
+	}
+	return dir
+}
+
+// artifactSlug turns a step name into something safe to use as a directory
+// name.
+func artifactSlug(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExecuteStepsCollectsArtifacts(t *testing.T) {
+	useProvider(t, newFakeProvider())
+	saved := *artifactsDir
+	*artifactsDir = t.TempDir()
+	t.Cleanup(func() { *artifactsDir = saved })
+
+	var detail string
+	steps := []step{
+		{name: "works", kind: "inspect", run: func(ctx context.Context) error { return nil }},
+		{name: "breaks", kind: "mount", run: func(ctx context.Context) error { return errors.New("broken") }},
+	}
+	cleanup := []step{
+		{name: "cleans up", kind: "unmount", run: func(ctx context.Context) error { return nil }},
+	}
+	results, err := executeSteps(context.Background(), steps, cleanup, &detail, "pd-1", []string{"node-a", "node-b"})
+	if err == nil {
+		t.Fatalf("executeSteps succeeded with a failing step")
+	}
+	if len(results) != 3 {
+		t.Fatalf("executeSteps returned %d results, expected 3", len(results))
+	}
+	for i, r := range results {
+		if (r.ArtifactsDir != "") != (r.Err != nil) {
+			t.Errorf("step %d %q has error %v and artifacts %q", i, r.Name, r.Err, r.ArtifactsDir)
+		}
+	}
+
+	expectedDir := filepath.Join(*artifactsDir, "pd-1", "01-breaks")
+	if results[1].ArtifactsDir != expectedDir {
+		t.Errorf("artifacts collected into %q, expected %q", results[1].ArtifactsDir, expectedDir)
+	}
+	for _, instanceName := range []string{"node-a", "node-b"} {
+		for _, c := range artifactCommands("", time.Time{}, time.Time{}) {
+			if _, err := os.Stat(filepath.Join(expectedDir, instanceName, c.name+".txt")); err != nil {
+				t.Errorf("artifact %s of %s is missing: %v", c.name, instanceName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	attachLimitInstance = flag.String("attach-limit-instance", testInstance0Name, "Instance the attach-limit subcommand attaches disks to.")
+	attachLimitMax      = flag.Int("attach-limit-max", 128, "Stop the attach-limit subcommand after this many disks even if attach keeps succeeding.")
+)
+
+// runAttachLimit attaches new disks to a single instance until GCE refuses,
+// verifies every attached disk is usable, and then removes them all in
+// reverse order.
+func runAttachLimit(ctx context.Context) error {
+	instanceName := *attachLimitInstance
+	baseName := generatePdName()
+	log.Printf("***Attaching disks to %q until attach fails (at most %d)\r\n", instanceName, *attachLimitMax)
+
+	start := time.Now()
+	steps := &stepRecorder{}
+	var attached []string
+	var limitErr error
+	for i := 0; i < *attachLimitMax; i++ {
+		pdName := fmt.Sprintf("%s-limit-%d", baseName, i)
+		createStart := time.Now()
+		_, err := createPDWithRetry(ctx, pdName, defaultScenario().Disk)
+		steps.record(fmt.Sprintf("create disk %d", i), "create", createStart, err)
+		if err != nil {
+			limitErr = err
+			break
+		}
+
+		// Attach only once: retrying would hide the limit error.
+		attachStart := time.Now()
+		if err := provider.AttachVolume(ctx, pdName, instanceName, false /* readonly */); err != nil {
+			limitErr = err
+			// Hitting the limit is the outcome being measured, not a
+			// failed attach.
+			if classifyError(err) != errClassAttachLimit {
+				steps.record(fmt.Sprintf("attach disk %d", i), "attach", attachStart, err)
+				collectArtifacts(ctx, pdName, i, "attach", []string{instanceName}, attachStart)
+			}
+			if err := deletePDWithRetry(ctx, pdName); err != nil {
+				log.Println(err)
+			}
+			break
+		}
+		steps.record(fmt.Sprintf("attach disk %d", i), "attach", attachStart, nil)
+		attached = append(attached, pdName)
+	}
+
+	switch {
+	case limitErr == nil:
+		log.Printf("***Attached %d disks to %q without hitting a limit\r\n", len(attached), instanceName)
+	case classifyError(limitErr) == errClassAttachLimit:
+		log.Printf("***Observed attach limit on %q: %d disks\r\n", instanceName, len(attached))
+	default:
+		log.Printf("***Attach failed after %d disks on %q with an unexpected error: %v\r\n", len(attached), instanceName, limitErr)
+	}
+
+	verifyErrs := verifyAttachedDisks(ctx, attached, instanceName, steps)
+	cleanupErrs := cleanupAttachedDisks(ctx, attached, instanceName, steps)
+
+	var err error
+	switch {
+	case limitErr != nil && classifyError(limitErr) != errClassAttachLimit:
+		err = fmt.Errorf("attach failed for a reason other than the attach limit: %v", limitErr)
+	case verifyErrs > 0 || cleanupErrs > 0:
+		err = fmt.Errorf("%d of %d attached disks failed verification, %d failed cleanup", verifyErrs, len(attached), cleanupErrs)
+	}
+	recordHistory("attach-limit", instanceName, baseName, start, steps.results(), err, false /* resumed */)
+	return err
+}
+
+// verifyAttachedDisks checks each disk shows up as a block device and can be
+// formatted and mounted. It returns the number of disks that failed.
+func verifyAttachedDisks(ctx context.Context, pdNames []string, instanceName string, steps *stepRecorder) int {
+	failed := 0
+	for i, pdName := range pdNames {
+		start := time.Now()
+		devPath, err := provider.DevicePath(pdName, instanceName)
+		if err == nil {
+			_, err = runOnInstance(ctx, "test -b "+devPath, instanceName)
+		}
+		steps.record(fmt.Sprintf("verify device of disk %d", i), "inspect", start, err)
+		if err != nil {
+			log.Printf("PD %q is attached to %q but its device is missing: %v\r\n", pdName, instanceName, err)
+			collectArtifacts(ctx, pdName, i, "verify device", []string{instanceName}, start)
+			failed++
+			continue
+		}
+		mountStart := time.Now()
+		err = mountDevice(ctx, devPath, getDeviceGlobalMountPath(pdName), instanceName, testFSType, false /* readOnly */, nil /* mountOptions */)
+		steps.record(fmt.Sprintf("mount disk %d", i), "mount", mountStart, err)
+		if err != nil {
+			log.Println(err)
+			collectArtifacts(ctx, pdName, i, "mount", []string{instanceName}, mountStart)
+			failed++
+		}
+	}
+	return failed
+}
+
+// cleanupAttachedDisks unmounts, detaches and deletes the disks in reverse
+// order of attachment. It returns the number of disks that could not be
+// deleted.
+func cleanupAttachedDisks(ctx context.Context, pdNames []string, instanceName string, steps *stepRecorder) int {
+	failed := 0
+	for i := len(pdNames) - 1; i >= 0; i-- {
+		pdName := pdNames[i]
+		start := time.Now()
+		// Disks that failed verification are not mounted, so the unmount
+		// error is expected for them.
+		unmountDevice(ctx, getDeviceGlobalMountPath(pdName), instanceName)
+		detachStart := time.Now()
+		err := detachDiskWithRetry(ctx, pdName, instanceName)
+		steps.record(fmt.Sprintf("detach disk %d", i), "detach", detachStart, err)
+		if err != nil {
+			log.Println(err)
+			collectArtifacts(ctx, pdName, i, "detach", []string{instanceName}, start)
+		}
+		deleteStart := time.Now()
+		err = deletePDWithRetry(ctx, pdName)
+		steps.record(fmt.Sprintf("delete disk %d", i), "delete", deleteStart, err)
+		if err != nil {
+			log.Println(err)
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	bench            = flag.Bool("bench", false, "Run an fio benchmark against the final mount path while the PD is mounted read-write on the first instance.")
+	benchPatterns    = flag.String("bench-patterns", "read,write,randread,randwrite", "Comma separated fio access patterns to benchmark.")
+	benchBlockSizes  = flag.String("bench-block-sizes", "4k,128k,1m", "Comma separated block sizes to benchmark.")
+	benchQueueDepths = flag.String("bench-queue-depths", "1,32", "Comma separated queue depths to benchmark.")
+	benchRuntime     = flag.Int("bench-runtime", 30, "Seconds each benchmark job runs for.")
+	benchFileSize    = flag.String("bench-file-size", "1G", "Size of the file each benchmark job does I/O on.")
+)
+
+// benchmarkSpec configures the benchmark step. Every combination of pattern,
+// block size and queue depth is a separate fio job.
+type benchmarkSpec struct {
+	Patterns       []string `json:"patterns"`
+	BlockSizes     []string `json:"blockSizes"`
+	QueueDepths    []int    `json:"queueDepths"`
+	RuntimeSeconds int      `json:"runtimeSeconds"`
+	FileSize       string   `json:"fileSize"`
+}
+
+// benchmarkFromFlags returns the benchmark configured on the command line, or
+// nil if benchmarking is off.
+func benchmarkFromFlags() (*benchmarkSpec, error) {
+	if !*bench {
+		return nil, nil
+	}
+	spec := &benchmarkSpec{
+		Patterns:       strings.Split(*benchPatterns, ","),
+		BlockSizes:     strings.Split(*benchBlockSizes, ","),
+		RuntimeSeconds: *benchRuntime,
+		FileSize:       *benchFileSize,
+	}
+	for _, depth := range strings.Split(*benchQueueDepths, ",") {
+		qd, err := strconv.Atoi(depth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -bench-queue-depths %q: %v", *benchQueueDepths, err)
+		}
+		spec.QueueDepths = append(spec.QueueDepths, qd)
+	}
+	return spec, spec.validate()
+}
+
+var benchmarkPatterns = map[string]bool{
+	"read":      true,
+	"write":     true,
+	"randread":  true,
+	"randwrite": true,
+	"readwrite": true,
+	"randrw":    true,
+}
+
+// fio sizes: a number with an optional unit such as 4k, 128KiB or 1G. The
+// file size may also be a percentage of the file system. Anything else is
+// rejected, since the values end up in a shell command on the instance.
+var (
+	fioBlockSizeRE = regexp.MustCompile(`^[1-9][0-9]*([kKmMgG]i?[bB]?)?$`)
+	fioFileSizeRE  = regexp.MustCompile(`^[1-9][0-9]*(([kKmMgGtT]i?[bB]?)|%)?$`)
+)
+
+func (b *benchmarkSpec) validate() error {
+	if len(b.Patterns) == 0 || len(b.BlockSizes) == 0 || len(b.QueueDepths) == 0 {
+		return fmt.Errorf("benchmark needs at least one pattern, block size and queue depth")
+	}
+	for _, pattern := range b.Patterns {
+		if !benchmarkPatterns[pattern] {
+			return fmt.Errorf("unknown benchmark pattern %q", pattern)
+		}
+	}
+	for _, blockSize := range b.BlockSizes {
+		if !fioBlockSizeRE.MatchString(blockSize) {
+			return fmt.Errorf("invalid benchmark block size %q", blockSize)
+		}
+	}
+	for _, qd := range b.QueueDepths {
+		if qd < 1 {
+			return fmt.Errorf("invalid benchmark queue depth %d", qd)
+		}
+	}
+	if b.RuntimeSeconds < 1 {
+		return fmt.Errorf("invalid benchmark runtime %ds", b.RuntimeSeconds)
+	}
+	if !fioFileSizeRE.MatchString(b.FileSize) {
+		return fmt.Errorf("invalid benchmark file size %q", b.FileSize)
+	}
+	return nil
+}
+
+// benchmarkResult is the outcome of one fio job. Bandwidth is in bytes per
+// second and latency is the mean completion latency.
+type benchmarkResult struct {
+	Pattern      string
+	BlockSize    string
+	QueueDepth   int
+	ReadBW       int64
+	ReadIOPS     float64
+	ReadLatency  time.Duration
+	WriteBW      int64
+	WriteIOPS    float64
+	WriteLatency time.Duration
+}
+
+// fioOutput is the part of fio's JSON output the benchmark reads.
+type fioOutput struct {
+	Jobs []struct {
+		JobName string       `json:"jobname"`
+		Error   int          `json:"error"`
+		Read    fioDirection `json:"read"`
+		Write   fioDirection `json:"write"`
+	} `json:"jobs"`
+}
+
+type fioDirection struct {
+	BWBytes int64   `json:"bw_bytes"`
+	IOPS    float64 `json:"iops"`
+	ClatNs  struct {
+		Mean float64 `json:"mean"`
+	} `json:"clat_ns"`
+}
+
+// runBenchmark runs every job of spec against a file under mountPath on
+// instanceName, one after the other so they do not compete for the disk.
+func runBenchmark(ctx context.Context, spec *benchmarkSpec, mountPath, instanceName string) ([]benchmarkResult, error) {
+	log.Printf("Benchmarking %q on %q\r\n", mountPath, instanceName)
+	defer fmt.Println("------------")
+
+	// A resumed run's spec comes from its state file, which nothing else
+	// validates.
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := runOnInstance(ctx, "command -v fio", instanceName); err != nil {
+		return nil, fmt.Errorf("fio is not installed on %q: %v", instanceName, err)
+	}
+
+	benchFile := path.Join(mountPath, "fio-bench")
+	defer runOnInstance(ctx, "rm -f "+benchFile, instanceName)
+
+	var results []benchmarkResult
+	for _, pattern := range spec.Patterns {
+		for _, blockSize := range spec.BlockSizes {
+			for _, qd := range spec.QueueDepths {
+				result, err := runFioJob(ctx, spec, benchFile, pattern, blockSize, qd, instanceName)
+				if err != nil {
+					return results, err
+				}
+				log.Printf("Benchmark %s: %s\r\n", result.jobName(), result.summary())
+				results = append(results, *result)
+			}
+		}
+	}
+	return results, nil
+}
+
+func runFioJob(ctx context.Context, spec *benchmarkSpec, benchFile, pattern, blockSize string, queueDepth int, instanceName string) (*benchmarkResult, error) {
+	result := &benchmarkResult{Pattern: pattern, BlockSize: blockSize, QueueDepth: queueDepth}
+	// Direct I/O so the page cache does not inflate the numbers.
+	remoteCommand := fmt.Sprintf(
+		"fio --name=%s --filename=%s --size=%s --rw=%s --bs=%s --iodepth=%d --ioengine=libaio --direct=1 --runtime=%d --time_based --group_reporting --output-format=json",
+		result.jobName(),
+		benchFile,
+		spec.FileSize,
+		pattern,
+		blockSize,
+		queueDepth,
+		spec.RuntimeSeconds)
+	outputBytes, cmdErr := runOnInstance(ctx, remoteCommand, instanceName)
+	if cmdErr != nil {
+		log.Printf(
+			"Benchmark job %s on %q failed. error: %v\r\n",
+			result.jobName(),
+			instanceName,
+			cmdErr)
+		return nil, cmdErr
+	}
+
+	// fio may print warnings before the JSON document.
+	output := string(outputBytes)
+	if i := strings.Index(output, "{"); i > 0 {
+		output = output[i:]
+	}
+	var fio fioOutput
+	if err := json.Unmarshal([]byte(output), &fio); err != nil {
+		return nil, fmt.Errorf("parsing fio output of job %s failed: %v", result.jobName(), err)
+	}
+	if len(fio.Jobs) != 1 {
+		return nil, fmt.Errorf("fio job %s reported %d jobs, expected 1", result.jobName(), len(fio.Jobs))
+	}
+	job := fio.Jobs[0]
+	if job.Error != 0 {
+		return nil, fmt.Errorf("fio job %s failed with error %d", result.jobName(), job.Error)
+	}
+
+	result.ReadBW = job.Read.BWBytes
+	result.ReadIOPS = job.Read.IOPS
+	result.ReadLatency = time.Duration(job.Read.ClatNs.Mean)
+	result.WriteBW = job.Write.BWBytes
+	result.WriteIOPS = job.Write.IOPS
+	result.WriteLatency = time.Duration(job.Write.ClatNs.Mean)
+	return result, nil
+}
+
+func (r *benchmarkResult) jobName() string {
+	return fmt.Sprintf("%s-%s-qd%d", r.Pattern, r.BlockSize, r.QueueDepth)
+}
+
+func (r *benchmarkResult) summary() string {
+	var parts []string
+	if r.ReadIOPS > 0 {
+		parts = append(parts, fmt.Sprintf("read %s/s %.0f IOPS lat %v", formatBytes(r.ReadBW), r.ReadIOPS, r.ReadLatency.Round(time.Microsecond)))
+	}
+	if r.WriteIOPS > 0 {
+		parts = append(parts, fmt.Sprintf("write %s/s %.0f IOPS lat %v", formatBytes(r.WriteBW), r.WriteIOPS, r.WriteLatency.Round(time.Microsecond)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// printBenchmarkTable prints the benchmark results of every run that has
+// them, one line per fio job, so disk types and filesystems line up.
+func printBenchmarkTable(w io.Writer, results []*runResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FSTYPE\tDISK TYPE\tSIZE\tPATTERN\tBS\tQD\tREAD BW\tREAD IOPS\tREAD LAT\tWRITE BW\tWRITE IOPS\tWRITE LAT")
+	for _, r := range results {
+		s := r.Scenario
+		diskType := s.Disk.Type
+		if diskType == "" {
+			diskType = "default"
+		}
+		for _, b := range r.Benchmarks {
+			fmt.Fprintf(tw, "%s\t%s\t%dGB\t%s\t%s\t%d\t%s/s\t%.0f\t%v\t%s/s\t%.0f\t%v\n",
+				s.FSType,
+				diskType,
+				s.Disk.SizeGB,
+				b.Pattern,
+				b.BlockSize,
+				b.QueueDepth,
+				formatBytes(b.ReadBW),
+				b.ReadIOPS,
+				b.ReadLatency.Round(time.Microsecond),
+				formatBytes(b.WriteBW),
+				b.WriteIOPS,
+				b.WriteLatency.Round(time.Microsecond))
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func validBenchmarkSpec() benchmarkSpec {
+	return benchmarkSpec{
+		Patterns:       []string{"read", "randwrite"},
+		BlockSizes:     []string{"4k", "128KiB", "1m", "512"},
+		QueueDepths:    []int{1, 32},
+		RuntimeSeconds: 30,
+		FileSize:       "1G",
+	}
+}
+
+func TestBenchmarkSpecValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(b *benchmarkSpec)
+		ok     bool
+	}{
+		{name: "valid", modify: func(b *benchmarkSpec) {}, ok: true},
+		{name: "file size percentage", modify: func(b *benchmarkSpec) { b.FileSize = "50%" }, ok: true},
+		{name: "unknown pattern", modify: func(b *benchmarkSpec) { b.Patterns = []string{"trim"} }},
+		{name: "pattern injection", modify: func(b *benchmarkSpec) { b.Patterns = []string{"read; rm -rf /"} }},
+		{name: "block size injection", modify: func(b *benchmarkSpec) { b.BlockSizes = []string{"4k --filename=/dev/sda"} }},
+		{name: "block size command substitution", modify: func(b *benchmarkSpec) { b.BlockSizes = []string{"$(reboot)"} }},
+		{name: "zero block size", modify: func(b *benchmarkSpec) { b.BlockSizes = []string{"0k"} }},
+		{name: "file size injection", modify: func(b *benchmarkSpec) { b.FileSize = "1G && reboot" }},
+		{name: "empty file size", modify: func(b *benchmarkSpec) { b.FileSize = "" }},
+		{name: "no block sizes", modify: func(b *benchmarkSpec) { b.BlockSizes = nil }},
+		{name: "zero queue depth", modify: func(b *benchmarkSpec) { b.QueueDepths = []int{0} }},
+		{name: "zero runtime", modify: func(b *benchmarkSpec) { b.RuntimeSeconds = 0 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := validBenchmarkSpec()
+			tc.modify(&b)
+			if err := b.validate(); (err == nil) != tc.ok {
+				t.Errorf("validate() = %v, expected ok=%v", err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestRunBenchmarkRejectsInvalidSpec(t *testing.T) {
+	p := newFakeProvider()
+	useProvider(t, p)
+	b := validBenchmarkSpec()
+	b.BlockSizes = []string{"4k;reboot"}
+	if _, err := runBenchmark(context.Background(), &b, "/mnt/disks/pd", "node-a"); err == nil {
+		t.Errorf("runBenchmark ran a spec with an invalid block size")
+	}
+}
+
+func TestHistoryRecordsBenchmarks(t *testing.T) {
+	saved := *historyFile
+	*historyFile = filepath.Join(t.TempDir(), "history.jsonl")
+	t.Cleanup(func() { *historyFile = saved })
+
+	s := scenario{FSType: "xfs", Disk: diskSpec{Type: "pd-ssd", SizeGB: 100}}
+	rec := newHistoryRecord("lifecycle", s.String(), "pd-1", time.Now(), nil, nil, false /* resumed */)
+	rec.addBenchmarks(s, []benchmarkResult{{
+		Pattern:     "randread",
+		BlockSize:   "4k",
+		QueueDepth:  32,
+		ReadBW:      64 << 20,
+		ReadIOPS:    16384,
+		ReadLatency: 1500 * time.Microsecond,
+	}})
+	appendHistory(rec)
+
+	records, err := loadHistory(*historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("history has %d records, expected 1", len(records))
+	}
+	expected := []historyBenchmark{{
+		DiskType:      "pd-ssd",
+		FSType:        "xfs",
+		Pattern:       "randread",
+		BlockSize:     "4k",
+		QueueDepth:    32,
+		ReadIOPS:      16384,
+		ReadBW:        64 << 20,
+		ReadLatencyMs: 1.5,
+	}}
+	if !reflect.DeepEqual(records[0].Benchmarks, expected) {
+		t.Errorf("recorded benchmarks %+v, expected %+v", records[0].Benchmarks, expected)
+	}
+}
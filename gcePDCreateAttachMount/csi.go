@@ -0,0 +1,381 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	csiEndpoint = flag.String("csi-endpoint", "unix:///var/lib/kubelet/plugins/pd.csi.storage.gke.io/csi.sock", "Endpoint of the CSI node plugin on the instance the csi subcommand drives.")
+	csiCSC      = flag.String("csi-csc", "csc", "Path of the csc CSI client on the instance.")
+	csiInstance = flag.String("csi-instance", testInstance0Name, "Instance whose CSI node plugin the csi subcommand drives.")
+	csiSizeGB   = flag.Int("csi-size-gb", 10, "Size of the PD the csi subcommand creates, in GB.")
+)
+
+// CSI node service capabilities the csi subcommand depends on.
+const (
+	csiCapStageUnstage = "STAGE_UNSTAGE_VOLUME"
+	csiCapStats        = "GET_VOLUME_STATS"
+	csiCapExpand       = "EXPAND_VOLUME"
+)
+
+// csiRun drives a CSI node plugin through the lifecycle in place of the
+// tool's own mount code. The plugin runs on the instance, so its socket is
+// reached with csc over runOnInstance. Attach and detach are controller
+// operations and still go through the provider.
+//
+// Every node call is made twice, since the CO retries calls whose response it
+// lost and the spec requires them to be idempotent, and the mount state is
+// checked from mountinfo after each.
+type csiRun struct {
+	pdName       string
+	instanceName string
+	volumeID     string
+	stagingPath  string
+	targetPath   string
+	capabilities string
+	// detail is set by a running step to report a measurement.
+	detail string
+}
+
+func runCSI(ctx context.Context) error {
+	r := newCSIRun(generatePdName(), *csiInstance)
+
+	log.Printf("***Running CSI node plugin %s on %q with PD %q\r\n", *csiEndpoint, r.instanceName, r.pdName)
+	start := time.Now()
+	results, err := executeSteps(ctx, r.steps(), r.cleanupSteps(), &r.detail, r.pdName, []string{r.instanceName})
+	recordHistory("csi", "", r.pdName, start, results, err, false /* resumed */)
+	fmt.Printf("CSI run report for PD %q on %q\n", r.pdName, r.instanceName)
+	printStepTable(os.Stdout, results)
+	status := "PASSED"
+	if err != nil {
+		status = fmt.Sprintf("FAILED (%v)", err)
+	}
+	fmt.Printf("Total %v: %s\n", time.Since(start).Round(time.Millisecond), status)
+	return err
+}
+
+func newCSIRun(pdName, instanceName string) *csiRun {
+	return &csiRun{
+		pdName:       pdName,
+		instanceName: instanceName,
+		stagingPath:  getDeviceGlobalMountPath(pdName),
+		targetPath:   getFinalMountPath(pdName),
+	}
+}
+
+func (r *csiRun) steps() []step {
+	steps := []step{
+		{
+			name: "create",
+			kind: "create",
+			run: func(ctx context.Context) error {
+				if _, err := createPDWithRetry(ctx, r.pdName, diskSpec{SizeGB: *csiSizeGB}); err != nil {
+					return err
+				}
+				var err error
+				r.volumeID, err = provider.CSIVolumeID(r.pdName)
+				return err
+			},
+		},
+		{
+			name: fmt.Sprintf("attach to %q", r.instanceName),
+			kind: "attach",
+			run: func(ctx context.Context) error {
+				return attachDiskWithRetry(ctx, r.pdName, r.instanceName, false /* readOnly */)
+			},
+		},
+		{
+			name: "NodeGetCapabilities",
+			kind: "csi",
+			run: func(ctx context.Context) error {
+				output, err := r.csc(ctx, "get-capabilities")
+				if err != nil {
+					return err
+				}
+				r.capabilities = string(output)
+				if !r.hasCapability(csiCapStageUnstage) {
+					return fmt.Errorf("node plugin does not support %s", csiCapStageUnstage)
+				}
+				r.detail = strings.Join(strings.Fields(r.capabilities), ",")
+				return nil
+			},
+		},
+		{
+			name: "create staging path",
+			kind: "mount",
+			run: func(ctx context.Context) error {
+				_, err := runMkDir(ctx, r.stagingPath, r.instanceName)
+				return err
+			},
+		},
+	}
+	steps = append(steps, r.idempotent("NodeStageVolume", "mount", r.stage, r.checkStaged)...)
+	steps = append(steps, r.idempotent("NodePublishVolume", "bind", r.publish, r.checkPublished)...)
+	steps = append(steps,
+		step{
+			name: fmt.Sprintf("write on %q", r.instanceName),
+			kind: "write",
+			run: func(ctx context.Context) error {
+				_, err := WriteContentToFile(ctx, testFileContent, path.Join(r.targetPath, testFileName), r.instanceName)
+				return err
+			},
+		},
+		step{
+			name: fmt.Sprintf("read on %q", r.instanceName),
+			kind: "read",
+			run: func(ctx context.Context) error {
+				content, err := ReadContentsFromFile(ctx, path.Join(r.targetPath, testFileName), r.instanceName)
+				if err != nil {
+					return err
+				}
+				if content != testFileContent {
+					return fmt.Errorf("read file content differs. Expected: <%s> Actual: <%s>", testFileContent, content)
+				}
+				return nil
+			},
+		},
+		step{
+			name: "NodeGetVolumeStats",
+			kind: "csi",
+			run:  r.checkStats,
+		},
+		// Growing the disk is a controller operation, so this only checks
+		// that expanding to the current size is accepted and leaves the
+		// volume usable.
+		step{
+			name: "NodeExpandVolume",
+			kind: "csi",
+			run: func(ctx context.Context) error {
+				if !r.hasCapability(csiCapExpand) {
+					r.detail = "not supported"
+					return nil
+				}
+				if _, err := r.csc(ctx, "expand",
+					"--staging-target-path", r.stagingPath,
+					"--req-bytes", strconv.FormatInt(int64(*csiSizeGB)<<30, 10),
+					r.volumeID, r.targetPath); err != nil {
+					return err
+				}
+				return r.checkStats(ctx)
+			},
+		},
+	)
+	steps = append(steps, r.idempotent("NodeUnpublishVolume", "unbind", r.unpublish, r.checkUnmounted(r.targetPath))...)
+	steps = append(steps, r.idempotent("NodeUnstageVolume", "unmount", r.unstage, r.checkUnmounted(r.stagingPath))...)
+	return steps
+}
+
+// cleanupSteps release the disk. Unpublish and unstage are repeated first:
+// after a successful run they are no-ops, after a failed one they undo
+// whatever the plugin had done.
+func (r *csiRun) cleanupSteps() []step {
+	return []step{
+		{
+			name: "cleanup NodeUnpublishVolume",
+			kind: "unbind",
+			run:  r.unpublish,
+		},
+		{
+			name: "cleanup NodeUnstageVolume",
+			kind: "unmount",
+			run:  r.unstage,
+		},
+		{
+			name: fmt.Sprintf("detach from %q", r.instanceName),
+			kind: "detach",
+			run:  func(ctx context.Context) error { return detachDiskWithRetry(ctx, r.pdName, r.instanceName) },
+		},
+		{
+			name: "delete",
+			kind: "delete",
+			run:  func(ctx context.Context) error { return deletePDWithRetry(ctx, r.pdName) },
+		},
+	}
+}
+
+// idempotent returns a step making call and a step repeating it, each
+// followed by check.
+func (r *csiRun) idempotent(name, kind string, call, check func(ctx context.Context) error) []step {
+	run := func(ctx context.Context) error {
+		if err := call(ctx); err != nil {
+			return err
+		}
+		return check(ctx)
+	}
+	return []step{
+		{name: name, kind: kind, run: run},
+		{name: name + " (repeat)", kind: kind, run: run},
+	}
+}
+
+// csc runs a csc node command against the plugin's endpoint on the instance.
+func (r *csiRun) csc(ctx context.Context, command string, args ...string) ([]byte, error) {
+	remoteCommand := fmt.Sprintf("%s node %s --endpoint %s %s", *csiCSC, command, *csiEndpoint, strings.Join(args, " "))
+	outputBytes, cmdErr := runOnInstance(ctx, remoteCommand, r.instanceName)
+	if cmdErr != nil {
+		log.Printf(
+			"csc node %s on %q failed. error: %v\r\n",
+			command,
+			r.instanceName,
+			cmdErr)
+	}
+	return outputBytes, cmdErr
+}
+
+func (r *csiRun) hasCapability(capability string) bool {
+	for _, c := range strings.Fields(r.capabilities) {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *csiRun) volumeCapability() string {
+	return "SINGLE_NODE_WRITER,mount," + testFSType
+}
+
+func (r *csiRun) stage(ctx context.Context) error {
+	_, err := r.csc(ctx, "stage",
+		"--staging-target-path", r.stagingPath,
+		"--cap", r.volumeCapability(),
+		r.volumeID)
+	return err
+}
+
+func (r *csiRun) publish(ctx context.Context) error {
+	_, err := r.csc(ctx, "publish",
+		"--staging-target-path", r.stagingPath,
+		"--target-path", r.targetPath,
+		"--cap", r.volumeCapability(),
+		r.volumeID)
+	return err
+}
+
+func (r *csiRun) unpublish(ctx context.Context) error {
+	_, err := r.csc(ctx, "unpublish", "--target-path", r.targetPath, r.volumeID)
+	return err
+}
+
+func (r *csiRun) unstage(ctx context.Context) error {
+	_, err := r.csc(ctx, "unstage", "--staging-target-path", r.stagingPath, r.volumeID)
+	return err
+}
+
+// singleMount returns the mount at mountPath, failing if there is none or if
+// a repeated call stacked another mount on top of it.
+func singleMount(infos []mountInfo, mountPath string) (*mountInfo, error) {
+	count := 0
+	for _, info := range infos {
+		if info.MountPoint == strings.TrimSuffix(mountPath, "/") {
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, fmt.Errorf("found %d mounts at %q, expected 1", count, mountPath)
+	}
+	return findMountInfo(infos, mountPath), nil
+}
+
+func (r *csiRun) checkStaged(ctx context.Context) error {
+	infos, err := getMountInfo(ctx, r.instanceName)
+	if err != nil {
+		return err
+	}
+	staged, err := singleMount(infos, r.stagingPath)
+	if err != nil {
+		return err
+	}
+	if staged.FSType != testFSType {
+		return fmt.Errorf("staging path %q has filesystem %q, expected %q", r.stagingPath, staged.FSType, testFSType)
+	}
+	return nil
+}
+
+func (r *csiRun) checkPublished(ctx context.Context) error {
+	infos, err := getMountInfo(ctx, r.instanceName)
+	if err != nil {
+		return err
+	}
+	staged, err := singleMount(infos, r.stagingPath)
+	if err != nil {
+		return err
+	}
+	published, err := singleMount(infos, r.targetPath)
+	if err != nil {
+		return err
+	}
+	if published.Source != staged.Source || published.Root != staged.Root {
+		return fmt.Errorf("target path %q mounts %s%s, expected the staged %s%s", r.targetPath, published.Source, published.Root, staged.Source, staged.Root)
+	}
+	return nil
+}
+
+func (r *csiRun) checkUnmounted(mountPath string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		infos, err := getMountInfo(ctx, r.instanceName)
+		if err != nil {
+			return err
+		}
+		if info := findMountInfo(infos, mountPath); info != nil {
+			return fmt.Errorf("%q is still mounted from %s", mountPath, info.Source)
+		}
+		return nil
+	}
+}
+
+// checkStats verifies that the plugin reports a byte capacity close to the
+// disk size. Filesystem overhead accounts for the difference.
+func (r *csiRun) checkStats(ctx context.Context) error {
+	if !r.hasCapability(csiCapStats) {
+		r.detail = "not supported"
+		return nil
+	}
+	output, err := r.csc(ctx, "stats",
+		"--format", `'{{range .Usage}}{{.Unit}} {{.Total}}{{"\n"}}{{end}}'`,
+		r.volumeID+":"+r.targetPath+":"+r.stagingPath)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "BYTES" {
+			continue
+		}
+		total, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing NodeGetVolumeStats output %q failed: %v", line, err)
+		}
+		size := int64(*csiSizeGB) << 30
+		if total > size || total < size*9/10 {
+			return fmt.Errorf("NodeGetVolumeStats reports %s total, expected about %s", formatBytes(total), formatBytes(size))
+		}
+		r.detail = formatBytes(total) + " total"
+		return nil
+	}
+	return fmt.Errorf("NodeGetVolumeStats reported no byte usage: %q", output)
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+)
+
+// fakeCSINode is a CSI node plugin answering csc node commands on a
+// fakeInstance. Its bug fields break the idempotency or mount behavior the
+// csi subcommand checks.
+type fakeCSINode struct {
+	capabilities []string
+	// stackRepeatedStage mounts the volume again on a repeated stage.
+	stackRepeatedStage bool
+	// stackRepeatedPublish bind-mounts again on a repeated publish.
+	stackRepeatedPublish bool
+	// failRepeatedUnpublish returns NotFound for an unpublished target.
+	failRepeatedUnpublish bool
+	// failRepeatedUnstage returns NotFound for an unstaged volume.
+	failRepeatedUnstage bool
+	// publishWrongSource bind-mounts a different device to the target.
+	publishWrongSource bool
+	// keepPublished reports success for unpublish without unmounting.
+	keepPublished bool
+}
+
+func newFakeCSINode() *fakeCSINode {
+	return &fakeCSINode{capabilities: []string{csiCapStageUnstage, csiCapStats, csiCapExpand}}
+}
+
+// cscArgs splits csc arguments into flags and positional arguments. The
+// quoted --format template of stats is skipped.
+func cscArgs(args []string) (map[string]string, []string) {
+	flags := make(map[string]string)
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--format":
+			for i++; i < len(args) && !strings.HasSuffix(args[i], "'"); i++ {
+			}
+		case strings.HasPrefix(args[i], "--") && i+1 < len(args):
+			flags[args[i]] = args[i+1]
+			i++
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+	return flags, positional
+}
+
+func (n *fakeCSINode) handle(inst *fakeInstance, command string, args []string) (string, error) {
+	flags, positional := cscArgs(args)
+	if flags["--endpoint"] != *csiEndpoint {
+		return "connection refused", fmt.Errorf("unexpected endpoint %q", flags["--endpoint"])
+	}
+	if command == "get-capabilities" {
+		return strings.Join(n.capabilities, "\n") + "\n", nil
+	}
+	// expand also takes the volume path after the volume ID.
+	if len(positional) == 0 {
+		return "missing volume ID", fmt.Errorf("bad arguments %v", args)
+	}
+	volumeID := positional[0]
+	pdName := path.Base(volumeID)
+	stagingPath, targetPath := flags["--staging-target-path"], flags["--target-path"]
+
+	switch command {
+	case "stage":
+		if !inst.provider.attached(pdName, inst.name) {
+			return fmt.Sprintf("rpc error: code = NotFound desc = device for %s not found", volumeID), fmt.Errorf("not attached")
+		}
+		if _, ok := inst.lookupMount(stagingPath); ok && !n.stackRepeatedStage {
+			return "", nil
+		}
+		inst.addMount(fakeMount{mountPoint: stagingPath, root: "/", fsType: testFSType, source: getPDDevPath(pdName)})
+		return "", nil
+	case "publish":
+		staged, ok := inst.lookupMount(stagingPath)
+		if !ok {
+			return "rpc error: code = FailedPrecondition desc = volume is not staged", fmt.Errorf("not staged")
+		}
+		if _, ok := inst.lookupMount(targetPath); ok && !n.stackRepeatedPublish {
+			return "", nil
+		}
+		if n.publishWrongSource {
+			staged.source = "/dev/sdz"
+		}
+		staged.mountPoint = targetPath
+		inst.addMount(staged)
+		return "", nil
+	case "unpublish":
+		if _, ok := inst.lookupMount(targetPath); !ok {
+			if n.failRepeatedUnpublish {
+				return "rpc error: code = NotFound desc = target path not found", fmt.Errorf("not found")
+			}
+			return "", nil
+		}
+		if !n.keepPublished {
+			inst.removeMount(targetPath)
+		}
+		return "", nil
+	case "unstage":
+		if _, ok := inst.lookupMount(stagingPath); !ok {
+			if n.failRepeatedUnstage {
+				return "rpc error: code = NotFound desc = staging path not found", fmt.Errorf("not found")
+			}
+			return "", nil
+		}
+		inst.removeMount(stagingPath)
+		return "", nil
+	case "stats":
+		return fmt.Sprintf("BYTES %d\nINODES 655360\n", int64(*csiSizeGB)<<30*95/100), nil
+	case "expand":
+		return "", nil
+	}
+	return "unknown command", fmt.Errorf("unknown csc command %q", command)
+}
+
+// runFakeCSI runs the csi subcommand's steps against node and returns the
+// step results, the run error and the provider for inspection.
+func runFakeCSI(t *testing.T, node *fakeCSINode) ([]stepResult, error, *fakeProvider) {
+	t.Helper()
+	p := newFakeProvider()
+	useProvider(t, p)
+	p.instance("node-a").csc = node.handle
+
+	r := newCSIRun(generatePdName(), "node-a")
+	results, err := executeSteps(context.Background(), r.steps(), r.cleanupSteps(), &r.detail, r.pdName, []string{r.instanceName})
+	if len(p.volumes) != 0 {
+		t.Errorf("cleanup left volumes behind: %v", p.volumes)
+	}
+	return results, err, p
+}
+
+func TestCSIRunPasses(t *testing.T) {
+	results, err, p := runFakeCSI(t, newFakeCSINode())
+	if err != nil {
+		t.Fatalf("CSI run against a conforming plugin failed: %v", err)
+	}
+	repeated := 0
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("step %q failed: %v", r.Name, r.Err)
+		}
+		if strings.HasSuffix(r.Name, " (repeat)") {
+			repeated++
+		}
+	}
+	if repeated != 4 {
+		t.Errorf("got %d repeated calls, expected one each for stage, publish, unpublish and unstage", repeated)
+	}
+	if mounts := p.instance("node-a").mounts; len(mounts) != 0 {
+		t.Errorf("run left mounts behind: %v", mounts)
+	}
+}
+
+func TestCSIRunDetectsNonConformingPlugins(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		bug        func(n *fakeCSINode)
+		failedStep string
+		errSubstr  string
+	}{
+		{
+			name:       "repeated stage stacks a mount",
+			bug:        func(n *fakeCSINode) { n.stackRepeatedStage = true },
+			failedStep: "NodeStageVolume (repeat)",
+			errSubstr:  "found 2 mounts",
+		},
+		{
+			name:       "repeated publish stacks a mount",
+			bug:        func(n *fakeCSINode) { n.stackRepeatedPublish = true },
+			failedStep: "NodePublishVolume (repeat)",
+			errSubstr:  "found 2 mounts",
+		},
+		{
+			name:       "repeated unpublish fails",
+			bug:        func(n *fakeCSINode) { n.failRepeatedUnpublish = true },
+			failedStep: "NodeUnpublishVolume (repeat)",
+			errSubstr:  "NotFound",
+		},
+		{
+			name:       "repeated unstage fails",
+			bug:        func(n *fakeCSINode) { n.failRepeatedUnstage = true },
+			failedStep: "NodeUnstageVolume (repeat)",
+			errSubstr:  "NotFound",
+		},
+		{
+			name:       "publish mounts another device",
+			bug:        func(n *fakeCSINode) { n.publishWrongSource = true },
+			failedStep: "NodePublishVolume",
+			errSubstr:  "expected the staged",
+		},
+		{
+			name:       "unpublish leaves the target mounted",
+			bug:        func(n *fakeCSINode) { n.keepPublished = true },
+			failedStep: "NodeUnpublishVolume",
+			errSubstr:  "is still mounted",
+		},
+		{
+			name:       "no stage support",
+			bug:        func(n *fakeCSINode) { n.capabilities = []string{csiCapStats} },
+			failedStep: "NodeGetCapabilities",
+			errSubstr:  "does not support " + csiCapStageUnstage,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node := newFakeCSINode()
+			tc.bug(node)
+			results, err, _ := runFakeCSI(t, node)
+			if err == nil {
+				t.Fatalf("CSI run passed, expected step %q to fail", tc.failedStep)
+			}
+			var failed *stepResult
+			for i := range results {
+				if results[i].Err != nil {
+					failed = &results[i]
+					break
+				}
+			}
+			if failed == nil || failed.Name != tc.failedStep {
+				t.Fatalf("first failed step is %+v, expected %q", failed, tc.failedStep)
+			}
+			if !strings.Contains(failed.Err.Error(), tc.errSubstr) {
+				t.Errorf("step %q failed with %v, expected it to mention %q", failed.Name, failed.Err, tc.errSubstr)
+			}
+		})
+	}
+}
+
+func TestSingleMount(t *testing.T) {
+	infos := []mountInfo{
+		{MountPoint: "/mnt/a", Source: "/dev/sdb"},
+		{MountPoint: "/mnt/b", Source: "/dev/sdc"},
+		{MountPoint: "/mnt/b", Source: "/dev/sdc"},
+	}
+	if info, err := singleMount(infos, "/mnt/a/"); err != nil || info.Source != "/dev/sdb" {
+		t.Errorf("singleMount(/mnt/a/) = %+v, %v, expected the /dev/sdb mount", info, err)
+	}
+	if _, err := singleMount(infos, "/mnt/b"); err == nil {
+		t.Errorf("singleMount(/mnt/b) accepted a stacked mount")
+	}
+	if _, err := singleMount(infos, "/mnt/c"); err == nil {
+		t.Errorf("singleMount(/mnt/c) accepted a missing mount")
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	diskType              = flag.String("disk-type", "", "Disk type of a plain run, e.g. pd-ssd. Empty uses the provider default.")
+	diskSizeGB            = flag.Int("disk-size-gb", 10, "Disk size of a plain run in GB.")
+	diskImage             = flag.String("disk-image", "", "Image the disk of a plain run is created from. It must hold a filesystem on the whole device.")
+	diskSnapshot          = flag.String("disk-snapshot", "", "Snapshot the disk of a plain run is created from.")
+	diskLabels            = flag.String("disk-labels", "", "Comma separated key=value labels of the disk of a plain run.")
+	diskPhysicalBlockSize = flag.Int("disk-physical-block-size", 0, "Physical block size of the disk of a plain run in bytes, 4096 or 16384. 0 uses the provider default.")
+	diskKMSKey            = flag.String("disk-kms-key", "", "Resource name of the customer-managed key the disk of a plain run is encrypted with.")
+)
+
+// diskSpecFromFlags returns the disk of a plain run.
+func diskSpecFromFlags() (diskSpec, error) {
+	spec := diskSpec{
+		Type:                   *diskType,
+		SizeGB:                 *diskSizeGB,
+		Image:                  *diskImage,
+		Snapshot:               *diskSnapshot,
+		PhysicalBlockSizeBytes: *diskPhysicalBlockSize,
+		KMSKey:                 *diskKMSKey,
+	}
+	if *diskLabels != "" {
+		spec.Labels = make(map[string]string)
+		for _, pair := range strings.Split(*diskLabels, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return spec, fmt.Errorf("invalid -disk-labels entry %q, must be key=value", pair)
+			}
+			spec.Labels[key] = value
+		}
+	}
+	zones, err := replicaZonesFromFlags()
+	if err != nil {
+		return spec, err
+	}
+	spec.ReplicaZones = zones
+	return spec, spec.validate()
+}
+
+// GCE label keys start with a lowercase letter; keys and values are at most
+// 63 lowercase letters, digits, underscores and dashes.
+var (
+	labelKeyRE   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueRE = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+func (d diskSpec) validate() error {
+	if d.SizeGB < 1 {
+		return fmt.Errorf("invalid disk size %dGB", d.SizeGB)
+	}
+	if d.Image != "" && d.Snapshot != "" {
+		return fmt.Errorf("a disk can be created from an image or a snapshot, not both")
+	}
+	switch d.PhysicalBlockSizeBytes {
+	case 0, 4096, 16384:
+	default:
+		return fmt.Errorf("invalid physical block size %d, must be 4096 or 16384", d.PhysicalBlockSizeBytes)
+	}
+	for key, value := range d.Labels {
+		if !labelKeyRE.MatchString(key) {
+			return fmt.Errorf("invalid disk label key %q", key)
+		}
+		if key == runLabelKey {
+			return fmt.Errorf("disk label %q is reserved for the run ID, use -run-id to set it", key)
+		}
+		if !labelValueRE.MatchString(value) {
+			return fmt.Errorf("invalid value %q of disk label %q", value, key)
+		}
+	}
+	return validateReplicaZones(d.ReplicaZones)
+}
+
+// verifyBlockDevice checks that devPath on instanceName has at least the size
+// of spec and its physical block size, as reported by both lsblk and
+// blockdev. Providers may round the size up. The physical block size is only
+// checked when spec sets one.
+func verifyBlockDevice(ctx context.Context, devPath string, spec diskSpec, instanceName string) error {
+	log.Printf("Verifying block device %q on %q\r\n", devPath, instanceName)
+	defer fmt.Println("------------")
+
+	remoteCommand := fmt.Sprintf("lsblk -b -d -n -o SIZE,PHY-SEC %[1]s && blockdev --getsize64 --getpbsz %[1]s", devPath)
+	outputBytes, cmdErr := runOnInstance(ctx, remoteCommand, instanceName)
+	if cmdErr != nil {
+		log.Printf(
+			"Inspecting block device %q on %q failed. error: %v\r\n",
+			devPath,
+			instanceName,
+			cmdErr)
+		return cmdErr
+	}
+
+	var values []int64
+	for _, field := range strings.Fields(string(outputBytes)) {
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing lsblk/blockdev output %q failed: %v", string(outputBytes), err)
+		}
+		values = append(values, value)
+	}
+	if len(values) != 4 {
+		return fmt.Errorf("unexpected lsblk/blockdev output %q", string(outputBytes))
+	}
+	lsblkSize, lsblkBlockSize, size, blockSize := values[0], values[1], values[2], values[3]
+	if lsblkSize != size || lsblkBlockSize != blockSize {
+		return fmt.Errorf("lsblk reports %d bytes with %d byte blocks, blockdev %d bytes with %d byte blocks", lsblkSize, lsblkBlockSize, size, blockSize)
+	}
+
+	if wantSize := int64(spec.SizeGB) << 30; size < wantSize {
+		return fmt.Errorf("block device %q is %d bytes, expected at least %d (%dGB)", devPath, size, wantSize, spec.SizeGB)
+	}
+	if spec.PhysicalBlockSizeBytes != 0 && blockSize != int64(spec.PhysicalBlockSizeBytes) {
+		return fmt.Errorf("block device %q has %d byte physical blocks, expected %d", devPath, blockSize, spec.PhysicalBlockSizeBytes)
+	}
+	log.Printf("Block device %q is %d bytes with %d byte physical blocks\r\n", devPath, size, blockSize)
+	return nil
+}
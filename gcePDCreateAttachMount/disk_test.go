@@ -0,0 +1,101 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDiskSpecValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec diskSpec
+		ok   bool
+	}{
+		{name: "minimal", spec: diskSpec{SizeGB: 1}, ok: true},
+		{name: "zero size", spec: diskSpec{SizeGB: 0}},
+		{name: "negative size", spec: diskSpec{SizeGB: -10}},
+		{name: "image", spec: diskSpec{SizeGB: 10, Image: "projects/p/global/images/i"}, ok: true},
+		{name: "image and snapshot", spec: diskSpec{SizeGB: 10, Image: "i", Snapshot: "s"}},
+		{name: "default block size", spec: diskSpec{SizeGB: 10, PhysicalBlockSizeBytes: 0}, ok: true},
+		{name: "4096 byte blocks", spec: diskSpec{SizeGB: 10, PhysicalBlockSizeBytes: 4096}, ok: true},
+		{name: "16384 byte blocks", spec: diskSpec{SizeGB: 10, PhysicalBlockSizeBytes: 16384}, ok: true},
+		{name: "512 byte blocks", spec: diskSpec{SizeGB: 10, PhysicalBlockSizeBytes: 512}},
+		{name: "8192 byte blocks", spec: diskSpec{SizeGB: 10, PhysicalBlockSizeBytes: 8192}},
+		{name: "labels", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"team": "storage", "cost_center": "", "env-2": "e2e_test-1"}}, ok: true},
+		{name: "label key with uppercase", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"Team": "storage"}}},
+		{name: "label key starting with a digit", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"1team": "storage"}}},
+		{name: "empty label key", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"": "storage"}}},
+		{name: "label key too long", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"a" + strings.Repeat("b", 63): "x"}}},
+		{name: "longest label key", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"a" + strings.Repeat("b", 62): "x"}}, ok: true},
+		{name: "label value with a dot", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"team": "storage.io"}}},
+		{name: "label value too long", spec: diskSpec{SizeGB: 10, Labels: map[string]string{"team": strings.Repeat("a", 64)}}},
+		{name: "reserved run label", spec: diskSpec{SizeGB: 10, Labels: map[string]string{runLabelKey: "test-run"}}},
+		{name: "replica zones", spec: diskSpec{SizeGB: 10, ReplicaZones: []string{"us-central1-a", "us-central1-b"}}, ok: true},
+		{name: "one replica zone", spec: diskSpec{SizeGB: 10, ReplicaZones: []string{"us-central1-a"}}},
+		{name: "replica zones in different regions", spec: diskSpec{SizeGB: 10, ReplicaZones: []string{"us-central1-a", "europe-west1-b"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.spec.validate(); (err == nil) != tc.ok {
+				t.Errorf("validate() = %v, expected ok=%v", err, tc.ok)
+			}
+		})
+	}
+}
+
+// blockDeviceProvider answers the lsblk and blockdev commands of
+// verifyBlockDevice with canned output.
+type blockDeviceProvider struct {
+	*fakeProvider
+	output string
+}
+
+func (p *blockDeviceProvider) RunOnInstance(ctx context.Context, command, instanceName string) ([]byte, error) {
+	return []byte(p.output), nil
+}
+
+func TestVerifyBlockDevice(t *testing.T) {
+	spec := diskSpec{SizeGB: 10, PhysicalBlockSizeBytes: 4096}
+	for _, tc := range []struct {
+		name   string
+		output string
+		ok     bool
+	}{
+		{name: "exact size", output: "10737418240 4096\n10737418240\n4096\n", ok: true},
+		{name: "rounded up", output: "10738466816 4096\n10738466816\n4096\n", ok: true},
+		{name: "too small", output: "10736369664 4096\n10736369664\n4096\n"},
+		{name: "wrong block size", output: "10737418240 16384\n10737418240\n16384\n"},
+		{name: "lsblk and blockdev disagree", output: "10737418240 4096\n21474836480\n4096\n"},
+		{name: "truncated output", output: "10737418240 4096\n"},
+		{name: "garbage", output: "lsblk: /dev/sdb: not a block device\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useProvider(t, &blockDeviceProvider{fakeProvider: newFakeProvider(), output: tc.output})
+			if err := verifyBlockDevice(context.Background(), "/dev/sdb", spec, "node-a"); (err == nil) != tc.ok {
+				t.Errorf("verifyBlockDevice() = %v, expected ok=%v", err, tc.ok)
+			}
+		})
+	}
+
+	// Without a requested block size any physical block size passes.
+	useProvider(t, &blockDeviceProvider{fakeProvider: newFakeProvider(), output: "10737418240 512\n10737418240\n512\n"})
+	if err := verifyBlockDevice(context.Background(), "/dev/sdb", diskSpec{SizeGB: 10}, "node-a"); err != nil {
+		t.Errorf("verifyBlockDevice() without a block size = %v", err)
+	}
+}
@@ -0,0 +1,660 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ebsFakeState        = flag.String("ebs-fake-state", "ebs-fake.json", "File the fake EBS API keeps its volumes in, so runs, resumes and teardowns share them.")
+	ebsTransitionDelay  = flag.Duration("ebs-transition-delay", 2*time.Second, "How long the fake EBS API keeps volumes and attachments in creating, attaching, detaching and deleting.")
+	ebsAttachLimit      = flag.Int("ebs-attach-limit", 27, "Volumes the fake EBS API lets a single instance attach.")
+	ebsDeviceNaming     = flag.String("ebs-device-naming", "nvme", "How attached EBS volumes show up on instances: nvme for /dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_<id>, or xvd for /dev/xvdX.")
+	ebsSSH              = flag.String("ebs-ssh", "ssh", "Command the EBS provider runs instance commands with, as <ssh> root@<instance> <command>.")
+	ebsSCP              = flag.String("ebs-scp", "scp", "Command the EBS provider copies files to instances with, as <scp> <local> root@<instance>:<remote>.")
+	ebsPollInterval     = flag.Duration("ebs-poll-interval", time.Second, "Interval between EBS volume state polls.")
+	ebsOperationTimeout = flag.Duration("ebs-operation-timeout", 5*time.Minute, "How long to wait for an EBS volume or attachment to leave a transient state.")
+)
+
+// EBS volume and attachment states.
+const (
+	ebsStateCreating  = "creating"
+	ebsStateAvailable = "available"
+	ebsStateInUse     = "in-use"
+	ebsStateDeleting  = "deleting"
+
+	ebsAttaching = "attaching"
+	ebsAttached  = "attached"
+	ebsDetaching = "detaching"
+)
+
+const ebsDefaultVolumeType = "gp3"
+
+// ebsMultiAttachType reports whether volumes of volumeType can be attached to
+// several instances at once.
+func ebsMultiAttachType(volumeType string) bool {
+	return volumeType == "io1" || volumeType == "io2"
+}
+
+type ebsVolume struct {
+	VolumeID   string
+	Size       int
+	VolumeType string
+	State      string
+	// MultiAttachEnabled volumes can be attached to several instances at
+	// once. Only io1 and io2 volumes support it.
+	MultiAttachEnabled bool
+	Tags               map[string]string
+	Attachments        []ebsAttachment
+	// TransitionAt is when the fake API moves the volume out of a transient
+	// state.
+	TransitionAt time.Time
+}
+
+type ebsAttachment struct {
+	InstanceID string
+	// Device is the name the volume was attached as, e.g. /dev/sdf.
+	Device       string
+	State        string
+	TransitionAt time.Time
+}
+
+// ebsAPI is the subset of the EC2 API the EBS provider uses. Calls return
+// as soon as the request is accepted; volumes and attachments then go
+// through transient states the caller has to poll for.
+type ebsAPI interface {
+	CreateVolume(size int, volumeType string, tags map[string]string) (*ebsVolume, error)
+	DeleteVolume(volumeID string) error
+	AttachVolume(volumeID, instanceID, device string) error
+	DetachVolume(volumeID, instanceID string) error
+	DescribeVolumes() ([]ebsVolume, error)
+}
+
+// fakeEBSAPI is a local stand-in for EC2 that keeps its volumes in a JSON
+// file. It enforces the EBS rules the lifecycle depends on and answers with
+// EC2 error codes, so the EBS provider can be exercised offline.
+type fakeEBSAPI struct {
+	path            string
+	transitionDelay time.Duration
+	attachLimit     int
+	mu              sync.Mutex
+}
+
+// update loads the volumes, settles transitions that are due, lets fn change
+// them and saves them again if fn succeeded.
+func (f *fakeEBSAPI) update(fn func(volumes map[string]*ebsVolume) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	volumes := make(map[string]*ebsVolume)
+	data, err := os.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading fake EBS state %q failed: %v", f.path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &volumes); err != nil {
+			return fmt.Errorf("parsing fake EBS state %q failed: %v", f.path, err)
+		}
+	}
+
+	f.settle(volumes, time.Now())
+	if err := fn(volumes); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(volumes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing fake EBS state %q failed: %v", tmp, err)
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// settle completes every transition that is due at now.
+func (f *fakeEBSAPI) settle(volumes map[string]*ebsVolume, now time.Time) {
+	for id, volume := range volumes {
+		var attachments []ebsAttachment
+		for _, a := range volume.Attachments {
+			if !now.Before(a.TransitionAt) {
+				if a.State == ebsDetaching {
+					continue
+				}
+				a.State = ebsAttached
+			}
+			attachments = append(attachments, a)
+		}
+		volume.Attachments = attachments
+
+		switch {
+		case volume.State == ebsStateDeleting && !now.Before(volume.TransitionAt):
+			delete(volumes, id)
+		case volume.State == ebsStateCreating && !now.Before(volume.TransitionAt):
+			volume.State = ebsStateAvailable
+		case volume.State == ebsStateAvailable && len(volume.Attachments) > 0:
+			volume.State = ebsStateInUse
+		case volume.State == ebsStateInUse && len(volume.Attachments) == 0:
+			volume.State = ebsStateAvailable
+		}
+	}
+}
+
+func (f *fakeEBSAPI) CreateVolume(size int, volumeType string, tags map[string]string) (*ebsVolume, error) {
+	suffix := make([]byte, 9)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	volume := &ebsVolume{
+		VolumeID:           "vol-" + hex.EncodeToString(suffix)[:17],
+		Size:               size,
+		VolumeType:         volumeType,
+		State:              ebsStateCreating,
+		MultiAttachEnabled: ebsMultiAttachType(volumeType),
+		Tags:               tags,
+		TransitionAt:       time.Now().Add(f.transitionDelay),
+	}
+	err := f.update(func(volumes map[string]*ebsVolume) error {
+		volumes[volume.VolumeID] = volume
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+func (f *fakeEBSAPI) DeleteVolume(volumeID string) error {
+	return f.update(func(volumes map[string]*ebsVolume) error {
+		volume, ok := volumes[volumeID]
+		if !ok {
+			return fmt.Errorf("InvalidVolume.NotFound: The volume '%s' does not exist.", volumeID)
+		}
+		if len(volume.Attachments) > 0 {
+			return fmt.Errorf("VolumeInUse: Volume %s is currently attached to %s", volumeID, volume.Attachments[0].InstanceID)
+		}
+		if volume.State != ebsStateAvailable {
+			return fmt.Errorf("IncorrectState: The volume '%s' is '%s'", volumeID, volume.State)
+		}
+		volume.State = ebsStateDeleting
+		volume.TransitionAt = time.Now().Add(f.transitionDelay)
+		return nil
+	})
+}
+
+func (f *fakeEBSAPI) AttachVolume(volumeID, instanceID, device string) error {
+	return f.update(func(volumes map[string]*ebsVolume) error {
+		volume, ok := volumes[volumeID]
+		if !ok {
+			return fmt.Errorf("InvalidVolume.NotFound: The volume '%s' does not exist.", volumeID)
+		}
+		if volume.State != ebsStateAvailable && volume.State != ebsStateInUse {
+			return fmt.Errorf("IncorrectState: vol '%s' is not 'available'.", volumeID)
+		}
+		for _, a := range volume.Attachments {
+			if a.InstanceID == instanceID {
+				return fmt.Errorf("VolumeInUse: %s is already attached to an instance", volumeID)
+			}
+		}
+		if len(volume.Attachments) > 0 && !volume.MultiAttachEnabled {
+			return fmt.Errorf("VolumeInUse: %s is already attached to an instance", volumeID)
+		}
+
+		onInstance := 0
+		for _, other := range volumes {
+			for _, a := range other.Attachments {
+				if a.InstanceID != instanceID {
+					continue
+				}
+				onInstance++
+				if a.Device == device {
+					return fmt.Errorf("InvalidParameterValue: Invalid value '%s' for unixDevice. Attachment point %s is already in use", device, device)
+				}
+			}
+		}
+		if onInstance >= f.attachLimit {
+			return fmt.Errorf("AttachmentLimitExceeded: instance %s already has %d volumes attached", instanceID, onInstance)
+		}
+
+		volume.Attachments = append(volume.Attachments, ebsAttachment{
+			InstanceID:   instanceID,
+			Device:       device,
+			State:        ebsAttaching,
+			TransitionAt: time.Now().Add(f.transitionDelay),
+		})
+		return nil
+	})
+}
+
+func (f *fakeEBSAPI) DetachVolume(volumeID, instanceID string) error {
+	return f.update(func(volumes map[string]*ebsVolume) error {
+		volume, ok := volumes[volumeID]
+		if !ok {
+			return fmt.Errorf("InvalidVolume.NotFound: The volume '%s' does not exist.", volumeID)
+		}
+		for i := range volume.Attachments {
+			a := &volume.Attachments[i]
+			if a.InstanceID != instanceID {
+				continue
+			}
+			if a.State != ebsAttached {
+				return fmt.Errorf("IncorrectState: Volume '%s' is %s on instance '%s'", volumeID, a.State, instanceID)
+			}
+			a.State = ebsDetaching
+			a.TransitionAt = time.Now().Add(f.transitionDelay)
+			return nil
+		}
+		return fmt.Errorf("IncorrectState: Volume '%s' is not attached to instance '%s'", volumeID, instanceID)
+	})
+}
+
+func (f *fakeEBSAPI) DescribeVolumes() ([]ebsVolume, error) {
+	var described []ebsVolume
+	err := f.update(func(volumes map[string]*ebsVolume) error {
+		for _, volume := range volumes {
+			described = append(described, *volume)
+		}
+		return nil
+	})
+	return described, err
+}
+
+// ebsProvider gives the lifecycle EBS semantics: volumes have generated IDs
+// and are found by their Name tag, the device name is picked at attach time,
+// and every call has to wait for the volume or attachment to settle.
+type ebsProvider struct {
+	api ebsAPI
+	// attachMu serializes device name selection and attach requests, so
+	// parallel attaches to one instance do not pick the same name.
+	attachMu sync.Mutex
+}
+
+func newEBSProvider() (VolumeProvider, error) {
+	if *ebsDeviceNaming != "nvme" && *ebsDeviceNaming != "xvd" {
+		return nil, fmt.Errorf("unknown -ebs-device-naming %q, must be nvme or xvd", *ebsDeviceNaming)
+	}
+	return &ebsProvider{
+		api: &fakeEBSAPI{
+			path:            *ebsFakeState,
+			transitionDelay: *ebsTransitionDelay,
+			attachLimit:     *ebsAttachLimit,
+		},
+	}, nil
+}
+
+func (p *ebsProvider) Name() string {
+	return "ebs"
+}
+
+// findVolume returns the volume tagged with name.
+func (p *ebsProvider) findVolume(name string) (*ebsVolume, error) {
+	volumes, err := p.api.DescribeVolumes()
+	if err != nil {
+		return nil, err
+	}
+	for i := range volumes {
+		if volumes[i].Tags["Name"] == name && volumes[i].State != ebsStateDeleting {
+			return &volumes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("InvalidVolume.NotFound: no volume named %q", name)
+}
+
+func (p *ebsProvider) attachment(volume *ebsVolume, instanceName string) *ebsAttachment {
+	for i := range volume.Attachments {
+		if volume.Attachments[i].InstanceID == instanceName {
+			return &volume.Attachments[i]
+		}
+	}
+	return nil
+}
+
+// waitFor polls done until it reports true, fails, or the EBS operation
+// timeout passes.
+func (p *ebsProvider) waitFor(what string, done func() (bool, error)) error {
+	for start := time.Now(); time.Since(start) < *ebsOperationTimeout; time.Sleep(*ebsPollInterval) {
+		ok, err := done()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("waiting for %s: %w", what, errOperationTimeout)
+}
+
+func (p *ebsProvider) CreateVolume(ctx context.Context, name string, spec diskSpec) error {
+	log.Printf("Attempting to create EBS volume %q with spec %+v\r\n", name, spec)
+	defer fmt.Println("------------")
+
+	start := time.Now()
+	err := p.createVolume(name, spec)
+	metrics.observeOperation("create", start, err)
+	if err != nil {
+		log.Printf(
+			"Creating EBS volume %q failed with %v\r\n",
+			name,
+			err)
+		return err
+	}
+	log.Printf("Created EBS volume %q successfully\r\n", name)
+	return nil
+}
+
+func (p *ebsProvider) createVolume(name string, spec diskSpec) error {
+	if len(spec.ReplicaZones) > 0 {
+		return fmt.Errorf("InvalidParameterValue: EBS volumes cannot be replicated across zones")
+	}
+	if spec.Image != "" || spec.PhysicalBlockSizeBytes != 0 {
+		return fmt.Errorf("InvalidParameterValue: EBS volumes have no image source or configurable block size")
+	}
+	if spec.Snapshot != "" || spec.KMSKey != "" {
+		return fmt.Errorf("InvalidParameterValue: the fake EBS API does not support snapshot sources or KMS keys")
+	}
+	// EBS does not enforce unique names, so check here to behave like GCE.
+	if volume, err := p.findVolume(name); err == nil {
+		return fmt.Errorf("volume %q already exists as %s", name, volume.VolumeID)
+	}
+	volumeType := spec.Type
+	if volumeType == "" {
+		volumeType = ebsDefaultVolumeType
+	}
+	// Labels become tags, next to the Name tag volumes are looked up by.
+	tags := map[string]string{"Name": name}
+	for key, value := range spec.Labels {
+		tags[key] = value
+	}
+	volume, err := p.api.CreateVolume(spec.SizeGB, volumeType, tags)
+	if err != nil {
+		return err
+	}
+	return p.waitFor("volume "+volume.VolumeID+" to become available", func() (bool, error) {
+		volume, err := p.findVolume(name)
+		if err != nil {
+			return false, err
+		}
+		return volume.State == ebsStateAvailable, nil
+	})
+}
+
+func (p *ebsProvider) DeleteVolume(ctx context.Context, name string) error {
+	log.Printf("Attempting to delete EBS volume %q\r\n", name)
+	defer fmt.Println("------------")
+
+	start := time.Now()
+	err := p.deleteVolume(name)
+	metrics.observeOperation("delete", start, err)
+	if err != nil {
+		log.Printf(
+			"Deleting EBS volume %q failed with %v\r\n",
+			name,
+			err)
+		return err
+	}
+	log.Printf("Deleted EBS volume %q\r\n", name)
+	return nil
+}
+
+func (p *ebsProvider) deleteVolume(name string) error {
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return err
+	}
+	if err := p.api.DeleteVolume(volume.VolumeID); err != nil {
+		return err
+	}
+	return p.waitFor("volume "+volume.VolumeID+" to be deleted", func() (bool, error) {
+		volumes, err := p.api.DescribeVolumes()
+		if err != nil {
+			return false, err
+		}
+		for _, v := range volumes {
+			if v.VolumeID == volume.VolumeID {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+func (p *ebsProvider) AttachVolume(ctx context.Context, name, instanceName string, readOnly bool) error {
+	log.Printf("Attempting to attach EBS volume %q to %q as %q\r\n", name, instanceName, modeString(readOnly))
+	defer fmt.Println("------------")
+
+	if readOnly {
+		// EBS has no read-only attachments. The read-only mounts are what
+		// keep the instances from writing.
+		log.Printf("EBS cannot attach read-only, attaching %q to %q read-write\r\n", name, instanceName)
+	}
+	start := time.Now()
+	err := p.attachVolume(name, instanceName)
+	metrics.observeOperation("attach", start, err)
+	if err != nil {
+		log.Printf(
+			"Attaching EBS volume %q to %q failed with %v\r\n",
+			name,
+			instanceName,
+			err)
+		return err
+	}
+	log.Printf("Attached EBS volume %q to %q\r\n", name, instanceName)
+	return nil
+}
+
+func (p *ebsProvider) attachVolume(name, instanceName string) error {
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return err
+	}
+
+	p.attachMu.Lock()
+	device, err := p.freeDevice(instanceName)
+	if err == nil {
+		err = p.api.AttachVolume(volume.VolumeID, instanceName, device)
+	}
+	p.attachMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return p.waitFor("volume "+volume.VolumeID+" to attach to "+instanceName, func() (bool, error) {
+		volume, err := p.findVolume(name)
+		if err != nil {
+			return false, err
+		}
+		a := p.attachment(volume, instanceName)
+		if a == nil {
+			return false, fmt.Errorf("attachment of %s to %s disappeared while attaching", volume.VolumeID, instanceName)
+		}
+		return a.State == ebsAttached, nil
+	})
+}
+
+// freeDevice picks the first device name in the range AWS recommends for EBS
+// volumes that no volume on instanceName uses.
+func (p *ebsProvider) freeDevice(instanceName string) (string, error) {
+	volumes, err := p.api.DescribeVolumes()
+	if err != nil {
+		return "", err
+	}
+	used := make(map[string]bool)
+	for _, volume := range volumes {
+		for _, a := range volume.Attachments {
+			if a.InstanceID == instanceName {
+				used[a.Device] = true
+			}
+		}
+	}
+	for letter := 'f'; letter <= 'z'; letter++ {
+		device := "/dev/sd" + string(letter)
+		if !used[device] {
+			return device, nil
+		}
+	}
+	return "", fmt.Errorf("AttachmentLimitExceeded: no free device names on %s", instanceName)
+}
+
+func (p *ebsProvider) DetachVolume(ctx context.Context, name, instanceName string) error {
+	log.Printf("Attempting to detach EBS volume %q from %q\r\n", name, instanceName)
+	defer fmt.Println("------------")
+
+	start := time.Now()
+	err := p.detachVolume(name, instanceName)
+	metrics.observeOperation("detach", start, err)
+	if err != nil {
+		log.Printf(
+			"Detaching EBS volume %q from %q failed with %v\r\n",
+			name,
+			instanceName,
+			err)
+		return err
+	}
+	log.Printf("Detached EBS volume %q from %q\r\n", name, instanceName)
+	return nil
+}
+
+func (p *ebsProvider) detachVolume(name, instanceName string) error {
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return err
+	}
+	if err := p.api.DetachVolume(volume.VolumeID, instanceName); err != nil {
+		return err
+	}
+	return p.waitFor("volume "+volume.VolumeID+" to detach from "+instanceName, func() (bool, error) {
+		volume, err := p.findVolume(name)
+		if err != nil {
+			return false, err
+		}
+		return p.attachment(volume, instanceName) == nil, nil
+	})
+}
+
+// VerifyAttachment ignores readOnly, since EBS attachments have no mode.
+func (p *ebsProvider) VerifyAttachment(ctx context.Context, name, instanceName string, attached, readOnly bool) error {
+	log.Printf("Verifying EBS volume %q attached=%v to %q\r\n", name, attached, instanceName)
+
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return err
+	}
+	a := p.attachment(volume, instanceName)
+	switch {
+	case attached && a == nil:
+		return fmt.Errorf("EBS volume %q (%s) is not attached to %q", name, volume.VolumeID, instanceName)
+	case attached && a.State != ebsAttached:
+		return fmt.Errorf("EBS volume %q (%s) is %s on %q, expected %s", name, volume.VolumeID, a.State, instanceName, ebsAttached)
+	case attached && volume.State != ebsStateInUse:
+		return fmt.Errorf("EBS volume %q (%s) is %s while attached to %q, expected %s", name, volume.VolumeID, volume.State, instanceName, ebsStateInUse)
+	case !attached && a != nil:
+		return fmt.Errorf("EBS volume %q (%s) is still %s on %q after detach", name, volume.VolumeID, a.State, instanceName)
+	}
+	return nil
+}
+
+func (p *ebsProvider) VolumeUsers(ctx context.Context, name string) ([]string, error) {
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, a := range volume.Attachments {
+		users = append(users, a.InstanceID)
+	}
+	return users, nil
+}
+
+// DevicePath follows the naming of the instance type: Nitro instances expose
+// EBS volumes as NVMe devices whose serial is the volume ID, Xen instances
+// rename /dev/sdX to /dev/xvdX.
+func (p *ebsProvider) DevicePath(name, instanceName string) (string, error) {
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return "", err
+	}
+	if *ebsDeviceNaming == "nvme" {
+		return diskByIdPath + "nvme-Amazon_Elastic_Block_Store_" + strings.Replace(volume.VolumeID, "-", "", 1), nil
+	}
+	a := p.attachment(volume, instanceName)
+	if a == nil {
+		return "", fmt.Errorf("EBS volume %q (%s) is not attached to %q", name, volume.VolumeID, instanceName)
+	}
+	return strings.Replace(a.Device, "/dev/sd", "/dev/xvd", 1), nil
+}
+
+func (p *ebsProvider) VolumeLabels(ctx context.Context, name string) (map[string]string, error) {
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return nil, err
+	}
+	return volume.Tags, nil
+}
+
+func (p *ebsProvider) CSIVolumeID(name string) (string, error) {
+	volume, err := p.findVolume(name)
+	if err != nil {
+		return "", err
+	}
+	return volume.VolumeID, nil
+}
+
+func (p *ebsProvider) RunOnInstance(ctx context.Context, command, instanceName string) ([]byte, error) {
+	return executeCmd(ctx, *ebsSSH, "root@"+instanceName, command)
+}
+
+func (p *ebsProvider) CopyToInstance(ctx context.Context, localPath, remotePath, instanceName string) error {
+	_, err := executeCmd(ctx, *ebsSCP, localPath, "root@"+instanceName+":"+remotePath)
+	return err
+}
+
+// ValidateScenario rejects read-only scenarios on volumes without
+// Multi-Attach: they attach the volume to both instances at once, which EBS
+// refuses with VolumeInUse.
+func (p *ebsProvider) ValidateScenario(s scenario) error {
+	volumeType := s.Disk.Type
+	if volumeType == "" {
+		volumeType = ebsDefaultVolumeType
+	}
+	if s.ReadOnly && !ebsMultiAttachType(volumeType) {
+		return fmt.Errorf("read-only scenario %v attaches the volume to two instances, which EBS only allows for Multi-Attach volume types io1 and io2, not %s", s, volumeType)
+	}
+	return nil
+}
+
+func (p *ebsProvider) Preflight(ctx context.Context, checklist *preflightChecklist, instances []string) {
+	volumes, err := p.api.DescribeVolumes()
+	checklist.add("ebs api", fmt.Sprintf("fake API in %q with %d volumes", *ebsFakeState, len(volumes)), err)
+
+	for _, binary := range []string{*ebsSSH, *ebsSCP} {
+		resolved, err := exec.LookPath(binary)
+		checklist.add("ebs "+binary, resolved, err)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestEBSProvider returns an EBS provider backed by a fake API in a
+// temporary directory, polling fast enough for tests.
+func newTestEBSProvider(t *testing.T, transitionDelay time.Duration, attachLimit int) (*ebsProvider, *fakeEBSAPI) {
+	t.Helper()
+	savedInterval, savedTimeout := *ebsPollInterval, *ebsOperationTimeout
+	*ebsPollInterval = time.Millisecond
+	*ebsOperationTimeout = 5 * time.Second
+	t.Cleanup(func() { *ebsPollInterval, *ebsOperationTimeout = savedInterval, savedTimeout })
+
+	api := &fakeEBSAPI{
+		path:            filepath.Join(t.TempDir(), "ebs.json"),
+		transitionDelay: transitionDelay,
+		attachLimit:     attachLimit,
+	}
+	return &ebsProvider{api: api}, api
+}
+
+func describeVolume(t *testing.T, api *fakeEBSAPI, volumeID string) *ebsVolume {
+	t.Helper()
+	volumes, err := api.DescribeVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range volumes {
+		if volumes[i].VolumeID == volumeID {
+			return &volumes[i]
+		}
+	}
+	return nil
+}
+
+func TestEBSProviderLifecycle(t *testing.T) {
+	ctx := context.Background()
+	p, api := newTestEBSProvider(t, 10*time.Millisecond, 27)
+
+	if err := p.CreateVolume(ctx, "pd-1", diskSpec{SizeGB: 10, Labels: map[string]string{runLabelKey: "run-1"}}); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	labels, err := p.VolumeLabels(ctx, "pd-1")
+	if err != nil || labels["Name"] != "pd-1" || labels[runLabelKey] != "run-1" {
+		t.Errorf("VolumeLabels = %v, %v, expected the Name tag and the run label", labels, err)
+	}
+	volumeID, err := p.CSIVolumeID("pd-1")
+	if err != nil || !strings.HasPrefix(volumeID, "vol-") {
+		t.Fatalf("CSIVolumeID = %q, %v, expected a volume ID", volumeID, err)
+	}
+	if state := describeVolume(t, api, volumeID).State; state != ebsStateAvailable {
+		t.Errorf("volume is %s after CreateVolume, expected %s", state, ebsStateAvailable)
+	}
+
+	if err := p.AttachVolume(ctx, "pd-1", "i-a", false /* readOnly */); err != nil {
+		t.Fatalf("AttachVolume failed: %v", err)
+	}
+	if err := p.VerifyAttachment(ctx, "pd-1", "i-a", true /* attached */, false /* readOnly */); err != nil {
+		t.Errorf("VerifyAttachment after attach: %v", err)
+	}
+	if users, err := p.VolumeUsers(ctx, "pd-1"); err != nil || len(users) != 1 || users[0] != "i-a" {
+		t.Errorf("VolumeUsers = %v, %v, expected [i-a]", users, err)
+	}
+	devPath, err := p.DevicePath("pd-1", "i-a")
+	if want := diskByIdPath + "nvme-Amazon_Elastic_Block_Store_vol" + strings.TrimPrefix(volumeID, "vol-"); err != nil || devPath != want {
+		t.Errorf("DevicePath = %q, %v, expected %q", devPath, err, want)
+	}
+
+	if err := p.DetachVolume(ctx, "pd-1", "i-a"); err != nil {
+		t.Fatalf("DetachVolume failed: %v", err)
+	}
+	if err := p.VerifyAttachment(ctx, "pd-1", "i-a", false /* attached */, false /* readOnly */); err != nil {
+		t.Errorf("VerifyAttachment after detach: %v", err)
+	}
+	if state := describeVolume(t, api, volumeID).State; state != ebsStateAvailable {
+		t.Errorf("volume is %s after DetachVolume, expected %s", state, ebsStateAvailable)
+	}
+
+	if err := p.DeleteVolume(ctx, "pd-1"); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if v := describeVolume(t, api, volumeID); v != nil {
+		t.Errorf("volume still exists after DeleteVolume: %+v", v)
+	}
+}
+
+func TestEBSXvdDeviceNaming(t *testing.T) {
+	ctx := context.Background()
+	saved := *ebsDeviceNaming
+	*ebsDeviceNaming = "xvd"
+	t.Cleanup(func() { *ebsDeviceNaming = saved })
+
+	p, _ := newTestEBSProvider(t, 0, 27)
+	for _, name := range []string{"pd-1", "pd-2"} {
+		if err := p.CreateVolume(ctx, name, diskSpec{SizeGB: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.AttachVolume(ctx, name, "i-a", false /* readOnly */); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{"pd-1": "/dev/xvdf", "pd-2": "/dev/xvdg"} {
+		if devPath, err := p.DevicePath(name, "i-a"); err != nil || devPath != want {
+			t.Errorf("DevicePath(%s) = %q, %v, expected %q", name, devPath, err, want)
+		}
+	}
+}
+
+func TestFakeEBSAPIStateTransitions(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	_, api := newTestEBSProvider(t, delay, 27)
+
+	volume, err := api.CreateVolume(10, "gp3", map[string]string{"Name": "pd-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStates := func(volumeState, attachmentState string) {
+		t.Helper()
+		v := describeVolume(t, api, volume.VolumeID)
+		switch {
+		case volumeState == "" && v != nil:
+			t.Fatalf("volume is %s, expected it gone", v.State)
+		case volumeState == "":
+			return
+		case v == nil:
+			t.Fatalf("volume is gone, expected %s", volumeState)
+		case v.State != volumeState:
+			t.Fatalf("volume is %s, expected %s", v.State, volumeState)
+		}
+		got := ""
+		if len(v.Attachments) > 0 {
+			got = v.Attachments[0].State
+		}
+		if got != attachmentState {
+			t.Fatalf("attachment is %q, expected %q", got, attachmentState)
+		}
+	}
+	settle := func() { time.Sleep(2 * delay) }
+
+	expectStates(ebsStateCreating, "")
+	if err := api.AttachVolume(volume.VolumeID, "i-a", "/dev/sdf"); classifyError(err) != errClassContention {
+		t.Errorf("attach while creating returned %v, expected a contention error", err)
+	}
+	settle()
+	expectStates(ebsStateAvailable, "")
+
+	if err := api.AttachVolume(volume.VolumeID, "i-a", "/dev/sdf"); err != nil {
+		t.Fatal(err)
+	}
+	// EC2 marks the volume in-use as soon as the attachment starts.
+	expectStates(ebsStateInUse, ebsAttaching)
+	if err := api.DetachVolume(volume.VolumeID, "i-a"); classifyError(err) != errClassContention {
+		t.Errorf("detach while attaching returned %v, expected a contention error", err)
+	}
+	settle()
+	expectStates(ebsStateInUse, ebsAttached)
+
+	if err := api.DetachVolume(volume.VolumeID, "i-a"); err != nil {
+		t.Fatal(err)
+	}
+	expectStates(ebsStateInUse, ebsDetaching)
+	settle()
+	expectStates(ebsStateAvailable, "")
+
+	if err := api.DeleteVolume(volume.VolumeID); err != nil {
+		t.Fatal(err)
+	}
+	expectStates(ebsStateDeleting, "")
+	settle()
+	expectStates("", "")
+}
+
+func TestEBSErrorClasses(t *testing.T) {
+	ctx := context.Background()
+	p, _ := newTestEBSProvider(t, 0, 2)
+	for _, name := range []string{"gp3-1", "gp3-2", "gp3-3"} {
+		if err := p.CreateVolume(ctx, name, diskSpec{SizeGB: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.CreateVolume(ctx, "io2-1", diskSpec{SizeGB: 1, Type: "io2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AttachVolume(ctx, "gp3-1", "i-a", false /* readOnly */); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AttachVolume(ctx, "io2-1", "i-a", false /* readOnly */); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		operation func() error
+		class     string
+	}{
+		{
+			name:      "attach a gp3 volume to a second instance",
+			operation: func() error { return p.AttachVolume(ctx, "gp3-1", "i-b", true /* readOnly */) },
+			class:     errClassInUse,
+		},
+		{
+			name:      "delete an attached volume",
+			operation: func() error { return p.DeleteVolume(ctx, "gp3-1") },
+			class:     errClassInUse,
+		},
+		{
+			name:      "attach beyond the instance limit",
+			operation: func() error { return p.AttachVolume(ctx, "gp3-2", "i-a", false /* readOnly */) },
+			class:     errClassAttachLimit,
+		},
+		{
+			name:      "attach a missing volume",
+			operation: func() error { return p.AttachVolume(ctx, "missing", "i-a", false /* readOnly */) },
+			class:     errClassNotFound,
+		},
+		{
+			name:      "detach a volume that is not attached",
+			operation: func() error { return p.DetachVolume(ctx, "gp3-3", "i-a") },
+			class:     errClassContention,
+		},
+		{
+			name: "create on a replicated spec",
+			operation: func() error {
+				return p.CreateVolume(ctx, "regional", diskSpec{SizeGB: 1, ReplicaZones: []string{"a", "b"}})
+			},
+			class: errClassOther,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.operation()
+			if got := classifyError(err); got != tc.class {
+				t.Errorf("got %v classified as %q, expected %q", err, got, tc.class)
+			}
+		})
+	}
+
+	// io2 volumes have Multi-Attach.
+	if err := p.AttachVolume(ctx, "io2-1", "i-b", true /* readOnly */); err != nil {
+		t.Errorf("attaching an io2 volume to a second instance failed: %v", err)
+	}
+}
+
+func TestEBSOperationTimeout(t *testing.T) {
+	p, _ := newTestEBSProvider(t, time.Hour, 27)
+	*ebsOperationTimeout = 20 * time.Millisecond
+	err := p.CreateVolume(context.Background(), "pd-1", diskSpec{SizeGB: 1})
+	if got := classifyError(err); got != errClassTimeout {
+		t.Errorf("create stuck in creating returned %v classified as %q, expected %q", err, got, errClassTimeout)
+	}
+}
+
+func TestEBSValidateScenario(t *testing.T) {
+	p, _ := newTestEBSProvider(t, 0, 27)
+	for _, tc := range []struct {
+		readOnly bool
+		diskType string
+		ok       bool
+	}{
+		{readOnly: false, diskType: "", ok: true},
+		{readOnly: false, diskType: "gp2", ok: true},
+		{readOnly: true, diskType: "", ok: false},
+		{readOnly: true, diskType: "gp3", ok: false},
+		{readOnly: true, diskType: "io1", ok: true},
+		{readOnly: true, diskType: "io2", ok: true},
+	} {
+		s := scenario{FSType: testFSType, ReadOnly: tc.readOnly, Disk: diskSpec{Type: tc.diskType, SizeGB: 10}}
+		err := p.ValidateScenario(s)
+		if (err == nil) != tc.ok {
+			t.Errorf("ValidateScenario(%s, type %q) = %v, expected ok=%v", modeString(tc.readOnly), tc.diskType, err, tc.ok)
+		}
+	}
+}
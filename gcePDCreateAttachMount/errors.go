@@ -0,0 +1,173 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error classes reported for failed operations.
+const (
+	errClassContention  = "contention"
+	errClassTimeout     = "timeout"
+	errClassAttachLimit = "attach-limit"
+	errClassBusy        = "busy"
+	// errClassZoneMismatch is an attach of a PD to an instance outside its
+	// zone or replica zones.
+	errClassZoneMismatch = "zone-mismatch"
+	// errClassInUse is a disk attached or deleted while another instance
+	// holds it read-write.
+	errClassInUse      = "in-use"
+	errClassReadOnly   = "read-only"
+	errClassNotFound   = "not-found"
+	errClassNotMounted = "not-mounted"
+	// errClassMounted is a format refused because the device is mounted.
+	errClassMounted = "mounted"
+	errClassOther   = "other"
+)
+
+var errOperationTimeout = errors.New("operation timed out")
+
+// contentionMarkers are substrings of cloud errors returned when another
+// operation on the same disk or instance is still in flight.
+var contentionMarkers = []string{
+	"resourceNotReady",
+	"is not ready",
+	"RESOURCE_OPERATION_RATE_EXCEEDED",
+	"rateLimitExceeded",
+	"Operation rate exceeded",
+	"operation in progress",
+	// EBS rejects operations on volumes in a transient state.
+	"IncorrectState",
+}
+
+// attachLimitMarkers are substrings of cloud errors returned when an
+// instance already has the maximum number of disks attached.
+var attachLimitMarkers = []string{
+	"maximum number of disks",
+	"Exceeded maximum",
+	"exceeds the maximum",
+	// GCE quota error: "Exceeded limit 'maximum_persistent_disks' on
+	// resource 'instance-1'. Limit: 16.0".
+	"maximum_persistent_disks",
+	"AttachmentLimitExceeded",
+}
+
+// zoneMismatchMarkers are substrings of cloud errors returned when a disk
+// and an instance are in different zones.
+var zoneMismatchMarkers = []string{
+	"same zone as the instance",
+	"must be located in the same zone",
+	// Regional PDs attach only in their replica zones. Only the attach error
+	// is matched, not every message mentioning replica zones, such as the
+	// create errors for invalid replica zones.
+	"one of its replica zones",
+	"not in the same zone",
+}
+
+// inUseMarkers are substrings of cloud errors returned when a disk is held by
+// another instance.
+var inUseMarkers = []string{
+	"is already being used by",
+	"resourceInUseByAnotherResource",
+	"VolumeInUse",
+}
+
+// readOnlyMarkers are substrings of errors writing to a read-only mount.
+var readOnlyMarkers = []string{
+	"Read-only file system",
+}
+
+// notMountedMarkers are substrings of umount errors for a path that is not
+// mounted.
+var notMountedMarkers = []string{
+	"not mounted",
+}
+
+// mountedMarkers are substrings of mkfs errors refusing to format a device
+// that is in use.
+var mountedMarkers = []string{
+	"will not make a filesystem here",
+	"contains a mounted filesystem",
+}
+
+// notFoundMarkers are substrings of errors for disks, instances or devices
+// that do not exist.
+var notFoundMarkers = []string{
+	"does not exist",
+	"No such file or directory",
+	"InvalidVolume.NotFound",
+	"was not found",
+}
+
+// busyMarkers are substrings of umount errors returned when the mount is
+// still in use (EBUSY).
+var busyMarkers = []string{
+	"target is busy",
+	"device is busy",
+}
+
+// classifyError maps an operation error to one of the errClass constants.
+// It returns "" for a nil error.
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, errOperationTimeout) {
+		return errClassTimeout
+	}
+
+	msg := err.Error()
+	for _, marker := range attachLimitMarkers {
+		if strings.Contains(msg, marker) {
+			return errClassAttachLimit
+		}
+	}
+	for _, marker := range zoneMismatchMarkers {
+		if strings.Contains(msg, marker) {
+			return errClassZoneMismatch
+		}
+	}
+	for _, marker := range busyMarkers {
+		if strings.Contains(msg, marker) {
+			return errClassBusy
+		}
+	}
+	for _, marker := range contentionMarkers {
+		if strings.Contains(msg, marker) {
+			return errClassContention
+		}
+	}
+	for _, class := range []struct {
+		name    string
+		markers []string
+	}{
+		{errClassInUse, inUseMarkers},
+		{errClassReadOnly, readOnlyMarkers},
+		{errClassNotMounted, notMountedMarkers},
+		{errClassMounted, mountedMarkers},
+		{errClassNotFound, notFoundMarkers},
+	} {
+		for _, marker := range class.markers {
+			if strings.Contains(msg, marker) {
+				return class.name
+			}
+		}
+	}
+	return errClassOther
+}
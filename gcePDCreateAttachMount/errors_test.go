@@ -0,0 +1,122 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		msg   string
+		class string
+	}{
+		// gcloud compute instances attach-disk
+		{
+			msg:   "ERROR: (gcloud.compute.instances.attach-disk) Could not fetch resource:\n - Exceeded limit 'maximum_persistent_disks' on resource 'instance-1'. Limit: 16.0",
+			class: errClassAttachLimit,
+		},
+		{
+			msg:   "ERROR: (gcloud.compute.instances.attach-disk) Could not fetch resource:\n - The disk resource 'projects/p/zones/us-central1-b/disks/pd' is already being used by 'projects/p/zones/us-central1-b/instances/instance-1'",
+			class: errClassInUse,
+		},
+		{
+			msg:   "ERROR: (gcloud.compute.instances.attach-disk) Could not fetch resource:\n - Invalid value for field 'resource.source': 'projects/p/zones/us-central1-a/disks/pd'. Disk must be located in the same zone as the instance.",
+			class: errClassZoneMismatch,
+		},
+		{
+			msg:   "ERROR: (gcloud.compute.instances.attach-disk) Could not fetch resource:\n - Invalid value for field 'resource.source': 'projects/p/regions/us-central1/disks/pd'. Disk must be attached to an instance in one of its replica zones.",
+			class: errClassZoneMismatch,
+		},
+		{
+			msg:   "ERROR: (gcloud.compute.instances.attach-disk) Could not fetch resource:\n - The resource 'projects/p/zones/us-central1-b/disks/pd' is not ready",
+			class: errClassContention,
+		},
+		{
+			msg:   "ERROR: (gcloud.compute.instances.detach-disk) Could not fetch resource:\n - Operation rate exceeded for resource 'projects/p/zones/us-central1-b/disks/pd'. Too frequent operations from the source resource.",
+			class: errClassContention,
+		},
+		{
+			msg:   "ERROR: (gcloud.compute.disks.delete) Could not fetch resource:\n - The resource 'projects/p/zones/us-central1-b/disks/pd' was not found",
+			class: errClassNotFound,
+		},
+		// The tool's own replica zone validation is not a zone mismatch.
+		{
+			msg:   "replica zones [us-central1-a europe-west1-b] are in different regions",
+			class: errClassOther,
+		},
+		// EC2
+		{
+			msg:   "An error occurred (AttachmentLimitExceeded) when calling the AttachVolume operation: You have reached the maximum number of volumes for this instance.",
+			class: errClassAttachLimit,
+		},
+		{
+			msg:   "An error occurred (VolumeInUse) when calling the AttachVolume operation: vol-0123456789abcdef0 is already attached to an instance",
+			class: errClassInUse,
+		},
+		{
+			msg:   "An error occurred (IncorrectState) when calling the DetachVolume operation: Volume 'vol-0123456789abcdef0' is in the 'available' state.",
+			class: errClassContention,
+		},
+		{
+			msg:   "An error occurred (InvalidVolume.NotFound) when calling the DescribeVolumes operation: The volume 'vol-0123456789abcdef0' does not exist.",
+			class: errClassNotFound,
+		},
+		// Commands on the instance
+		{
+			msg:   "failed: err=exit status 32\noutput: umount: /mnt/disks/pd: target is busy.\n",
+			class: errClassBusy,
+		},
+		{
+			msg:   "failed: err=exit status 32\noutput: umount: /mnt/disks/pd: not mounted.\n",
+			class: errClassNotMounted,
+		},
+		{
+			msg:   "failed: err=exit status 1\noutput: mke2fs 1.46.5 (30-Dec-2021)\n/dev/sdb is mounted; will not make a filesystem here!\n",
+			class: errClassMounted,
+		},
+		{
+			msg:   "failed: err=exit status 1\noutput: bash: /mnt/disks/pd/mytest.log: Read-only file system\n",
+			class: errClassReadOnly,
+		},
+		{
+			msg:   "failed: err=exit status 1\noutput: cat: /mnt/disks/pd/mytest.log: No such file or directory\n",
+			class: errClassNotFound,
+		},
+		{
+			msg:   "failed: err=exit status 255\noutput: ssh: connect to host 10.0.0.2 port 22: Connection refused\n",
+			class: errClassOther,
+		},
+	} {
+		if got := classifyError(errors.New(tc.msg)); got != tc.class {
+			t.Errorf("classifyError(%q) = %q, expected %q", tc.msg, got, tc.class)
+		}
+	}
+}
+
+func TestClassifyErrorTimeout(t *testing.T) {
+	if got := classifyError(nil); got != "" {
+		t.Errorf("classifyError(nil) = %q, expected \"\"", got)
+	}
+	// A wrapped timeout wins over the markers of the last error it carries.
+	err := fmt.Errorf("attach did not finish: %w (last error: The resource 'pd' is not ready)", errOperationTimeout)
+	if got := classifyError(err); got != errClassTimeout {
+		t.Errorf("classifyError(%v) = %q, expected %q", err, got, errClassTimeout)
+	}
+}
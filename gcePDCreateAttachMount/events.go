@@ -0,0 +1,340 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	eventDir       = flag.String("event-dir", "events", "Directory a JSON-lines event log is written to per run ID. Empty disables the event log.")
+	eventsMaxDepth = flag.Int("events-max-depth", 0, "Deepest span nesting the events subcommand shows. 0 shows all.")
+)
+
+// spanAttrs describe what a span operates on. Unset attributes are inherited
+// from the parent span.
+type spanAttrs struct {
+	Step     string `json:"step,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Disk     string `json:"disk,omitempty"`
+	Command  string `json:"command,omitempty"`
+}
+
+// event is one line of the event log. Every span writes a start event and,
+// unless the tool dies first, an end event.
+type event struct {
+	RunID    string    `json:"runID"`
+	Type     string    `json:"type"`
+	SpanID   string    `json:"spanID"`
+	ParentID string    `json:"parentID,omitempty"`
+	Name     string    `json:"name"`
+	Time     time.Time `json:"time"`
+	spanAttrs
+	// Set on end events only.
+	DurationMs float64 `json:"durationMs,omitempty"`
+	ExitCode   *int    `json:"exitCode,omitempty"`
+	ErrClass   string  `json:"errClass,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// span is an operation in progress. A span nests under the span of the
+// context it is started with, and the context startSpan returns carries it to
+// the operations it is made of.
+type span struct {
+	id       string
+	parentID string
+	name     string
+	start    time.Time
+	attrs    spanAttrs
+}
+
+type spanContextKey struct{}
+
+// startSpan opens a span nested under the span of ctx, if any, and returns
+// the context to run the span's operation with.
+func startSpan(ctx context.Context, name string, attrs spanAttrs) (context.Context, *span) {
+	s := &span{id: newSpanID(), name: name, start: time.Now(), attrs: attrs}
+	if parent, ok := ctx.Value(spanContextKey{}).(*span); ok {
+		s.parentID = parent.id
+		s.attrs = inheritAttrs(s.attrs, parent.attrs)
+	}
+
+	writeEvent(event{
+		RunID:     runID,
+		Type:      "start",
+		SpanID:    s.id,
+		ParentID:  s.parentID,
+		Name:      s.name,
+		Time:      s.start,
+		spanAttrs: s.attrs,
+	})
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// end closes the span with the outcome of its operation.
+func (s *span) end(err error) {
+	now := time.Now()
+	e := event{
+		RunID:      runID,
+		Type:       "end",
+		SpanID:     s.id,
+		ParentID:   s.parentID,
+		Name:       s.name,
+		Time:       now,
+		spanAttrs:  s.attrs,
+		DurationMs: float64(now.Sub(s.start)) / float64(time.Millisecond),
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		e.ExitCode = &code
+	}
+	if err != nil {
+		e.ErrClass = classifyError(err)
+		e.Error = err.Error()
+	}
+	writeEvent(e)
+}
+
+// traced runs fn in a span nested under the span of ctx.
+func traced(ctx context.Context, name string, attrs spanAttrs, fn func(ctx context.Context) error) error {
+	ctx, s := startSpan(ctx, name, attrs)
+	err := fn(ctx)
+	s.end(err)
+	return err
+}
+
+func inheritAttrs(attrs, parent spanAttrs) spanAttrs {
+	if attrs.Step == "" {
+		attrs.Step = parent.Step
+	}
+	if attrs.Instance == "" {
+		attrs.Instance = parent.Instance
+	}
+	if attrs.Disk == "" {
+		attrs.Disk = parent.Disk
+	}
+	return attrs
+}
+
+// newSpanID returns a random ID, so spans of a resumed run appended to the
+// same log do not collide with those of the interrupted one.
+func newSpanID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var eventLog = struct {
+	sync.Mutex
+	runID string
+	file  *os.File
+}{}
+
+// eventLogPath returns the event log of a run.
+func eventLogPath(id string) string {
+	return filepath.Join(*eventDir, id+".jsonl")
+}
+
+// writeEvent appends e to the log of its run. Failing to log is reported but
+// never fails the operation being logged.
+func writeEvent(e event) {
+	if *eventDir == "" || e.RunID == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encoding event failed: %v\n", err)
+		return
+	}
+
+	eventLog.Lock()
+	defer eventLog.Unlock()
+	// A resumed run adopts the run ID of its state file, so the log can
+	// change mid-process.
+	if eventLog.file == nil || eventLog.runID != e.RunID {
+		if eventLog.file != nil {
+			eventLog.file.Close()
+			eventLog.file = nil
+		}
+		if err := os.MkdirAll(*eventDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Creating event directory failed: %v\n", err)
+			return
+		}
+		f, err := os.OpenFile(eventLogPath(e.RunID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Opening event log failed: %v\n", err)
+			return
+		}
+		eventLog.file, eventLog.runID = f, e.RunID
+	}
+	if _, err := eventLog.file.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Writing event failed: %v\n", err)
+	}
+}
+
+// spanRecord is a span reassembled from its start and end events.
+type spanRecord struct {
+	start    event
+	end      *event
+	children []*spanRecord
+}
+
+// runEvents renders the timeline of the run identified by arg, a run ID or
+// the path of an event log.
+func runEvents(arg string) error {
+	if arg == "" {
+		return fmt.Errorf("usage: events <run-id|event-log>")
+	}
+	logPath := arg
+	if _, err := os.Stat(logPath); err != nil {
+		logPath = eventLogPath(arg)
+	}
+	f, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("reading event log failed: %v", err)
+	}
+	defer f.Close()
+
+	roots, err := readSpans(f)
+	if err != nil {
+		return fmt.Errorf("reading event log %q failed: %v", logPath, err)
+	}
+	printTimeline(os.Stdout, roots)
+	return nil
+}
+
+func readSpans(r io.Reader) ([]*spanRecord, error) {
+	records := make(map[string]*spanRecord)
+	var order []*spanRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		switch e.Type {
+		case "start":
+			rec := &spanRecord{start: e}
+			records[e.SpanID] = rec
+			order = append(order, rec)
+		case "end":
+			if rec, ok := records[e.SpanID]; ok {
+				end := e
+				rec.end = &end
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var roots []*spanRecord
+	for _, rec := range order {
+		if parent, ok := records[rec.start.ParentID]; ok && rec.start.ParentID != "" {
+			parent.children = append(parent.children, rec)
+		} else {
+			roots = append(roots, rec)
+		}
+	}
+	sort.SliceStable(roots, func(i, j int) bool { return roots[i].start.Time.Before(roots[j].start.Time) })
+	return roots, nil
+}
+
+// printTimeline prints one line per span: its start relative to the first
+// span, its duration, its name indented by depth, and its outcome.
+func printTimeline(w io.Writer, roots []*spanRecord) {
+	if len(roots) == 0 {
+		fmt.Fprintln(w, "No events")
+		return
+	}
+	origin := roots[0].start.Time
+	var print func(rec *spanRecord, depth int)
+	print = func(rec *spanRecord, depth int) {
+		if *eventsMaxDepth > 0 && depth >= *eventsMaxDepth {
+			return
+		}
+		duration, status := "?", "unfinished"
+		if rec.end != nil {
+			duration = (time.Duration(rec.end.DurationMs * float64(time.Millisecond))).Round(time.Millisecond).String()
+			status = "ok"
+			if rec.end.Error != "" {
+				status = "FAILED " + rec.end.ErrClass
+			}
+			if rec.end.ExitCode != nil {
+				status += fmt.Sprintf(" (exit %d)", *rec.end.ExitCode)
+			}
+		}
+		fmt.Fprintf(w, "+%-9s %9s  %s%s%s  %s\n",
+			rec.start.Time.Sub(origin).Round(time.Millisecond),
+			duration,
+			strings.Repeat("  ", depth),
+			rec.start.Name,
+			describeAttrs(rec.start.spanAttrs, depth),
+			status)
+		for _, child := range rec.children {
+			print(child, depth+1)
+		}
+	}
+	for _, rec := range roots {
+		print(rec, 0)
+	}
+}
+
+// describeAttrs formats the attributes worth showing on a timeline line.
+// Inherited attributes are only shown on top-level spans.
+func describeAttrs(attrs spanAttrs, depth int) string {
+	var parts []string
+	if depth == 0 {
+		if attrs.Disk != "" {
+			parts = append(parts, "disk="+attrs.Disk)
+		}
+		if attrs.Instance != "" {
+			parts = append(parts, "instance="+attrs.Instance)
+		}
+	}
+	if attrs.Command != "" {
+		command := attrs.Command
+		if len(command) > 80 {
+			command = command[:77] + "..."
+		}
+		parts = append(parts, fmt.Sprintf("%q", command))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " " + strings.Join(parts, " ")
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// useEventLog writes the event log of the test to a temporary directory and
+// returns its path.
+func useEventLog(t *testing.T) string {
+	t.Helper()
+	saved := *eventDir
+	*eventDir = t.TempDir()
+	t.Cleanup(func() {
+		eventLog.Lock()
+		if eventLog.file != nil {
+			eventLog.file.Close()
+			eventLog.file = nil
+		}
+		eventLog.Unlock()
+		*eventDir = saved
+	})
+	return eventLogPath(runID)
+}
+
+func readEventLog(t *testing.T, logPath string) []*spanRecord {
+	t.Helper()
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	roots, err := readSpans(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return roots
+}
+
+func TestStressSpansNestUnderCycle(t *testing.T) {
+	useProvider(t, newFakeProvider())
+	logPath := useEventLog(t)
+	r := &stressRunner{stats: newStressStats()}
+
+	ctx, sp := startSpan(context.Background(), "cycle 0", spanAttrs{Instance: "node-a", Disk: "pd-1"})
+	for _, operation := range []string{"mount", "read"} {
+		// The operations run in goroutines of their own.
+		err := r.do(ctx, operation, func(ctx context.Context) error {
+			_, err := getMountInfo(ctx, "node-a")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sp.end(nil)
+
+	roots := readEventLog(t, logPath)
+	if len(roots) != 1 || roots[0].start.Name != "cycle 0" {
+		t.Fatalf("event log has roots %v, expected only the cycle span", spanNames(roots))
+	}
+	operations := roots[0].children
+	if names := spanNames(operations); len(names) != 2 || names[0] != "mount" || names[1] != "read" {
+		t.Fatalf("cycle span has children %v, expected [mount read]", names)
+	}
+	for _, op := range operations {
+		if len(op.children) != 1 || op.children[0].start.Name != "remote" {
+			t.Errorf("%s span has children %v, expected its remote command", op.start.Name, spanNames(op.children))
+			continue
+		}
+		if attrs := op.children[0].start.spanAttrs; attrs.Disk != "pd-1" || attrs.Instance != "node-a" {
+			t.Errorf("remote span of %s has attributes %+v, expected those of the cycle", op.start.Name, attrs)
+		}
+	}
+}
+
+func TestSpansWithoutParentAreRoots(t *testing.T) {
+	logPath := useEventLog(t)
+	for _, name := range []string{"first", "second"} {
+		if err := traced(context.Background(), name, spanAttrs{}, func(ctx context.Context) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names := spanNames(readEventLog(t, logPath)); len(names) != 2 {
+		t.Errorf("event log has roots %v, expected [first second]", names)
+	}
+}
+
+func spanNames(records []*spanRecord) []string {
+	var names []string
+	for _, rec := range records {
+		names = append(names, rec.start.Name)
+	}
+	return names
+}
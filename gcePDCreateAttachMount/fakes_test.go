@@ -0,0 +1,604 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// Keep test runs from writing event logs, history and state into the
+	// source tree.
+	*eventDir = ""
+	*historyFile = ""
+	*artifactsDir = ""
+	dir, err := os.MkdirTemp("", "pdtest-state")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	*stateDir = dir
+	if err := setRunID("test-run"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// useProvider makes p the provider for the rest of the test.
+func useProvider(t *testing.T, p VolumeProvider) {
+	t.Helper()
+	saved := provider
+	provider = p
+	t.Cleanup(func() { provider = saved })
+}
+
+// useFakeGCloud points -gcloud at a shell script running body and returns
+// the state directory the script finds in $FAKE_GCLOUD_STATE. Like gcloud,
+// the script should warn on stderr, so tests catch output parsed with the
+// warnings mixed in.
+func useFakeGCloud(t *testing.T, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "gcloud")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(dir, "state")
+	if err := os.Mkdir(state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_GCLOUD_STATE", state)
+
+	saved := *gcloudPath
+	*gcloudPath = script
+	t.Cleanup(func() { *gcloudPath = saved })
+	return state
+}
+
+// fakeProvider is an in-memory VolumeProvider. Commands run on its instances
+// are answered by fakeInstance, so lifecycle code runs without a cloud.
+type fakeProvider struct {
+	mu        sync.Mutex
+	volumes   map[string]*fakeVolume
+	instances map[string]*fakeInstance
+}
+
+type fakeVolume struct {
+	sizeGB int
+	// fsType is the file system the volume was formatted with.
+	fsType string
+	labels map[string]string
+	// users maps the instances the volume is attached to to whether the
+	// attachment is read-only.
+	users map[string]bool
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		volumes:   make(map[string]*fakeVolume),
+		instances: make(map[string]*fakeInstance),
+	}
+}
+
+// instance returns the emulated instance called name, creating it on first
+// use.
+func (p *fakeProvider) instance(name string) *fakeInstance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inst, ok := p.instances[name]
+	if !ok {
+		inst = &fakeInstance{name: name, provider: p, dirs: make(map[string]bool), files: make(map[string]string), superOptions: make(map[string][]string)}
+		p.instances[name] = inst
+	}
+	return inst
+}
+
+func (p *fakeProvider) volume(name string) (*fakeVolume, error) {
+	v, ok := p.volumes[name]
+	if !ok {
+		return nil, fmt.Errorf("The resource 'projects/fake/zones/fake/disks/%s' was not found", name)
+	}
+	return v, nil
+}
+
+// volumeName returns the volume devPath on an instance belongs to.
+func volumeName(devPath string) string {
+	return strings.TrimPrefix(path.Base(devPath), diskScsiGooglePrefix)
+}
+
+// fsType returns the file system volume name is formatted with, or "".
+func (p *fakeProvider) fsType(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.volumes[name]; ok {
+		return v.fsType
+	}
+	return ""
+}
+
+func (p *fakeProvider) format(name, fsType string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, err := p.volume(name)
+	if err != nil {
+		return err
+	}
+	v.fsType = fsType
+	return nil
+}
+
+// attached reports whether volume name is attached to instanceName.
+func (p *fakeProvider) attached(name, instanceName string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return false
+	}
+	_, ok = v.users[instanceName]
+	return ok
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) CreateVolume(ctx context.Context, name string, spec diskSpec) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.volumes[name]; ok {
+		return fmt.Errorf("The resource 'projects/fake/zones/fake/disks/%s' already exists", name)
+	}
+	p.volumes[name] = &fakeVolume{sizeGB: spec.SizeGB, labels: spec.Labels, users: make(map[string]bool)}
+	return nil
+}
+
+func (p *fakeProvider) DeleteVolume(ctx context.Context, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, err := p.volume(name)
+	if err != nil {
+		return err
+	}
+	for user := range v.users {
+		return fmt.Errorf("The disk resource '%s' is already being used by '%s'", name, user)
+	}
+	delete(p.volumes, name)
+	return nil
+}
+
+func (p *fakeProvider) AttachVolume(ctx context.Context, name, instanceName string, readOnly bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, err := p.volume(name)
+	if err != nil {
+		return err
+	}
+	for user, userReadOnly := range v.users {
+		if user == instanceName || !readOnly || !userReadOnly {
+			return fmt.Errorf("The disk resource '%s' is already being used by '%s'", name, user)
+		}
+	}
+	v.users[instanceName] = readOnly
+	return nil
+}
+
+func (p *fakeProvider) DetachVolume(ctx context.Context, name, instanceName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, err := p.volume(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := v.users[instanceName]; !ok {
+		return fmt.Errorf("Invalid value for field 'disk': '%s' is not attached to %s (was not found)", name, instanceName)
+	}
+	delete(v.users, instanceName)
+	return nil
+}
+
+func (p *fakeProvider) VerifyAttachment(ctx context.Context, name, instanceName string, attached, readOnly bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, err := p.volume(name)
+	if err != nil {
+		return err
+	}
+	userReadOnly, ok := v.users[instanceName]
+	switch {
+	case attached && !ok:
+		return fmt.Errorf("disk %q is not attached to %q", name, instanceName)
+	case attached && userReadOnly != readOnly:
+		return fmt.Errorf("disk %q is attached to %q in the wrong mode", name, instanceName)
+	case !attached && ok:
+		return fmt.Errorf("disk %q is still attached to %q", name, instanceName)
+	}
+	return nil
+}
+
+func (p *fakeProvider) VolumeUsers(ctx context.Context, name string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, err := p.volume(name)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for user := range v.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+func (p *fakeProvider) DevicePath(name, instanceName string) (string, error) {
+	return getPDDevPath(name), nil
+}
+
+func (p *fakeProvider) VolumeLabels(ctx context.Context, name string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, err := p.volume(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.labels, nil
+}
+
+func (p *fakeProvider) CSIVolumeID(name string) (string, error) {
+	return "projects/fake/zones/fake/disks/" + name, nil
+}
+
+func (p *fakeProvider) RunOnInstance(ctx context.Context, command, instanceName string) ([]byte, error) {
+	inst := p.instance(instanceName)
+	output, err := inst.run(command)
+	if inst.sshWarning {
+		output = "Warning: Permanently added 'compute.4122945335926391813' (ED25519) to the list of known hosts.\r\n" + output
+	}
+	if err != nil {
+		return []byte(output), fmt.Errorf("failed: err=exit status 1\noutput: %s\n", output)
+	}
+	return []byte(output), nil
+}
+
+func (p *fakeProvider) CopyToInstance(ctx context.Context, localPath, remotePath, instanceName string) error {
+	return nil
+}
+
+func (p *fakeProvider) ValidateScenario(s scenario) error { return nil }
+
+func (p *fakeProvider) Preflight(ctx context.Context, checklist *preflightChecklist, instances []string) {
+}
+
+// fakeMount is a mount on a fakeInstance. Bind mounts share the source and
+// root of the mount they were made from.
+type fakeMount struct {
+	mountPoint string
+	root       string
+	fsType     string
+	source     string
+	options    []string
+}
+
+// fakeInstance emulates the shell commands the tool runs on instances
+// against an in-memory mount table and file system.
+type fakeInstance struct {
+	name     string
+	provider *fakeProvider
+	mu       sync.Mutex
+	mounts   []fakeMount
+	dirs     map[string]bool
+	// files are keyed by the source and root of the mount holding them, so
+	// bind mounts see the same content.
+	files map[string]string
+	// superOptions are the options of the file system on each mounted
+	// source, shared by all mounts of it.
+	superOptions map[string][]string
+	// csc, if set, answers csc node commands.
+	csc func(inst *fakeInstance, command string, args []string) (string, error)
+	// uid is what id -u prints, "0" if empty.
+	uid string
+	// missing are the binaries and file systems the instance lacks.
+	missing map[string]bool
+	// sshWarning makes every command print ssh's known hosts warning.
+	sshWarning bool
+	// busy is how many more plain unmounts of each mount point fail with
+	// EBUSY, and holders are the processes findMountHolders reports for it.
+	busy    map[string]int
+	holders map[string][]string
+}
+
+var (
+	fakeWriteRE = regexp.MustCompile(`^echo '(.*)' > '(.*)' && sync$`)
+	fakeReadRE  = regexp.MustCompile(`^cat '(.*)'$`)
+	// fakeProbeRE matches the command probeInstance runs script as.
+	fakeProbeRE   = regexp.MustCompile(`^\((.*)\) \| sed 's/\^/(.*)/'$`)
+	fakeMissingRE = regexp.MustCompile(`^for (?:b|fs) in ([^;]*); do `)
+)
+
+func (inst *fakeInstance) run(command string) (string, error) {
+	if strings.HasPrefix(command, *csiCSC+" node ") && inst.csc != nil {
+		fields := strings.Fields(strings.TrimPrefix(command, *csiCSC+" node "))
+		return inst.csc(inst, fields[0], fields[1:])
+	}
+	if m := fakeProbeRE.FindStringSubmatch(command); m != nil {
+		output, err := inst.run(m[1])
+		var b strings.Builder
+		for _, line := range strings.SplitAfter(output, "\n") {
+			if line != "" {
+				b.WriteString(m[2] + line)
+			}
+		}
+		return b.String(), err
+	}
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	switch {
+	case command == "id -u":
+		if inst.uid == "" {
+			return "0\n", nil
+		}
+		return inst.uid + "\n", nil
+	case fakeMissingRE.MatchString(command):
+		var b strings.Builder
+		for _, name := range strings.Fields(fakeMissingRE.FindStringSubmatch(command)[1]) {
+			if inst.missing[name] {
+				b.WriteString(name + "\n")
+			}
+		}
+		return b.String(), nil
+	case command == "cat /proc/self/mountinfo":
+		return inst.mountInfo(), nil
+	case strings.HasPrefix(command, "mkdir -p -m 0750 "):
+		inst.dirs[strings.TrimSuffix(strings.TrimPrefix(command, "mkdir -p -m 0750 "), "/")] = true
+		return "", nil
+	case strings.HasPrefix(command, "rmdir "):
+		dir := strings.TrimSuffix(strings.TrimPrefix(command, "rmdir "), "/")
+		if inst.mountAt(dir) != nil {
+			return fmt.Sprintf("rmdir: failed to remove '%s': Device or resource busy", dir), fmt.Errorf("busy")
+		}
+		delete(inst.dirs, dir)
+		return "", nil
+	case strings.HasPrefix(command, "mount "):
+		return inst.mount(strings.Fields(command)[1:])
+	case strings.HasPrefix(command, "m="):
+		mountPoint := strings.TrimPrefix(strings.SplitN(command, "\n", 2)[0], "m=")
+		if inst.mountAt(mountPoint) == nil || len(inst.holders[mountPoint]) == 0 {
+			return "", nil
+		}
+		return strings.Join(inst.holders[mountPoint], "\n") + "\n", nil
+	case strings.HasPrefix(command, "umount "):
+		fields := strings.Fields(command)
+		mountPoint := fields[len(fields)-1]
+		// umount -l and -f succeed on a busy mount.
+		if len(fields) == 2 && inst.busy[strings.TrimSuffix(mountPoint, "/")] > 0 && inst.mountAt(mountPoint) != nil {
+			inst.busy[strings.TrimSuffix(mountPoint, "/")]--
+			return fmt.Sprintf("umount: %s: target is busy.", mountPoint), fmt.Errorf("busy")
+		}
+		if !inst.unmountAt(mountPoint) {
+			return fmt.Sprintf("umount: %s: not mounted.", mountPoint), fmt.Errorf("not mounted")
+		}
+		return "", nil
+	case strings.HasPrefix(command, "fsck -a "):
+		return "", nil
+	case strings.HasPrefix(command, "lsblk -nd -o FSTYPE "):
+		return inst.provider.fsType(volumeName(strings.TrimPrefix(command, "lsblk -nd -o FSTYPE "))) + "\n", nil
+	case strings.HasPrefix(command, "mkfs."):
+		fields := strings.Fields(command)
+		device := fields[len(fields)-1]
+		for _, m := range inst.mounts {
+			if m.source == device {
+				return fmt.Sprintf("%s is mounted; will not make a filesystem here!", device), fmt.Errorf("mounted")
+			}
+		}
+		if err := inst.provider.format(volumeName(device), strings.TrimPrefix(fields[0], "mkfs.")); err != nil {
+			return err.Error(), err
+		}
+		return "", nil
+	}
+	if m := fakeWriteRE.FindStringSubmatch(command); m != nil {
+		key, mount := inst.fileKey(m[2])
+		if mount != nil && (hasOption(mount.options, "ro") || hasOption(inst.superOptions[mount.source], "ro")) {
+			return fmt.Sprintf("bash: %s: Read-only file system", m[2]), fmt.Errorf("read-only")
+		}
+		inst.files[key] = m[1] + "\n"
+		return "", nil
+	}
+	if m := fakeReadRE.FindStringSubmatch(command); m != nil {
+		key, _ := inst.fileKey(m[1])
+		content, ok := inst.files[key]
+		if !ok {
+			return fmt.Sprintf("cat: %s: No such file or directory", m[1]), fmt.Errorf("not found")
+		}
+		return content, nil
+	}
+	return fmt.Sprintf("fake instance %s: unsupported command %q", inst.name, command), fmt.Errorf("unsupported")
+}
+
+// mountAt returns the topmost mount at mountPoint. inst.mu must be held.
+func (inst *fakeInstance) mountAt(mountPoint string) *fakeMount {
+	mountPoint = strings.TrimSuffix(mountPoint, "/")
+	for i := len(inst.mounts) - 1; i >= 0; i-- {
+		if inst.mounts[i].mountPoint == mountPoint {
+			return &inst.mounts[i]
+		}
+	}
+	return nil
+}
+
+// mount emulates mount(8). Like the kernel, a bind mount copies the
+// per-mount flags of its source, "remount,bind" replaces the per-mount flags
+// of one mount, and a remount without bind changes the file system, so every
+// mount of it sees the change. inst.mu must be held.
+func (inst *fakeInstance) mount(args []string) (string, error) {
+	var fsType string
+	var options []string
+	for len(args) > 2 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-t":
+			fsType = args[1]
+		case "-o":
+			options = strings.Split(args[1], ",")
+		}
+		args = args[2:]
+	}
+	if len(args) != 2 {
+		return "mount: bad usage", fmt.Errorf("bad usage")
+	}
+	source, target := args[0], strings.TrimSuffix(args[1], "/")
+	perMount, super := splitFakeMountOptions(options)
+
+	switch {
+	case hasOption(options, "remount"):
+		m := inst.mountAt(target)
+		if m == nil {
+			return fmt.Sprintf("mount: %s: mount point not mounted or bad option.", target), fmt.Errorf("not mounted")
+		}
+		if hasOption(options, "bind") {
+			m.options = perMount
+		} else {
+			inst.superOptions[m.source] = super
+		}
+	case hasOption(options, "bind"):
+		m := inst.mountAt(source)
+		if m == nil {
+			return fmt.Sprintf("mount: %s: special device %s does not exist.", target, source), fmt.Errorf("no source")
+		}
+		bound := *m
+		bound.mountPoint = target
+		inst.mounts = append(inst.mounts, bound)
+	default:
+		name := volumeName(source)
+		formatted := inst.provider.fsType(name)
+		if !inst.provider.attached(name, inst.name) {
+			return fmt.Sprintf("mount: %s: special device %s does not exist.", target, source), fmt.Errorf("no device")
+		}
+		if formatted == "" || (fsType != "" && fsType != formatted) {
+			return fmt.Sprintf("mount: %s: wrong fs type, bad option, bad superblock on %s, missing codepage or helper program, or other error.", target, source), fmt.Errorf("wrong fs type")
+		}
+		inst.mounts = append(inst.mounts, fakeMount{mountPoint: target, root: "/", fsType: formatted, source: source, options: perMount})
+		inst.superOptions[source] = super
+	}
+	return "", nil
+}
+
+// splitFakeMountOptions splits mount(8) options into the per-mount flags and
+// the file system options the kernel would list in mountinfo.
+func splitFakeMountOptions(options []string) (perMount, super []string) {
+	mode := "rw"
+	if hasOption(options, "ro") {
+		mode = "ro"
+	}
+	perMount, super = []string{mode}, []string{mode}
+	atime := false
+	for _, option := range options {
+		switch {
+		case option == "ro" || option == "rw" || nonKernelOptions[option]:
+		case perMountOptions[option]:
+			perMount = append(perMount, option)
+			atime = atime || strings.HasSuffix(option, "atime")
+		default:
+			super = append(super, option)
+		}
+	}
+	if !atime {
+		perMount = append(perMount, "relatime")
+	}
+	return perMount, super
+}
+
+// addMount mounts m on top of whatever is at its mount point.
+func (inst *fakeInstance) addMount(m fakeMount) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	m.mountPoint = strings.TrimSuffix(m.mountPoint, "/")
+	inst.mounts = append(inst.mounts, m)
+}
+
+// removeMount unmounts the topmost mount at mountPoint and reports whether
+// there was one.
+func (inst *fakeInstance) removeMount(mountPoint string) bool {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return inst.unmountAt(mountPoint)
+}
+
+// unmountAt is removeMount with inst.mu held.
+func (inst *fakeInstance) unmountAt(mountPoint string) bool {
+	mountPoint = strings.TrimSuffix(mountPoint, "/")
+	for i := len(inst.mounts) - 1; i >= 0; i-- {
+		if inst.mounts[i].mountPoint == mountPoint {
+			inst.mounts = append(inst.mounts[:i], inst.mounts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lookupMount returns a copy of the topmost mount at mountPoint.
+func (inst *fakeInstance) lookupMount(mountPoint string) (fakeMount, bool) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if m := inst.mountAt(mountPoint); m != nil {
+		return *m, true
+	}
+	return fakeMount{}, false
+}
+
+// fileKey resolves filePath through the mount table. inst.mu must be held.
+func (inst *fakeInstance) fileKey(filePath string) (string, *fakeMount) {
+	for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+		if m := inst.mountAt(dir); m != nil {
+			return m.source + ":" + path.Join(m.root, strings.TrimPrefix(filePath, dir)), m
+		}
+		if dir == "/" || dir == "." {
+			return filePath, nil
+		}
+	}
+}
+
+// mountInfo renders the mount table in the format of /proc/self/mountinfo.
+// inst.mu must be held.
+func (inst *fakeInstance) mountInfo() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n")
+	for i, m := range inst.mounts {
+		options := m.options
+		if len(options) == 0 {
+			options = []string{"rw", "relatime"}
+		}
+		super := inst.superOptions[m.source]
+		if len(super) == 0 {
+			super = []string{"rw"}
+		}
+		fmt.Fprintf(&b, "%d 22 8:%d %s %s %s - %s %s %s\n", 100+i, 16+i, m.root, m.mountPoint, strings.Join(options, ","), m.fsType, m.source, strings.Join(super, ","))
+	}
+	return b.String()
+}
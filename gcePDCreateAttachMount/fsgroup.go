@@ -0,0 +1,167 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	fsGroup       = flag.Int64("fs-group", -1, "Apply kubelet fsGroup ownership to the final mount path with this GID after every read-write bind mount. Negative disables.")
+	fsGroupPolicy = flag.String("fs-group-policy", fsGroupPolicyAlways, "fsGroup change policy: Always or OnRootMismatch.")
+	fsGroupFiles  = flag.Int("fs-group-files", 0, "Number of files to create under the final mount path before the first fsGroup change, so its cost can be measured against file count.")
+)
+
+// fsGroupChangePolicy values, as in the pod security context.
+const (
+	fsGroupPolicyAlways         = "Always"
+	fsGroupPolicyOnRootMismatch = "OnRootMismatch"
+)
+
+// applyFSGroupScript mirrors what kubelet does for a volume with an fsGroup:
+// every file except symlinks gets the group, files get ug+rw, and directories
+// get ug+rwx and setgid so new files inherit the group. With OnRootMismatch
+// nothing is changed if the root of the volume already has the group and
+// permissions. The change is timed on the instance so ssh overhead is not
+// counted. It prints "changed|skipped <files> <nanoseconds>".
+const applyFSGroupScript = `set -e
+p=%[1]s; g=%[2]d
+files=$(find "$p" -xdev | wc -l)
+if [ "%[3]s" = OnRootMismatch ] && [ "$(stat -c %%g "$p")" = "$g" ] && [ $(( 0$(stat -c %%a "$p") & 02770 )) -eq $(( 02770 )) ]; then
+  echo "skipped $files 0"
+  exit 0
+fi
+start=$(date +%%s%%N)
+find "$p" -xdev ! -type l -exec chgrp "$g" {} +
+find "$p" -xdev -type d -exec chmod ug+rwx,g+s {} +
+find "$p" -xdev ! -type d ! -type l -exec chmod ug+rw {} +
+end=$(date +%%s%%N)
+bad=$(find "$p" -xdev ! -type l ! -group "$g" | head -n 1)
+if [ -n "$bad" ]; then
+  echo "$bad is not owned by group $g after the change" >&2
+  exit 1
+fi
+echo "changed $files $((end - start))"`
+
+// populateFilesScript creates n empty files under d, a thousand per
+// subdirectory.
+const populateFilesScript = `set -e
+d=%[1]s; n=%[2]d
+mkdir -p "$d"
+seq 0 $((n - 1)) | awk -v d="$d" '{ print d "/" int($1 / 1000) }' | uniq | xargs mkdir -p
+seq 0 $((n - 1)) | awk -v d="$d" '{ print d "/" int($1 / 1000) "/f" $1 }' | xargs touch
+sync`
+
+type fsGroupResult struct {
+	// Files is the number of files and directories under the mount path.
+	Files    int
+	Skipped  bool
+	Duration time.Duration
+}
+
+func (r *fsGroupResult) String() string {
+	if r.Skipped {
+		return fmt.Sprintf("skipped by OnRootMismatch, %d files", r.Files)
+	}
+	perFile := time.Duration(0)
+	if r.Files > 0 {
+		perFile = r.Duration / time.Duration(r.Files)
+	}
+	return fmt.Sprintf("%d files in %v (%v/file)", r.Files, r.Duration.Round(time.Millisecond), perFile)
+}
+
+func validateFSGroupPolicy(policy string) error {
+	if policy != fsGroupPolicyAlways && policy != fsGroupPolicyOnRootMismatch {
+		return fmt.Errorf("invalid fsGroup change policy %q, must be %s or %s", policy, fsGroupPolicyAlways, fsGroupPolicyOnRootMismatch)
+	}
+	return nil
+}
+
+// applyFSGroup changes the group ownership and permissions of everything
+// under mountPath on instanceName the way kubelet does for fsGroup.
+func applyFSGroup(ctx context.Context, mountPath, instanceName string, gid int64, policy string) (*fsGroupResult, error) {
+	log.Printf("Applying fsGroup %d with policy %s to %q on %q\r\n", gid, policy, mountPath, instanceName)
+	defer fmt.Println("------------")
+
+	if err := validateFSGroupPolicy(policy); err != nil {
+		return nil, err
+	}
+	script := fmt.Sprintf(applyFSGroupScript, mountPath, gid, policy)
+	outputBytes, cmdErr := runOnInstance(ctx, script, instanceName)
+	if cmdErr != nil {
+		log.Printf(
+			"Failed to apply fsGroup %d to %q on %q. error: %v\r\n",
+			gid,
+			mountPath,
+			instanceName,
+			cmdErr)
+		return nil, cmdErr
+	}
+
+	result, err := parseFSGroupOutput(string(outputBytes))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("fsGroup %d on %q: %v\r\n", gid, mountPath, result)
+	return result, nil
+}
+
+// parseFSGroupOutput reads the summary line applyFSGroupScript ends with.
+func parseFSGroupOutput(output string) (*fsGroupResult, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) != 3 || (fields[0] != "changed" && fields[0] != "skipped") {
+		return nil, fmt.Errorf("unexpected fsGroup output %q", output)
+	}
+	files, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected fsGroup file count in %q: %v", output, err)
+	}
+	nanos, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected fsGroup duration in %q: %v", output, err)
+	}
+	return &fsGroupResult{
+		Files:    files,
+		Skipped:  fields[0] == "skipped",
+		Duration: time.Duration(nanos),
+	}, nil
+}
+
+// populateFiles creates count empty files under dir on instanceName.
+func populateFiles(ctx context.Context, dir, instanceName string, count int) error {
+	log.Printf("Creating %d files under %q on %q\r\n", count, dir, instanceName)
+	defer fmt.Println("------------")
+
+	_, cmdErr := runOnInstance(ctx, fmt.Sprintf(populateFilesScript, dir, count), instanceName)
+	if cmdErr != nil {
+		log.Printf(
+			"Failed to create %d files under %q on %q. error: %v\r\n",
+			count,
+			dir,
+			instanceName,
+			cmdErr)
+		return cmdErr
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseFSGroupOutput(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		result *fsGroupResult
+	}{
+		{name: "changed", output: "changed 1001 52000000\n", result: &fsGroupResult{Files: 1001, Duration: 52 * time.Millisecond}},
+		{name: "skipped", output: "skipped 1001 0\n", result: &fsGroupResult{Files: 1001, Skipped: true}},
+		{
+			name:   "after an ssh warning",
+			output: "Warning: Permanently added 'compute.4122945335926391813' (ED25519) to the list of known hosts.\r\nchanged 3 1500\r\n",
+			result: &fsGroupResult{Files: 3, Duration: 1500},
+		},
+		{name: "empty", output: ""},
+		{name: "unknown outcome", output: "unchanged 3 1500\n"},
+		{name: "missing duration", output: "changed 3\n"},
+		{name: "file count not a number", output: "changed three 1500\n"},
+		{name: "duration not a number", output: "changed 3 1.5ms\n"},
+		{name: "error last", output: "changed 3 1500\n/mnt/x is not owned by group 2000 after the change\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parseFSGroupOutput(tc.output)
+			if tc.result == nil {
+				if err == nil {
+					t.Errorf("parseFSGroupOutput(%q) = %+v, expected an error", tc.output, result)
+				}
+				return
+			}
+			if err != nil || *result != *tc.result {
+				t.Errorf("parseFSGroupOutput(%q) = %+v, %v; expected %+v", tc.output, result, err, tc.result)
+			}
+		})
+	}
+}
+
+func TestValidateFSGroupPolicy(t *testing.T) {
+	for policy, ok := range map[string]bool{
+		fsGroupPolicyAlways:         true,
+		fsGroupPolicyOnRootMismatch: true,
+		"":                          false,
+		"always":                    false,
+		"OnRootMismatch ":           false,
+		"Never":                     false,
+	} {
+		if err := validateFSGroupPolicy(policy); (err == nil) != ok {
+			t.Errorf("validateFSGroupPolicy(%q) = %v, expected ok=%v", policy, err, ok)
+		}
+	}
+	if _, err := applyFSGroup(context.Background(), "/mnt/x", "node-a", 2000, "Never"); err == nil {
+		t.Errorf("applyFSGroup ran with an invalid policy")
+	}
+}
+
+// localShellProvider runs instance commands in a local shell.
+type localShellProvider struct {
+	*fakeProvider
+}
+
+func (p localShellProvider) RunOnInstance(ctx context.Context, command, instanceName string) ([]byte, error) {
+	return executeCmd(ctx, "sh", "-c", command)
+}
+
+// TestApplyFSGroupPolicies runs applyFSGroupScript on a local directory with
+// the group of the test process, which it may always change files to.
+func TestApplyFSGroupPolicies(t *testing.T) {
+	if err := exec.Command("stat", "-c", "%g", os.TempDir()).Run(); err != nil {
+		t.Skipf("applyFSGroupScript needs GNU stat: %v", err)
+	}
+	ctx := context.Background()
+	useProvider(t, localShellProvider{newFakeProvider()})
+	gid := int64(os.Getgid())
+
+	newVolume := func() string {
+		dir := t.TempDir()
+		if err := os.Chmod(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "f"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	apply := func(dir, policy string) *fsGroupResult {
+		t.Helper()
+		result, err := applyFSGroup(ctx, dir, "local", gid, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// OnRootMismatch changes a volume whose root lacks the permissions.
+	dir := newVolume()
+	if result := apply(dir, fsGroupPolicyOnRootMismatch); result.Skipped || result.Files != 2 {
+		t.Errorf("first OnRootMismatch change is %+v, expected it to change 2 files", result)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSetgid == 0 || info.Mode().Perm()&0770 != 0770 {
+		t.Errorf("volume root mode is %v after the change, expected ug+rwx and setgid", info.Mode())
+	}
+	info, err = os.Stat(filepath.Join(dir, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0660 != 0660 {
+		t.Errorf("file mode is %v after the change, expected ug+rw", info.Mode())
+	}
+
+	// Once the root matches, OnRootMismatch skips while Always changes.
+	if result := apply(dir, fsGroupPolicyOnRootMismatch); !result.Skipped || result.Files != 2 || result.Duration != 0 {
+		t.Errorf("second OnRootMismatch change is %+v, expected it skipped", result)
+	}
+	if result := apply(dir, fsGroupPolicyAlways); result.Skipped {
+		t.Errorf("Always change is %+v, expected it not skipped", result)
+	}
+	if result := apply(newVolume(), fsGroupPolicyAlways); result.Skipped || result.Files != 2 {
+		t.Errorf("Always change of a new volume is %+v, expected it to change 2 files", result)
+	}
+}
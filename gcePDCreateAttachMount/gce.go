@@ -0,0 +1,304 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gceProvider manages GCE persistent disks with gcloud.
+type gceProvider struct{}
+
+func (gceProvider) Name() string {
+	return "gce"
+}
+
+func (gceProvider) CreateVolume(ctx context.Context, name string, spec diskSpec) error {
+	_, err := createPD(ctx, name, spec)
+	return err
+}
+
+func (gceProvider) DeleteVolume(ctx context.Context, name string) error {
+	return deletePD(ctx, name)
+}
+
+func (gceProvider) AttachVolume(ctx context.Context, name, instanceName string, readOnly bool) error {
+	return attachDisk(ctx, name, instanceName, readOnly)
+}
+
+func (gceProvider) DetachVolume(ctx context.Context, name, instanceName string) error {
+	return detachDisk(ctx, name, instanceName)
+}
+
+func (gceProvider) VerifyAttachment(ctx context.Context, name, instanceName string, attached, readOnly bool) error {
+	return verifyAttachment(ctx, name, instanceName, attached, readOnly)
+}
+
+func (gceProvider) VolumeUsers(ctx context.Context, name string) ([]string, error) {
+	disk, err := describeDisk(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, user := range disk.Users {
+		users = append(users, path.Base(user))
+	}
+	return users, nil
+}
+
+// DevicePath is the same on every instance: GCE names the by-id link after
+// the device name, which attachDisk sets to the disk name.
+func (gceProvider) DevicePath(name, instanceName string) (string, error) {
+	return getPDDevPath(name), nil
+}
+
+func (gceProvider) VolumeLabels(ctx context.Context, name string) (map[string]string, error) {
+	disk, err := describeDisk(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return disk.Labels, nil
+}
+
+func (gceProvider) CSIVolumeID(name string) (string, error) {
+	if zones := diskReplicaZones(name); zones != nil {
+		return fmt.Sprintf("projects/%s/regions/%s/disks/%s", testProjectID, zoneRegion(zones[0]), name), nil
+	}
+	return fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProjectID, *gceZone, name), nil
+}
+
+func (gceProvider) RunOnInstance(ctx context.Context, command, instanceName string) ([]byte, error) {
+	return executeRemoteGCloudCmd(ctx, command, instanceName)
+}
+
+func (gceProvider) CopyToInstance(ctx context.Context, localPath, remotePath, instanceName string) error {
+	cmdArgs := []string{
+		"compute",
+		"scp",
+		localPath,
+		"root@" + instanceName + ":" + remotePath}
+	_, err := executeGCloudCmd(ctx, cmdArgs)
+	return err
+}
+
+func (gceProvider) ValidateScenario(s scenario) error {
+	return nil
+}
+
+func (gceProvider) Preflight(ctx context.Context, checklist *preflightChecklist, instances []string) {
+	account, err := gcloudValue(ctx, []string{"auth", "list", "--filter=status:ACTIVE", "--format=value(account)"})
+	if err == nil && account == "" {
+		err = fmt.Errorf("no active gcloud account, run gcloud auth login")
+	}
+	checklist.add("gcloud auth", "active account "+account, err)
+
+	project, err := gcloudValue(ctx, []string{"config", "get-value", "project"})
+	if err == nil && project != testProjectID {
+		err = fmt.Errorf("gcloud project is %q, the tool is configured for %q", project, testProjectID)
+	}
+	checklist.add("gcloud project", project, err)
+
+	for _, instanceName := range instances {
+		checkGCEInstance(ctx, checklist, instanceName)
+	}
+
+	checkDiskQuota(ctx, checklist, defaultScenario().Disk)
+}
+
+func createPD(ctx context.Context, pdName string, spec diskSpec) (string, error) {
+	log.Printf("Attempting to create PD %q with spec %+v\r\n", pdName, spec)
+	defer fmt.Println("------------")
+
+	cmdArgs := []string{
+		"compute",
+		"--quiet",
+		"--project=" + testProjectID,
+		"disks",
+		"create",
+		fmt.Sprintf("--size=%dGB", spec.SizeGB)}
+	registerDisk(pdName, spec)
+	location := diskLocationFlag(pdName)
+	cmdArgs = append(cmdArgs, location)
+	if len(spec.ReplicaZones) > 0 {
+		cmdArgs = append(cmdArgs, "--replica-zones="+strings.Join(spec.ReplicaZones, ","))
+	}
+	if spec.Type != "" {
+		cmdArgs = append(cmdArgs, "--type="+spec.Type)
+	}
+	if spec.Image != "" {
+		cmdArgs = append(cmdArgs, "--image="+spec.Image)
+	}
+	if spec.Snapshot != "" {
+		cmdArgs = append(cmdArgs, "--source-snapshot="+spec.Snapshot)
+	}
+	if len(spec.Labels) > 0 {
+		labels := make([]string, 0, len(spec.Labels))
+		for key, value := range spec.Labels {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		cmdArgs = append(cmdArgs, "--labels="+strings.Join(labels, ","))
+	}
+	if spec.PhysicalBlockSizeBytes != 0 {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("--physical-block-size=%d", spec.PhysicalBlockSizeBytes))
+	}
+	if spec.KMSKey != "" {
+		cmdArgs = append(cmdArgs, "--kms-key="+spec.KMSKey)
+	}
+	cmdArgs = append(cmdArgs, pdName)
+	start := time.Now()
+	outputBytes, cmdErr := executeGCloudOperation(ctx, "create", location, cmdArgs)
+	metrics.observeOperation("create", start, cmdErr)
+	if cmdErr != nil {
+		log.Printf(
+			"Creating PD %q failed with %v\r\n",
+			pdName,
+			cmdErr)
+		return "", cmdErr
+	}
+
+	log.Printf(
+		"Created PD %q successfully. Output: %q\r\n",
+		pdName,
+		string(outputBytes))
+	return pdName, nil
+}
+
+func deletePD(ctx context.Context, pdName string) error {
+	log.Printf("Attempting to delete PD %q\r\n", pdName)
+	defer fmt.Println("------------")
+
+	cmdArgs := []string{
+		"compute",
+		"--quiet",
+		"--project=" + testProjectID,
+		"disks",
+		"delete",
+		diskLocationFlag(pdName),
+		pdName}
+	start := time.Now()
+	outputBytes, cmdErr := executeGCloudOperation(ctx, "delete", diskLocationFlag(pdName), cmdArgs)
+	metrics.observeOperation("delete", start, cmdErr)
+	if cmdErr != nil {
+		log.Printf(
+			"Deleting PD %q failed with %v\r\n",
+			pdName,
+			cmdErr)
+		return cmdErr
+	}
+
+	log.Printf(
+		"Deleting PD %q succeeded. Output: %q\r\n",
+		pdName,
+		string(outputBytes))
+	return nil
+}
+
+func attachDisk(ctx context.Context, pdName, instanceName string, readonly bool) error {
+	mode := "ro"
+	if !readonly {
+		mode = "rw"
+	}
+
+	log.Printf("Attempting to attach PD %q to %q as %q\r\n", pdName, instanceName, mode)
+	defer fmt.Println("------------")
+
+	cmdArgs := []string{
+		"compute",
+		"instances",
+		"--quiet",
+		"attach-disk",
+		instanceName,
+		"--disk=" + attachDiskArg(pdName, instanceName),
+		"--device-name=" + pdName,
+		"--mode=" + mode,
+		"--zone=" + instanceZone(instanceName)}
+	if diskReplicaZones(pdName) != nil {
+		cmdArgs = append(cmdArgs, "--disk-scope=regional")
+	}
+	start := time.Now()
+	outputBytes, cmdErr := executeGCloudOperation(ctx, "attach", "--zone="+instanceZone(instanceName), cmdArgs)
+	metrics.observeOperation("attach", start, cmdErr)
+	if cmdErr != nil {
+		log.Printf(
+			"Attaching PD %q to %q as %q failed with %v\r\n",
+			pdName,
+			instanceName,
+			mode,
+			cmdErr)
+		return cmdErr
+	}
+
+	log.Printf(
+		"Attaching PD %q to %q as %q succeeded. Output: %q\r\n",
+		pdName,
+		instanceName,
+		mode,
+		string(outputBytes))
+	return nil
+}
+
+// attachDiskArg returns the --disk value attaching pdName to instanceName.
+// gcloud looks a bare disk name up in the instance's zone, so a zonal PD in
+// another zone is named by its full path to reach GCE's own zone check.
+func attachDiskArg(pdName, instanceName string) string {
+	if diskReplicaZones(pdName) == nil && instanceZone(instanceName) != *gceZone {
+		return fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProjectID, *gceZone, pdName)
+	}
+	return pdName
+}
+
+func detachDisk(ctx context.Context, pdName, instanceName string) error {
+	log.Printf("Attempting to detach PD %q from %q\r\n", pdName, instanceName)
+	defer fmt.Println("------------")
+
+	cmdArgs := []string{
+		"compute",
+		"instances",
+		"--quiet",
+		"detach-disk",
+		instanceName,
+		"--disk=" + pdName,
+		"--zone=" + instanceZone(instanceName)}
+	if diskReplicaZones(pdName) != nil {
+		cmdArgs = append(cmdArgs, "--disk-scope=regional")
+	}
+	start := time.Now()
+	outputBytes, cmdErr := executeGCloudOperation(ctx, "detach", "--zone="+instanceZone(instanceName), cmdArgs)
+	metrics.observeOperation("detach", start, cmdErr)
+	if cmdErr != nil {
+		log.Printf(
+			"Detaching PD %q from %q failed with %v\r\n",
+			pdName,
+			instanceName,
+			cmdErr)
+		return cmdErr
+	}
+
+	log.Printf(
+		"Detaching PD %q from %q succeeded. Output: %q\r\n",
+		pdName,
+		instanceName,
+		string(outputBytes))
+	return nil
+}
@@ -18,8 +18,12 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"path"
 	"strings"
@@ -39,422 +43,203 @@ const (
 	finalMountPath       = "/var/lib/saad/pods/volumes/kubernetes.io~gce-pd/"
 )
 
-func main() {
-	// Create a new PD
-	t := time.Now()
-	generatedPdName := fmt.Sprintf("test-%s", t.Format("20060102150405"))
-	pdName, createErr := createPDWithRetry(generatedPdName)
-	if createErr != nil {
-		log.Fatalln(createErr)
-	}
-
-	devPath := getPDDevPath(pdName)
-	devGlobalMountPath := getDeviceGlobalMountPath(pdName)
-	finalMountPath := getFinalMountPath(pdName)
-	fatalError := false
-
-	// Attach PD RW to host0
-	log.Println("***Attach PD RW to host0")
-	attachErr := attachDiskWithRetry(pdName, testInstance0Name, false /* readonly */)
-	if attachErr != nil {
-		log.Fatalln(attachErr)
-		fatalError = true
-	}
-
-	// ls disks on host0
-	o, _ := executeRemoteGCloudCmd("ls /dev/disk/by-id/", testInstance0Name)
-	log.Printf("ls /dev/disk/by-id/\r\n%v", string(o))
-
-	// Format attached disk and mount PD to global mount point on host0
-	mountDevErr := mountDevice(devPath, devGlobalMountPath, testInstance0Name, testFSType, false /* readOnly */)
-	if mountDevErr != nil {
-		log.Println(mountDevErr)
-		fatalError = true
-	}
-
-	// Bind Mount global mount point to final mount point on host0
-	bindMountErr := bindMountToFinalPath(devGlobalMountPath, finalMountPath, testInstance0Name, false /* readOnly */)
-	if bindMountErr != nil {
-		log.Println(bindMountErr)
-		fatalError = true
-	}
-
-	fileContent := "hello world"
-	fileName := "mytest.log"
-	_, writeFileErr := WriteContentToFile(fileContent, path.Join(finalMountPath, fileName), testInstance0Name)
-	if writeFileErr != nil {
-		log.Println(writeFileErr)
-		fatalError = true
-	}
-
-	readFileContent, readFileErr := ReadContentsFromFile(path.Join(finalMountPath, fileName), testInstance0Name)
-	if readFileErr != nil {
-		log.Println(readFileErr)
-		fatalError = true
-	}
-
-	if fileContent != readFileContent {
-		log.Printf("Read file content differs. Expected: <%s> Actual: <%s>", fileContent, readFileContent)
-		fatalError = true
-	}
-
-	log.Println("Sleeping before unmount")
-	time.Sleep(3 * time.Second)
+var (
+	metricsPort      = flag.Int("metrics-port", 0, "Port to serve Prometheus metrics on while running. 0 disables the endpoint.")
+	soak             = flag.Bool("soak", false, "Repeat the PD lifecycle until interrupted, recording failures instead of exiting on the first one.")
+	matrixConfigPath = flag.String("matrix-config", "matrix.json", "Path to the JSON file declaring the test matrix axes for the matrix subcommand.")
+	gcloudPath       = flag.String("gcloud", "gcloud", "gcloud binary the gce provider runs.")
+)
 
-	// Unmount bind mount on host 0
-	removeBindMountErr := removeBindMount(finalMountPath, testInstance0Name)
-	if removeBindMountErr != nil {
-		log.Println(removeBindMountErr)
-	}
+func main() {
+	flag.Parse()
 
-	// Unmount PD from global mount point on host 0
-	unmountDevErr := unmountDevice(devGlobalMountPath, testInstance0Name)
-	if unmountDevErr != nil {
-		log.Println(unmountDevErr)
+	if err := initRunID(); err != nil {
+		log.Fatalln(err)
 	}
-
-	// Detach PD from host0
-	detachErr := detachDiskWithRetry(pdName, testInstance0Name)
-	if detachErr != nil {
-		log.Println(detachErr)
-		fatalError = true
+	p, err := newVolumeProvider(*providerName)
+	if err != nil {
+		log.Fatalln(err)
 	}
-
-	log.Println("***Detached PD RW from host0")
-
-	// Attach PD RW to host1
-	log.Println("***Attaching PD RW to host1")
-	attachErr = attachDiskWithRetry(pdName, testInstance1Name, false /* readonly */)
-	if attachErr != nil {
-		log.Println(attachErr)
-		fatalError = true
+	provider = runGuardedProvider{p}
+	if err := validateUnmountFallback(); err != nil {
+		log.Fatalln(err)
 	}
+	ctx := context.Background()
 
-	// Mount PD RW to global mount point on host1
-	mountDevErr = mountDevice(devPath, devGlobalMountPath, testInstance1Name, testFSType, false /* readOnly */)
-	if mountDevErr != nil {
-		log.Println(mountDevErr)
-		fatalError = true
+	if *metricsPort > 0 {
+		startMetricsServer(*metricsPort)
 	}
 
-	// Bind Mount RW global mount point to final mount point on host1
-	bindMountErr = bindMountToFinalPath(devGlobalMountPath, finalMountPath, testInstance1Name, false /* readOnly */)
-	if bindMountErr != nil {
-		log.Println(bindMountErr)
-		fatalError = true
+	switch cmd := flag.Arg(0); cmd {
+	case "", "run":
+		runLifecycleLoop(ctx)
+	case "matrix":
+		if err := runMatrix(ctx, *matrixConfigPath); err != nil {
+			log.Fatalln(err)
+		}
+	case "stress":
+		if err := runStress(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "attach-limit":
+		if err := runAttachLimit(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "preflight":
+		if err := runPreflight(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "resume":
+		result, err := runResume(ctx, flag.Arg(1))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printRunReport(os.Stdout, result)
+		if result.Err != nil {
+			log.Fatalf("Fatal error\r\n")
+		}
+	case "teardown":
+		if err := runTeardown(ctx, flag.Arg(1)); err != nil {
+			log.Fatalln(err)
+		}
+	case "model":
+		if err := runModel(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "k8s":
+		if err := runK8s(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "csi":
+		if err := runCSI(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "cross-zone":
+		if err := runCrossZone(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "negative":
+		if err := runNegative(ctx); err != nil {
+			log.Fatalln(err)
+		}
+	case "reconcile":
+		if err := runReconcile(ctx, flag.Arg(1)); err != nil {
+			log.Fatalln(err)
+		}
+	case "report":
+		if err := runReport(); err != nil {
+			log.Fatalln(err)
+		}
+	case "events":
+		if err := runEvents(flag.Arg(1)); err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		log.Fatalf("Unknown subcommand %q\r\n", cmd)
 	}
+}
 
-	readFileContent, readFileErr = ReadContentsFromFile(path.Join(finalMountPath, fileName), testInstance1Name)
-	if readFileErr != nil {
-		log.Println(readFileErr)
-		fatalError = true
-	}
-
-	if fileContent != readFileContent {
-		log.Printf("Read file content differs. Expected: <%s> Actual: <%s>", fileContent, readFileContent)
-		fatalError = true
-	}
-
-	log.Println("Sleeping before unmount")
-	time.Sleep(10 * time.Second)
-
-	// Unmount RW bind mount on host1
-	removeBindMountErr = removeBindMount(finalMountPath, testInstance1Name)
-	if removeBindMountErr != nil {
-		log.Println(removeBindMountErr)
-	}
-
-	// Unmount PD from global mount point on host1
-	unmountDevErr = unmountDevice(devGlobalMountPath, testInstance1Name)
-	if unmountDevErr != nil {
-		log.Println(unmountDevErr)
-	}
-
-	// Detach PD RW to host1
-	detachErr = detachDiskWithRetry(pdName, testInstance1Name)
-	if detachErr != nil {
-		log.Println(detachErr)
-		fatalError = true
+func runLifecycleLoop(ctx context.Context) {
+	for {
+		result := runScenario(ctx, defaultScenario(), generatePdName())
+		printRunReport(os.Stdout, result)
+		if !*soak {
+			if result.Err != nil {
+				log.Fatalf("Fatal error\r\n")
+			}
+			return
+		}
+		if result.Err != nil {
+			log.Printf("Lifecycle run failed: %v\r\n", result.Err)
+		}
 	}
-	log.Println("***Detached PD RW from host1")
-
-	// // Attach PD RO to host0
-	// log.Println("***Attaching PD RO to host0")
-	// attachErr = attachDiskWithRetry(pdName, testInstance0Name, true /* readonly */)
-	// if attachErr != nil {
-	// 	log.Println(attachErr)
-	// 	fatalError = true
-	// }
+}
 
-	// // Mount PD RO to global mount point on host0
-	// mountDevErr = mountDevice(devPath, devGlobalMountPath, testInstance0Name, testFSType, true /* readOnly */)
-	// if mountDevErr != nil {
-	// 	log.Println(mountDevErr)
-	// 	fatalError = true
-	// }
-
-	// // Bind Mount RO global mount point to final mount point on host0
-	// bindMountErr = bindMountToFinalPath(devGlobalMountPath, finalMountPath, testInstance0Name, true /* readOnly */)
-	// if bindMountErr != nil {
-	// 	log.Println(bindMountErr)
-	// 	fatalError = true
-	// }
-
-	// // Attach PD RO to host1
-	// log.Println("***Attaching PD RO to host1")
-	// attachErr = attachDiskWithRetry(pdName, testInstance1Name, true /* readonly */)
-	// if attachErr != nil {
-	// 	log.Println(attachErr)
-	// 	fatalError = true
-	// }
-
-	// // Mount PD RO to global mount point on host1
-	// mountDevErr = mountDevice(devPath, devGlobalMountPath, testInstance1Name, testFSType, true /* readOnly */)
-	// if mountDevErr != nil {
-	// 	log.Println(mountDevErr)
-	// 	fatalError = true
-	// }
-
-	// // Bind Mount RO global mount point to final mount point on host1
-	// bindMountErr = bindMountToFinalPath(devGlobalMountPath, finalMountPath, testInstance1Name, true /* readOnly */)
-	// if bindMountErr != nil {
-	// 	log.Println(bindMountErr)
-	// 	fatalError = true
-	// }
-
-	// // Unmount RO bind mount on host0
-	// removeBindMountErr = removeBindMount(finalMountPath, testInstance0Name)
-	// if removeBindMountErr != nil {
-	// 	log.Println(removeBindMountErr)
-	// }
-
-	// // Unmount PD from global mount point on host0
-	// unmountDevErr = unmountDevice(devGlobalMountPath, testInstance0Name)
-	// if unmountDevErr != nil {
-	// 	log.Println(unmountDevErr)
-	// }
-
-	// // Detach PD RO to host0
-	// detachErr = detachDiskWithRetry(pdName, testInstance0Name)
-	// if detachErr != nil {
-	// 	log.Println(detachErr)
-	// 	fatalError = true
-	// }
-	// log.Println("***Detached PD RO to host0")
-
-	// // Unmount RO bind mount on host1
-	// removeBindMountErr = removeBindMount(finalMountPath, testInstance1Name)
-	// if removeBindMountErr != nil {
-	// 	log.Println(removeBindMountErr)
-	// }
-
-	// // Unmount PD from global mount point on host1
-	// unmountDevErr = unmountDevice(devGlobalMountPath, testInstance1Name)
-	// if unmountDevErr != nil {
-	// 	log.Println(unmountDevErr)
-	// }
-
-	// // Detach PD RO to host1
-	// detachErr = detachDiskWithRetry(pdName, testInstance1Name)
-	// if detachErr != nil {
-	// 	log.Println(detachErr)
-	// 	fatalError = true
-	// }
-	// log.Println("***Detached PD RO to host1")
-
-	// Delete PD on completion
-	deleteErr := deletePDWithRetry(pdName)
-	if deleteErr != nil {
-		log.Println(deleteErr)
-		fatalError = true
-	}
-
-	if fatalError {
-		log.Fatalf("Fatal error\r\n")
-	}
-}
-
-func createPDWithRetry(pdName string) (string, error) {
-	newDiskName := ""
-	var err error
+func createPDWithRetry(ctx context.Context, pdName string, spec diskSpec) (newDiskName string, err error) {
+	ctx, sp := startSpan(ctx, "create", spanAttrs{Disk: pdName})
+	defer func() { sp.end(err) }()
+	attempt := 0
 	for start := time.Now(); time.Since(start) < 180*time.Second; time.Sleep(5 * time.Second) {
-		if newDiskName, err = createPD(pdName); err != nil {
+		attempt++
+		if err = traced(ctx, fmt.Sprintf("attempt %d", attempt), spanAttrs{}, func(ctx context.Context) error { return provider.CreateVolume(ctx, pdName, spec) }); err != nil {
 			log.Printf("Couldn't create a new PD. Sleeping 5 seconds (%v)\r\n", err)
 			continue
 		}
+		newDiskName = pdName
 		log.Printf("Successfully created a new PD: %q.\r\n", newDiskName)
 		break
 	}
 	return newDiskName, err
 }
 
-func createPD(pdName string) (string, error) {
-	log.Printf("Attempting to create PD %q\r\n", pdName)
-	defer fmt.Println("------------")
-
-	cmdArgs := []string{
-		"compute",
-		"--quiet",
-		"--project=" + testProjectID,
-		"disks",
-		"create",
-		"--zone=" + testProjectZone,
-		"--size=10GB",
-		pdName}
-	outputBytes, cmdErr := executeGCloudCmd(cmdArgs)
-	if cmdErr != nil {
-		log.Printf(
-			"Creating PD %q failed with %v\r\n",
-			pdName,
-			cmdErr)
-		return "", cmdErr
-	}
-
-	log.Printf(
-		"Created PD %q successfully. Output: %q\r\n",
-		pdName,
-		string(outputBytes))
-	return pdName, nil
-}
-
-func deletePDWithRetry(pdName string) error {
-	var err error
+func deletePDWithRetry(ctx context.Context, pdName string) (err error) {
+	ctx, sp := startSpan(ctx, "delete", spanAttrs{Disk: pdName})
+	defer func() { sp.end(err) }()
+	attempt := 0
 	for start := time.Now(); time.Since(start) < 180*time.Second; time.Sleep(5 * time.Second) {
-		if err = deletePD(pdName); err != nil {
+		attempt++
+		if err = traced(ctx, fmt.Sprintf("attempt %d", attempt), spanAttrs{}, func(ctx context.Context) error { return provider.DeleteVolume(ctx, pdName) }); err != nil {
+			if errors.Is(err, errForeignVolume) {
+				break
+			}
 			log.Printf("Couldn't delete PD %q. Sleeping 5 seconds (%v)\r\n", pdName, err)
 			continue
 		}
 		log.Printf("Deleted PD %v", pdName)
 		break
 	}
+	metrics.setDiskLeaked(pdName, err != nil)
 	return err
 }
 
-func deletePD(pdName string) error {
-	log.Printf("Attempting to delete PD %q\r\n", pdName)
-	defer fmt.Println("------------")
-
-	cmdArgs := []string{
-		"compute",
-		"--quiet",
-		"--project=" + testProjectID,
-		"disks",
-		"delete",
-		"--zone=" + testProjectZone,
-		pdName}
-	outputBytes, cmdErr := executeGCloudCmd(cmdArgs)
-	if cmdErr != nil {
-		log.Printf(
-			"Deleting PD %q failed with %v\r\n",
-			pdName,
-			cmdErr)
-		return cmdErr
-	}
-
-	log.Printf(
-		"Deleting PD %q succeeded. Output: %q\r\n",
-		pdName,
-		string(outputBytes))
-	return nil
-}
-
-func attachDiskWithRetry(pdName, instanceName string, readonly bool) error {
-	var err error
+func attachDiskWithRetry(ctx context.Context, pdName, instanceName string, readonly bool) (err error) {
+	ctx, sp := startSpan(ctx, "attach", spanAttrs{Disk: pdName, Instance: instanceName})
+	defer func() { sp.end(err) }()
+	attempt := 0
 	for start := time.Now(); time.Since(start) < 180*time.Second; time.Sleep(5 * time.Second) {
-		if err = attachDisk(pdName, instanceName, readonly); err != nil {
+		attempt++
+		if err = traced(ctx, fmt.Sprintf("attempt %d", attempt), spanAttrs{}, func(ctx context.Context) error { return provider.AttachVolume(ctx, pdName, instanceName, readonly) }); err != nil {
+			if errors.Is(err, errForeignVolume) {
+				break
+			}
 			log.Printf("Couldn't attach PD %q to %q. Sleeping 5 seconds (%v)\r\n", pdName, instanceName, err)
 			continue
 		}
 		log.Printf("Successfully attach PD %q to %q.\r\n", pdName, instanceName)
 		break
 	}
-	return err
-}
-
-func attachDisk(pdName, instanceName string, readonly bool) error {
-	mode := "ro"
-	if !readonly {
-		mode = "rw"
-	}
-
-	log.Printf("Attempting to attach PD %q to %q as %q\r\n", pdName, instanceName, mode)
-	defer fmt.Println("------------")
-
-	cmdArgs := []string{
-		"compute",
-		"instances",
-		"--quiet",
-		"attach-disk",
-		instanceName,
-		"--disk=" + pdName,
-		"--device-name=" + pdName,
-		"--mode=" + mode,
-		"--zone=" + testProjectZone}
-	outputBytes, cmdErr := executeGCloudCmd(cmdArgs)
-	if cmdErr != nil {
-		log.Printf(
-			"Attaching PD %q to %q as %q failed with %v\r\n",
-			pdName,
-			instanceName,
-			mode,
-			cmdErr)
-		return cmdErr
+	if err != nil {
+		return err
 	}
-
-	log.Printf(
-		"Attaching PD %q to %q as %q succeeded. Output: %q\r\n",
-		pdName,
-		instanceName,
-		mode,
-		string(outputBytes))
-	return nil
+	// The provider reporting success does not guarantee the cloud agrees.
+	return provider.VerifyAttachment(ctx, pdName, instanceName, true /* attached */, readonly)
 }
 
-func detachDiskWithRetry(pdName, instanceName string) error {
-	var err error
+func detachDiskWithRetry(ctx context.Context, pdName, instanceName string) (err error) {
+	ctx, sp := startSpan(ctx, "detach", spanAttrs{Disk: pdName, Instance: instanceName})
+	defer func() { sp.end(err) }()
+	attempt := 0
 	for start := time.Now(); time.Since(start) < 180*time.Second; time.Sleep(5 * time.Second) {
-		if err = detachDisk(pdName, instanceName); err != nil {
+		attempt++
+		if err = traced(ctx, fmt.Sprintf("attempt %d", attempt), spanAttrs{}, func(ctx context.Context) error { return provider.DetachVolume(ctx, pdName, instanceName) }); err != nil {
+			if errors.Is(err, errForeignVolume) {
+				break
+			}
 			log.Printf("Couldn't detach PD %q to %q. Sleeping 5 seconds (%v)\r\n", pdName, instanceName, err)
 			continue
 		}
 		log.Printf("Successfully detach PD %q to %q.\r\n", pdName, instanceName)
 		break
 	}
-	return err
-}
-
-func detachDisk(pdName, instanceName string) error {
-	log.Printf("Attempting to detach PD %q from %q\r\n", pdName, instanceName)
-	defer fmt.Println("------------")
-
-	cmdArgs := []string{
-		"compute",
-		"instances",
-		"--quiet",
-		"detach-disk",
-		instanceName,
-		"--disk=" + pdName,
-		"--zone=" + testProjectZone}
-	outputBytes, cmdErr := executeGCloudCmd(cmdArgs)
-	if cmdErr != nil {
-		log.Printf(
-			"Detaching PD %q from %q failed with %v\r\n",
-			pdName,
-			instanceName,
-			cmdErr)
-		return cmdErr
+	if err != nil {
+		return err
 	}
-
-	log.Printf(
-		"Detaching PD %q from %q succeeded. Output: %q\r\n",
-		pdName,
-		instanceName,
-		string(outputBytes))
-	return nil
+	// The provider reporting success does not guarantee the cloud agrees.
+	return provider.VerifyAttachment(ctx, pdName, instanceName, false /* attached */, false /* readOnly */)
 }
 
-func bindMountToFinalPath(deviceMountPath, finalMountPath, instanceName string, readOnly bool) error {
-	if _, err := runMkDir(finalMountPath, instanceName); err != nil {
+func bindMountToFinalPath(ctx context.Context, deviceMountPath, finalMountPath, instanceName string, readOnly bool, mountOptions []string) error {
+	if _, err := runMkDir(ctx, finalMountPath, instanceName); err != nil {
 		return err
 	}
 
@@ -463,27 +248,30 @@ func bindMountToFinalPath(deviceMountPath, finalMountPath, instanceName string,
 	if readOnly {
 		options = append(options, "ro")
 	}
+	options = append(options, mountOptions...)
 
-	if _, err := mount(deviceMountPath, finalMountPath, instanceName, "" /* fstype */, options); err != nil {
-		unmount(finalMountPath, instanceName)
-		runRmDir(finalMountPath, instanceName)
+	if _, err := mount(ctx, deviceMountPath, finalMountPath, instanceName, "" /* fstype */, options); err != nil {
+		unmount(ctx, finalMountPath, instanceName)
+		runRmDir(ctx, finalMountPath, instanceName)
 		return err
 	}
 	log.Printf("Successfully bind mounted %q to %q\r\n", finalMountPath, deviceMountPath)
 	return nil
 }
 
-func removeBindMount(finalMountPath, instanceName string) error {
-	_, err := unmount(finalMountPath, instanceName)
-	runRmDir(finalMountPath, instanceName)
+// removeBindMount unmounts and removes finalMountPath. It returns how the
+// unmount went if the mount was busy, or "".
+func removeBindMount(ctx context.Context, finalMountPath, instanceName string) (string, error) {
+	_, report, err := unmount(ctx, finalMountPath, instanceName)
+	runRmDir(ctx, finalMountPath, instanceName)
 	if err == nil {
 		log.Printf("Successfully removed bind mount %q\r\n", finalMountPath)
 	}
-	return err
+	return report, err
 }
 
-func mountDevice(devicePath, deviceMountPath, instanceName, fstype string, readOnly bool) error {
-	if _, err := runMkDir(deviceMountPath, instanceName); err != nil {
+func mountDevice(ctx context.Context, devicePath, deviceMountPath, instanceName, fstype string, readOnly bool, mountOptions []string) error {
+	if _, err := runMkDir(ctx, deviceMountPath, instanceName); err != nil {
 		return err
 	}
 
@@ -491,29 +279,32 @@ func mountDevice(devicePath, deviceMountPath, instanceName, fstype string, readO
 	if readOnly {
 		options = append(options, "ro")
 	}
+	options = append(options, mountOptions...)
 
-	if _, err := formatAndMount(devicePath, deviceMountPath, instanceName, fstype, options); err != nil {
-		runRmDir(deviceMountPath, instanceName)
+	if _, err := formatAndMount(ctx, devicePath, deviceMountPath, instanceName, fstype, options); err != nil {
+		runRmDir(ctx, deviceMountPath, instanceName)
 		return err
 	}
 	log.Printf("Successfully mounted %q to %q\r\n", deviceMountPath, devicePath)
 	return nil
 }
 
-func unmountDevice(mountPath, instanceName string) error {
-	_, err := unmount(mountPath, instanceName)
-	runRmDir(mountPath, instanceName)
+// unmountDevice unmounts and removes mountPath. It returns how the unmount
+// went if the mount was busy, or "".
+func unmountDevice(ctx context.Context, mountPath, instanceName string) (string, error) {
+	_, report, err := unmount(ctx, mountPath, instanceName)
+	runRmDir(ctx, mountPath, instanceName)
 	if err == nil {
 		log.Printf("Successfully unmounted %q\r\n", mountPath)
 	}
-	return err
+	return report, err
 }
 
-func formatAndMount(devPath, mountPath, instanceName, fstype string, options []string) ([]byte, error) {
+func formatAndMount(ctx context.Context, devPath, mountPath, instanceName, fstype string, options []string) ([]byte, error) {
 	// Don't attempt to format if mounting as readonly. Go straight to mounting.
 	for _, option := range options {
 		if option == "ro" {
-			_, err := mount(devPath, mountPath, instanceName, fstype, options)
+			_, err := mount(ctx, devPath, mountPath, instanceName, fstype, options)
 			if err == nil {
 				log.Printf("Successfully mounted %q to %q\r\n", mountPath, devPath)
 			}
@@ -524,7 +315,7 @@ func formatAndMount(devPath, mountPath, instanceName, fstype string, options []s
 	options = append(options, "defaults")
 
 	// Run fsck on the disk to fix repairable issues
-	outputBytes, err := runFsck(devPath, instanceName)
+	outputBytes, err := runFsck(ctx, devPath, instanceName)
 	if err != nil {
 		if strings.Contains(err.Error(), "exist status 1") {
 			// exit status 1 -- 'fsck' found errors and corrected them
@@ -539,17 +330,17 @@ func formatAndMount(devPath, mountPath, instanceName, fstype string, options []s
 	}
 
 	// Try to mount the disk
-	_, err = mount(devPath, mountPath, instanceName, fstype, options)
+	_, err = mount(ctx, devPath, mountPath, instanceName, fstype, options)
 	if err != nil {
 		// It is possible that this disk is not formatted. Double check using diskLooksUnformatted
-		notFormatted, err := diskLooksUnformatted(devPath, instanceName)
+		notFormatted, err := diskLooksUnformatted(ctx, devPath, instanceName)
 		if err == nil && notFormatted {
 			log.Printf("Disk looks unformated, will attempt to format it.")
-			_, err := format(devPath, instanceName, fstype)
+			_, err := format(ctx, devPath, instanceName, fstype)
 			if err == nil {
 				// the disk has been formatted successfully try to mount it again.
 				log.Printf("Disk formated successfully, will attempt to mount it.")
-				_, err = mount(devPath, mountPath, instanceName, fstype, options)
+				_, err = mount(ctx, devPath, mountPath, instanceName, fstype, options)
 				if err == nil {
 					log.Printf("Successfully formatAndMount %q to %q\r\n", mountPath, devPath)
 				}
@@ -562,8 +353,8 @@ func formatAndMount(devPath, mountPath, instanceName, fstype string, options []s
 	return nil, err
 }
 
-func format(devPath, instanceName string, fstype string) ([]byte, error) {
-	log.Printf("Attempting to format %q on %q with fstype %q and options %v\r\n", devPath, instanceName, fstype)
+func format(ctx context.Context, devPath, instanceName string, fstype string) ([]byte, error) {
+	log.Printf("Attempting to format %q on %q with fstype %q\r\n", devPath, instanceName, fstype)
 	defer fmt.Println("------------")
 
 	formatCmd := "mkfs." + fstype + " " + devPath
@@ -575,10 +366,12 @@ func format(devPath, instanceName string, fstype string) ([]byte, error) {
 		formatCmd = "mkfs." + fstype + " -E lazy_itable_init=0,lazy_journal_init=0 -F " + devPath
 	}
 
-	outputBytes, cmdErr := executeRemoteGCloudCmd(formatCmd, instanceName)
+	start := time.Now()
+	outputBytes, cmdErr := runOnInstance(ctx, formatCmd, instanceName)
+	metrics.observeOperation("format", start, cmdErr)
 	if cmdErr != nil {
 		log.Printf(
-			"Failed to format %q on %q with fstype %q and options %v. error: %v\r\n",
+			"Failed to format %q on %q with fstype %q. error: %v\r\n",
 			devPath,
 			instanceName,
 			fstype,
@@ -590,26 +383,41 @@ func format(devPath, instanceName string, fstype string) ([]byte, error) {
 	return outputBytes, nil
 }
 
-func mount(devPath, mountPath, instanceName string, fstype string, options []string) ([]byte, error) {
+func mount(ctx context.Context, devPath, mountPath, instanceName string, fstype string, options []string) ([]byte, error) {
 	bind, bindRemountOpts := isBind(options)
 
 	if bind {
-		outputBytes, err := doMount(devPath, mountPath, instanceName, fstype, []string{"bind"})
-		if err != nil {
+		outputBytes, err := doMount(ctx, devPath, mountPath, instanceName, fstype, []string{"bind"})
+		if err != nil || len(bindRemountOpts) == 2 {
 			return outputBytes, err
 		}
-		return doMount(devPath, mountPath, instanceName, fstype, bindRemountOpts)
+		return doMount(ctx, devPath, mountPath, instanceName, fstype, bindRemountOpts)
 	}
 
-	return doMount(devPath, mountPath, instanceName, fstype, options)
+	return doMount(ctx, devPath, mountPath, instanceName, fstype, options)
 }
 
-func unmount(mountPath, instanceName string) ([]byte, error) {
-	log.Printf("Attempting to unmount %q on %q \r\n", mountPath, instanceName)
+// unmount unmounts mountPath. If the mount was busy, report says which
+// processes held it and how it was finally unmounted.
+func unmount(ctx context.Context, mountPath, instanceName string) (outputBytes []byte, report string, err error) {
+	outputBytes, err = doUnmount(ctx, mountPath, instanceName, "" /* flags */)
+	if err != nil && classifyError(err) == errClassBusy {
+		return unmountBusy(ctx, mountPath, instanceName, outputBytes, err)
+	}
+	return outputBytes, "", err
+}
+
+func doUnmount(ctx context.Context, mountPath, instanceName, flags string) ([]byte, error) {
+	log.Printf("Attempting to unmount %q on %q with flags %q\r\n", mountPath, instanceName, flags)
 	defer fmt.Println("------------")
 
 	unmountCmd := "umount " + mountPath
-	outputBytes, cmdErr := executeRemoteGCloudCmd(unmountCmd, instanceName)
+	if len(flags) > 0 {
+		unmountCmd = "umount " + flags + " " + mountPath
+	}
+	start := time.Now()
+	outputBytes, cmdErr := runOnInstance(ctx, unmountCmd, instanceName)
+	metrics.observeOperation("unmount", start, cmdErr)
 	if cmdErr != nil {
 		log.Printf(
 			"Failed to unmount %q on %q. error: %v\r\n",
@@ -623,8 +431,12 @@ func unmount(mountPath, instanceName string) ([]byte, error) {
 	return outputBytes, nil
 }
 
+// isBind reports whether options ask for a bind mount, and returns the
+// options of the remount that applies its per-mount flags. Superblock options
+// are shared with the source mount and cannot be changed through a bind
+// mount, so they are left out.
 func isBind(options []string) (bool, []string) {
-	bindRemountOpts := []string{"remount"}
+	bindRemountOpts := []string{"remount", "bind"}
 	bind := false
 
 	if len(options) != 0 {
@@ -636,7 +448,9 @@ func isBind(options []string) (bool, []string) {
 			case "remount":
 				break
 			default:
-				bindRemountOpts = append(bindRemountOpts, option)
+				if perMountOptions[option] {
+					bindRemountOpts = append(bindRemountOpts, option)
+				}
 			}
 		}
 	}
@@ -644,12 +458,12 @@ func isBind(options []string) (bool, []string) {
 	return bind, bindRemountOpts
 }
 
-func runMkDir(dir, instanceName string) ([]byte, error) {
+func runMkDir(ctx context.Context, dir, instanceName string) ([]byte, error) {
 	log.Printf("Attempting to create directory %q on %q \r\n", dir, instanceName)
 	defer fmt.Println("------------")
 
 	mkdirCmd := "mkdir -p -m 0750 " + dir
-	outputBytes, cmdErr := executeRemoteGCloudCmd(mkdirCmd, instanceName)
+	outputBytes, cmdErr := runOnInstance(ctx, mkdirCmd, instanceName)
 	if cmdErr != nil {
 		log.Printf(
 			"Failed to create directory %q on %q. error: %v\r\n",
@@ -663,12 +477,12 @@ func runMkDir(dir, instanceName string) ([]byte, error) {
 	return outputBytes, nil
 }
 
-func runRmDir(dir, instanceName string) ([]byte, error) {
+func runRmDir(ctx context.Context, dir, instanceName string) ([]byte, error) {
 	log.Printf("Attempting to remove directory %q on %q \r\n", dir, instanceName)
 	defer fmt.Println("------------")
 
 	rmdirCmd := "rmdir " + dir
-	outputBytes, cmdErr := executeRemoteGCloudCmd(rmdirCmd, instanceName)
+	outputBytes, cmdErr := runOnInstance(ctx, rmdirCmd, instanceName)
 	if cmdErr != nil {
 		log.Printf(
 			"Failed to remove directory %q on %q. error: %v\r\n",
@@ -682,12 +496,14 @@ func runRmDir(dir, instanceName string) ([]byte, error) {
 	return outputBytes, nil
 }
 
-func doMount(devPath, mountPath, instanceName string, fstype string, options []string) ([]byte, error) {
+func doMount(ctx context.Context, devPath, mountPath, instanceName string, fstype string, options []string) ([]byte, error) {
 	log.Printf("Attempting to mount %q to %q on %q with fstype %q and options %v\r\n", mountPath, devPath, instanceName, fstype, options)
 	defer fmt.Println("------------")
 
 	mountCmd := makeMountCmd(devPath, mountPath, fstype, options)
-	outputBytes, cmdErr := executeRemoteGCloudCmd(mountCmd, instanceName)
+	start := time.Now()
+	outputBytes, cmdErr := runOnInstance(ctx, mountCmd, instanceName)
+	metrics.observeOperation("mount", start, cmdErr)
 	if cmdErr != nil {
 		log.Printf(
 			"Failed mount %q to %q on %q with fstype %q and options %v. error: %v\r\n",
@@ -734,12 +550,12 @@ const (
 	fsckErrorsUncorrected = 4
 )
 
-func runFsck(devPath, instanceName string) ([]byte, error) {
+func runFsck(ctx context.Context, devPath, instanceName string) ([]byte, error) {
 	log.Printf("Run fsck on disk %q on %q to fix repairable issues\r\n", devPath, instanceName)
 	defer fmt.Println("------------")
 
 	remoteCommand := "fsck -a " + devPath
-	outputBytes, cmdErr := executeRemoteGCloudCmd(remoteCommand, instanceName)
+	outputBytes, cmdErr := runOnInstance(ctx, remoteCommand, instanceName)
 	if cmdErr != nil {
 		log.Printf(
 			"Failed running fsck on disk %q on %q to fix repairable issues. error: %v\r\n",
@@ -753,12 +569,14 @@ func runFsck(devPath, instanceName string) ([]byte, error) {
 	return outputBytes, nil
 }
 
-func WriteContentToFile(fileContents, filePath, instanceName string) ([]byte, error) {
+func WriteContentToFile(ctx context.Context, fileContents, filePath, instanceName string) ([]byte, error) {
 	log.Printf("Writing %q to %q on %q\r\n", fileContents, filePath, instanceName)
 	defer fmt.Println("------------")
 
-	remoteCommand := fmt.Sprintf("echo '%s' > '%s'; sync", fileContents, filePath)
-	outputBytes, cmdErr := executeRemoteGCloudCmd(remoteCommand, instanceName)
+	// Chain with && so a failed write, e.g. to a read-only mount, is not
+	// masked by the exit status of sync.
+	remoteCommand := fmt.Sprintf("echo '%s' > '%s' && sync", fileContents, filePath)
+	outputBytes, cmdErr := runOnInstance(ctx, remoteCommand, instanceName)
 	if cmdErr != nil {
 		log.Printf(
 			"Failed writing %q to %q on %q. error: %v\r\n",
@@ -773,12 +591,12 @@ func WriteContentToFile(fileContents, filePath, instanceName string) ([]byte, er
 	return outputBytes, nil
 }
 
-func ReadContentsFromFile(filePath, instanceName string) (string, error) {
+func ReadContentsFromFile(ctx context.Context, filePath, instanceName string) (string, error) {
 	log.Printf("Reading %q on %q\r\n", filePath, instanceName)
 	defer fmt.Println("------------")
 
 	remoteCommand := fmt.Sprintf("cat '%s'", filePath)
-	outputBytes, cmdErr := executeRemoteGCloudCmd(remoteCommand, instanceName)
+	outputBytes, cmdErr := runOnInstance(ctx, remoteCommand, instanceName)
 	if cmdErr != nil {
 		log.Printf(
 			"Reading %q on %q. error: %v\r\n",
@@ -792,12 +610,12 @@ func ReadContentsFromFile(filePath, instanceName string) (string, error) {
 	return strings.TrimSpace(string(outputBytes)), nil
 }
 
-func diskLooksUnformatted(devPath, instanceName string) (bool, error) {
+func diskLooksUnformatted(ctx context.Context, devPath, instanceName string) (bool, error) {
 	log.Printf("Checking if %q is formatted on %q\r\n", devPath, instanceName)
 	defer fmt.Println("------------")
 
 	remoteCommand := "lsblk -nd -o FSTYPE " + devPath
-	outputBytes, cmdErr := executeRemoteGCloudCmd(remoteCommand, instanceName)
+	outputBytes, cmdErr := runOnInstance(ctx, remoteCommand, instanceName)
 	if cmdErr != nil {
 		log.Printf(
 			"Failed checking if %q is formatted on %q with %v\r\n",
@@ -819,23 +637,35 @@ func diskLooksUnformatted(devPath, instanceName string) (bool, error) {
 	return result, nil
 }
 
-func executeRemoteGCloudCmd(remoteCommand, instanceName string) ([]byte, error) {
+func executeRemoteGCloudCmd(ctx context.Context, remoteCommand, instanceName string) ([]byte, error) {
 	cmdArgs := []string{
 		"compute",
 		"ssh",
 		"root@" + instanceName,
 		"--command",
 		remoteCommand}
-	return executeGCloudCmd(cmdArgs)
+	return executeGCloudCmd(ctx, cmdArgs)
+}
+
+func executeGCloudCmd(ctx context.Context, cmdArgs []string) ([]byte, error) {
+	return executeCmd(ctx, *gcloudPath, cmdArgs...)
+}
+
+// executeGCloudCmdStdout runs gcloud for output that is parsed. The notices
+// and warnings gcloud writes to stderr are kept out of it.
+func executeGCloudCmdStdout(ctx context.Context, cmdArgs []string) ([]byte, error) {
+	return executeCmdStdout(ctx, *gcloudPath, cmdArgs...)
 }
 
-func executeGCloudCmd(cmdArgs []string) ([]byte, error) {
-	log.Printf("Executing: gcloud %v\r\n", cmdArgs)
-	command := exec.Command("gcloud", cmdArgs...)
-	output, err := command.CombinedOutput()
+func executeCmd(ctx context.Context, name string, args ...string) (output []byte, err error) {
+	log.Printf("Executing: %s %v\r\n", name, args)
+	_, sp := startSpan(ctx, "exec", spanAttrs{Command: strings.Join(append([]string{name}, args...), " ")})
+	defer func() { sp.end(err) }()
+	command := exec.Command(name, args...)
+	output, err = command.CombinedOutput()
 	if err != nil {
 		return output, fmt.Errorf(
-			"failed: err=%v\noutput: %s\n",
+			"failed: err=%w\noutput: %s\n",
 			err,
 			string(output))
 	}
@@ -843,6 +673,30 @@ func executeGCloudCmd(cmdArgs []string) ([]byte, error) {
 	return output, nil
 }
 
+// executeCmdStdout is executeCmd for output that is parsed or compared: it
+// returns stdout only, and includes stderr in the error.
+func executeCmdStdout(ctx context.Context, name string, args ...string) (stdout []byte, err error) {
+	log.Printf("Executing: %s %v\r\n", name, args)
+	_, sp := startSpan(ctx, "exec", spanAttrs{Command: strings.Join(append([]string{name}, args...), " ")})
+	defer func() { sp.end(err) }()
+	var stderr bytes.Buffer
+	command := exec.Command(name, args...)
+	command.Stderr = &stderr
+	stdout, err = command.Output()
+	if err != nil {
+		return stdout, fmt.Errorf(
+			"failed: err=%w\noutput: %s\nstderr: %s\n",
+			err,
+			string(stdout),
+			stderr.String())
+	}
+	if stderr.Len() > 0 {
+		log.Printf("%s wrote to stderr: %s\r\n", name, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout, nil
+}
+
 func getPDDevPath(pdName string) string {
 	return path.Join(diskByIdPath, diskScsiGooglePrefix+pdName)
 }
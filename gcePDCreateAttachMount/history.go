@@ -0,0 +1,630 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	historyFile     = flag.String("history-file", "history.jsonl", "JSON-lines file every run's results are appended to and the report subcommand reads. Empty disables recording.")
+	toolVersion     = flag.String("tool-version", "", "Tool version recorded with each run. Defaults to the VCS revision the binary was built from.")
+	reportSince     = flag.Duration("report-since", 0, "Only report runs started within this long. 0 reports all runs.")
+	reportWindow    = flag.Duration("report-window", 24*time.Hour, "Width of the time windows the report breaks step latency down by.")
+	reportGroupBy   = flag.String("report-group-by", "kind", "Group report steps by their \"kind\" (attach, mount, ...) or their full \"name\".")
+	reportBaseline  = flag.String("report-baseline", "", "Runs to compare against: a tool version, or a time range FROM..TO of RFC 3339 times or dates where either end may be empty.")
+	reportCandidate = flag.String("report-candidate", "", "Runs checked for regressions against -report-baseline, in the same form.")
+	reportAlpha     = flag.Float64("report-alpha", 0.01, "Significance level below which a difference is flagged as a regression.")
+)
+
+// minSamples is the fewest runs on each side a regression test is done with.
+const minSamples = 5
+
+// historyRecord is one run in the history file.
+type historyRecord struct {
+	RunID       string        `json:"runID"`
+	ToolVersion string        `json:"toolVersion"`
+	Suite       string        `json:"suite"`
+	Scenario    string        `json:"scenario,omitempty"`
+	PdName      string        `json:"pdName"`
+	Start       time.Time     `json:"start"`
+	DurationMs  float64       `json:"durationMs"`
+	Passed      bool          `json:"passed"`
+	Resumed     bool          `json:"resumed,omitempty"`
+	Steps       []historyStep `json:"steps"`
+	// Benchmarks are the fio jobs of a lifecycle run with -bench.
+	Benchmarks []historyBenchmark `json:"benchmarks,omitempty"`
+}
+
+type historyStep struct {
+	Name       string  `json:"name"`
+	Kind       string  `json:"kind"`
+	DurationMs float64 `json:"durationMs"`
+	ErrClass   string  `json:"errClass,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// historyBenchmark is one fio job. Bandwidth is in bytes per second and
+// latency is the mean completion latency.
+type historyBenchmark struct {
+	DiskType       string  `json:"diskType"`
+	FSType         string  `json:"fsType"`
+	Pattern        string  `json:"pattern"`
+	BlockSize      string  `json:"blockSize"`
+	QueueDepth     int     `json:"queueDepth"`
+	ReadIOPS       float64 `json:"readIOPS"`
+	ReadBW         int64   `json:"readBW"`
+	ReadLatencyMs  float64 `json:"readLatencyMs"`
+	WriteIOPS      float64 `json:"writeIOPS"`
+	WriteBW        int64   `json:"writeBW"`
+	WriteLatencyMs float64 `json:"writeLatencyMs"`
+}
+
+func (s historyStep) duration() time.Duration {
+	return time.Duration(s.DurationMs * float64(time.Millisecond))
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// historyMu serializes appends from matrix cells running in parallel.
+var historyMu sync.Mutex
+
+// recordHistory appends a run to the history file. Failing to record is
+// logged but never fails the run.
+func recordHistory(suite, scenario, pdName string, start time.Time, steps []stepResult, runErr error, resumed bool) {
+	appendHistory(newHistoryRecord(suite, scenario, pdName, start, steps, runErr, resumed))
+}
+
+func newHistoryRecord(suite, scenario, pdName string, start time.Time, steps []stepResult, runErr error, resumed bool) historyRecord {
+	rec := historyRecord{
+		RunID:       runID,
+		ToolVersion: currentToolVersion(),
+		Suite:       suite,
+		Scenario:    scenario,
+		PdName:      pdName,
+		Start:       start,
+		DurationMs:  durationMs(time.Since(start)),
+		Passed:      runErr == nil,
+		Resumed:     resumed,
+	}
+	for _, sr := range steps {
+		hs := historyStep{Name: sr.Name, Kind: sr.Kind, DurationMs: durationMs(sr.Duration)}
+		if sr.Err != nil {
+			hs.ErrClass = classifyError(sr.Err)
+			hs.Error = sr.Err.Error()
+		}
+		rec.Steps = append(rec.Steps, hs)
+	}
+	return rec
+}
+
+// stepRecorder collects the outcomes of operations that subcommands run
+// outside executeRun and executeSteps, so they can be recorded as the steps of
+// a history record. It is safe for concurrent use.
+type stepRecorder struct {
+	mu    sync.Mutex
+	steps []stepResult
+}
+
+func (r *stepRecorder) record(name, kind string, start time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps = append(r.steps, stepResult{Name: name, Kind: kind, Duration: time.Since(start), Err: err})
+}
+
+func (r *stepRecorder) results() []stepResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]stepResult(nil), r.steps...)
+}
+
+// addBenchmarks records the benchmark results of a lifecycle run of s.
+func (rec *historyRecord) addBenchmarks(s scenario, results []benchmarkResult) {
+	diskType := s.Disk.Type
+	if diskType == "" {
+		diskType = "default"
+	}
+	for _, b := range results {
+		rec.Benchmarks = append(rec.Benchmarks, historyBenchmark{
+			DiskType:       diskType,
+			FSType:         s.FSType,
+			Pattern:        b.Pattern,
+			BlockSize:      b.BlockSize,
+			QueueDepth:     b.QueueDepth,
+			ReadIOPS:       b.ReadIOPS,
+			ReadBW:         b.ReadBW,
+			ReadLatencyMs:  durationMs(b.ReadLatency),
+			WriteIOPS:      b.WriteIOPS,
+			WriteBW:        b.WriteBW,
+			WriteLatencyMs: durationMs(b.WriteLatency),
+		})
+	}
+}
+
+// appendHistory appends rec to the history file.
+func appendHistory(rec historyRecord) {
+	if *historyFile == "" {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Encoding history record failed: %v\r\n", err)
+		return
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	f, err := os.OpenFile(*historyFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Opening history file failed: %v\r\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Writing history file failed: %v\r\n", err)
+	}
+}
+
+// currentToolVersion returns -tool-version, or the VCS revision of the
+// binary if it was built from a module in a repository.
+func currentToolVersion() string {
+	if *toolVersion != "" {
+		return *toolVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "unknown"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+func loadHistory(historyPath string) ([]historyRecord, error) {
+	f, err := os.Open(historyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// runSelector picks the runs of one side of a regression comparison.
+type runSelector struct {
+	version  string
+	from, to time.Time
+}
+
+// parseRunSelector parses a tool version, or a time range FROM..TO.
+func parseRunSelector(s string) (runSelector, error) {
+	fromStr, toStr, isRange := strings.Cut(s, "..")
+	if !isRange {
+		return runSelector{version: s}, nil
+	}
+	var sel runSelector
+	var err error
+	if fromStr != "" {
+		if sel.from, err = parseReportTime(fromStr); err != nil {
+			return sel, err
+		}
+	}
+	if toStr != "" {
+		if sel.to, err = parseReportTime(toStr); err != nil {
+			return sel, err
+		}
+	}
+	return sel, nil
+}
+
+func parseReportTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q, must be RFC 3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+func (sel runSelector) matches(rec historyRecord) bool {
+	if sel.version != "" {
+		return rec.ToolVersion == sel.version
+	}
+	if !sel.from.IsZero() && rec.Start.Before(sel.from) {
+		return false
+	}
+	return sel.to.IsZero() || rec.Start.Before(sel.to)
+}
+
+// stepSamples are the outcomes of one group of steps.
+type stepSamples struct {
+	runs, failed int
+	// durations of the steps that succeeded.
+	durations []time.Duration
+}
+
+func (s *stepSamples) add(step historyStep) {
+	s.runs++
+	if step.ErrClass != "" {
+		s.failed++
+		return
+	}
+	s.durations = append(s.durations, step.duration())
+}
+
+func (s *stepSamples) failureRate() float64 {
+	if s.runs == 0 {
+		return 0
+	}
+	return float64(s.failed) / float64(s.runs)
+}
+
+// groupSteps collects the steps of records by -report-group-by.
+func groupSteps(records []historyRecord) (map[string]*stepSamples, error) {
+	groups := make(map[string]*stepSamples)
+	for _, rec := range records {
+		for _, step := range rec.Steps {
+			var key string
+			switch *reportGroupBy {
+			case "kind":
+				key = rec.Suite + "/" + step.Kind
+			case "name":
+				key = rec.Suite + "/" + step.Name
+			default:
+				return nil, fmt.Errorf("unknown -report-group-by %q, must be kind or name", *reportGroupBy)
+			}
+			if groups[key] == nil {
+				groups[key] = &stepSamples{}
+			}
+			groups[key].add(step)
+		}
+	}
+	return groups, nil
+}
+
+// percentile returns the nearest-rank p-th percentile of durations, which
+// must be sorted.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(durations))))
+	if rank < 1 {
+		rank = 1
+	}
+	return durations[rank-1]
+}
+
+func formatPercentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "-\t-\t-"
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return fmt.Sprintf("%v\t%v\t%v",
+		percentile(sorted, 50).Round(time.Millisecond),
+		percentile(sorted, 90).Round(time.Millisecond),
+		percentile(sorted, 99).Round(time.Millisecond))
+}
+
+// runReport prints failure rates and latency percentiles of the runs in the
+// history file and, if a baseline and a candidate are given, the steps that
+// regressed between them. It fails if any did.
+func runReport() error {
+	records, err := loadHistory(*historyFile)
+	if err != nil {
+		return fmt.Errorf("reading history file failed: %v", err)
+	}
+	if *reportSince > 0 {
+		cutoff := time.Now().Add(-*reportSince)
+		var recent []historyRecord
+		for _, rec := range records {
+			if !rec.Start.Before(cutoff) {
+				recent = append(recent, rec)
+			}
+		}
+		records = recent
+	}
+	// Parallel runs finish, and so are recorded, out of order.
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Start.Before(records[j].Start) })
+	if len(records) == 0 {
+		fmt.Println("No runs recorded")
+		return nil
+	}
+	if *reportWindow <= 0 {
+		return fmt.Errorf("-report-window must be positive")
+	}
+
+	if err := printFailureRates(os.Stdout, records); err != nil {
+		return err
+	}
+	fmt.Println()
+	if err := printLatencyWindows(os.Stdout, records); err != nil {
+		return err
+	}
+
+	if *reportBaseline == "" && *reportCandidate == "" {
+		return nil
+	}
+	if *reportBaseline == "" || *reportCandidate == "" {
+		return fmt.Errorf("-report-baseline and -report-candidate must be given together")
+	}
+	baseline, err := parseRunSelector(*reportBaseline)
+	if err != nil {
+		return fmt.Errorf("invalid -report-baseline: %v", err)
+	}
+	candidate, err := parseRunSelector(*reportCandidate)
+	if err != nil {
+		return fmt.Errorf("invalid -report-candidate: %v", err)
+	}
+	fmt.Println()
+	regressions, err := printRegressions(os.Stdout, records, baseline, candidate)
+	if err != nil {
+		return err
+	}
+	if regressions > 0 {
+		return fmt.Errorf("%d steps regressed from %s to %s", regressions, *reportBaseline, *reportCandidate)
+	}
+	return nil
+}
+
+func printFailureRates(w io.Writer, records []historyRecord) error {
+	groups, err := groupSteps(records)
+	if err != nil {
+		return err
+	}
+	passed := 0
+	for _, rec := range records {
+		if rec.Passed {
+			passed++
+		}
+	}
+	fmt.Fprintf(w, "%d runs from %s to %s, %d passed\n",
+		len(records),
+		records[0].Start.Format(time.RFC3339),
+		records[len(records)-1].Start.Format(time.RFC3339),
+		passed)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tRUNS\tFAILED\tFAILURE RATE\tP50\tP90\tP99")
+	for _, key := range sortedKeys(groups) {
+		g := groups[key]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%s\n", key, g.runs, g.failed, 100*g.failureRate(), formatPercentiles(g.durations))
+	}
+	return tw.Flush()
+}
+
+// printLatencyWindows breaks the steps down by the -report-window their run
+// started in.
+func printLatencyWindows(w io.Writer, records []historyRecord) error {
+	windows := make(map[time.Time][]historyRecord)
+	var starts []time.Time
+	for _, rec := range records {
+		start := rec.Start.Truncate(*reportWindow)
+		if windows[start] == nil {
+			starts = append(starts, start)
+		}
+		windows[start] = append(windows[start], rec)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WINDOW\tSTEP\tRUNS\tFAILURE RATE\tP50\tP90\tP99")
+	for _, start := range starts {
+		groups, err := groupSteps(windows[start])
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(groups) {
+			g := groups[key]
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f%%\t%s\n", start.Format(time.RFC3339), key, g.runs, 100*g.failureRate(), formatPercentiles(g.durations))
+		}
+	}
+	return tw.Flush()
+}
+
+// printRegressions tests every step group for a higher failure rate and for
+// higher latency in the candidate runs, and returns how many regressed.
+func printRegressions(w io.Writer, records []historyRecord, baseline, candidate runSelector) (int, error) {
+	var baseRecords, candRecords []historyRecord
+	for _, rec := range records {
+		if baseline.matches(rec) {
+			baseRecords = append(baseRecords, rec)
+		}
+		if candidate.matches(rec) {
+			candRecords = append(candRecords, rec)
+		}
+	}
+	fmt.Fprintf(w, "Comparing %d baseline runs (%s) with %d candidate runs (%s), alpha %g\n",
+		len(baseRecords), *reportBaseline, len(candRecords), *reportCandidate, *reportAlpha)
+	baseGroups, err := groupSteps(baseRecords)
+	if err != nil {
+		return 0, err
+	}
+	candGroups, err := groupSteps(candRecords)
+	if err != nil {
+		return 0, err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tRUNS\tFAILURE RATE\tP\tP50\tP\tRESULT")
+	regressions := 0
+	for _, key := range sortedKeys(candGroups) {
+		cand := candGroups[key]
+		base := baseGroups[key]
+		if base == nil {
+			base = &stepSamples{}
+		}
+		result := "ok"
+		failureP, latencyP := "-", "-"
+		if base.runs < minSamples || cand.runs < minSamples {
+			result = "too few runs"
+		} else {
+			p := failureRateIncreaseP(base, cand)
+			failureP = fmt.Sprintf("%.3g", p)
+			var regressed []string
+			if p < *reportAlpha {
+				regressed = append(regressed, "failure rate")
+			}
+			if len(base.durations) >= minSamples && len(cand.durations) >= minSamples {
+				p := latencyIncreaseP(base.durations, cand.durations)
+				latencyP = fmt.Sprintf("%.3g", p)
+				if p < *reportAlpha {
+					regressed = append(regressed, "latency")
+				}
+			}
+			if len(regressed) > 0 {
+				result = "REGRESSED " + strings.Join(regressed, ", ")
+				regressions++
+			}
+		}
+		basePercentiles, candPercentiles := "-", "-"
+		if len(base.durations) > 0 {
+			basePercentiles = formatPercentile(base.durations, 50)
+		}
+		if len(cand.durations) > 0 {
+			candPercentiles = formatPercentile(cand.durations, 50)
+		}
+		fmt.Fprintf(tw, "%s\t%d -> %d\t%.1f%% -> %.1f%%\t%s\t%s -> %s\t%s\t%s\n",
+			key,
+			base.runs, cand.runs,
+			100*base.failureRate(), 100*cand.failureRate(),
+			failureP,
+			basePercentiles, candPercentiles,
+			latencyP,
+			result)
+	}
+	return regressions, tw.Flush()
+}
+
+func formatPercentile(durations []time.Duration, p float64) string {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return percentile(sorted, p).Round(time.Millisecond).String()
+}
+
+// normalSF is the probability that a standard normal variable exceeds z.
+func normalSF(z float64) float64 {
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+// failureRateIncreaseP is the one-sided p-value of a two-proportion z-test
+// for the candidate failing more often than the baseline.
+func failureRateIncreaseP(base, cand *stepSamples) float64 {
+	n1, n2 := float64(base.runs), float64(cand.runs)
+	pooled := float64(base.failed+cand.failed) / (n1 + n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/n1 + 1/n2))
+	if se == 0 {
+		// Both always or never failed.
+		return 1
+	}
+	return normalSF((cand.failureRate() - base.failureRate()) / se)
+}
+
+// latencyIncreaseP is the one-sided p-value of a Mann-Whitney U test for the
+// candidate durations being larger than the baseline ones. It uses the normal
+// approximation with tie and continuity corrections, which is accurate enough
+// from minSamples runs on each side. Unlike a t-test it is not thrown off by
+// the long tail of cloud operation latencies.
+func latencyIncreaseP(base, cand []time.Duration) float64 {
+	type sample struct {
+		d         time.Duration
+		candidate bool
+	}
+	samples := make([]sample, 0, len(base)+len(cand))
+	for _, d := range base {
+		samples = append(samples, sample{d: d})
+	}
+	for _, d := range cand {
+		samples = append(samples, sample{d: d, candidate: true})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].d < samples[j].d })
+
+	// Sum the candidate ranks, giving tied samples their average rank.
+	n := float64(len(samples))
+	rankSum, tieTerm := 0.0, 0.0
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].d == samples[i].d {
+			j++
+		}
+		avgRank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if samples[k].candidate {
+				rankSum += avgRank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(cand)), float64(len(base))
+	u := rankSum - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	return normalSF((u - mean - 0.5) / sigma)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func seconds(values ...int) []time.Duration {
+	var durations []time.Duration
+	for _, v := range values {
+		durations = append(durations, time.Duration(v)*time.Second)
+	}
+	return durations
+}
+
+func TestPercentile(t *testing.T) {
+	durations := seconds(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	for p, expected := range map[float64]time.Duration{
+		0:   1 * time.Second,
+		50:  5 * time.Second,
+		90:  9 * time.Second,
+		99:  10 * time.Second,
+		100: 10 * time.Second,
+	} {
+		if actual := percentile(durations, p); actual != expected {
+			t.Errorf("p%v = %v, expected %v", p, actual, expected)
+		}
+	}
+	if actual := percentile(nil, 50); actual != 0 {
+		t.Errorf("p50 of no durations = %v, expected 0", actual)
+	}
+	if actual := formatPercentile(seconds(3, 1, 2), 50); actual != "2s" {
+		t.Errorf("p50 of unsorted durations = %s, expected 2s", actual)
+	}
+}
+
+func TestFailureRateIncreaseP(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		base, cand stepSamples
+		min, max   float64
+	}{
+		// z = 0.5 / sqrt(0.35 * 0.65 * 0.2) = 2.344.
+		{name: "more failures", base: stepSamples{runs: 10, failed: 1}, cand: stepSamples{runs: 10, failed: 6}, min: 0.0095, max: 0.0096},
+		{name: "same rate", base: stepSamples{runs: 10, failed: 2}, cand: stepSamples{runs: 20, failed: 4}, min: 0.5, max: 0.5},
+		{name: "fewer failures", base: stepSamples{runs: 10, failed: 6}, cand: stepSamples{runs: 10, failed: 1}, min: 0.99, max: 1},
+		{name: "never failing", base: stepSamples{runs: 10}, cand: stepSamples{runs: 10}, min: 1, max: 1},
+		{name: "always failing", base: stepSamples{runs: 10, failed: 10}, cand: stepSamples{runs: 10, failed: 10}, min: 1, max: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if p := failureRateIncreaseP(&tc.base, &tc.cand); p < tc.min || p > tc.max || math.IsNaN(p) {
+				t.Errorf("failureRateIncreaseP = %v, expected it in [%v, %v]", p, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+func TestLatencyIncreaseP(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		base, cand []time.Duration
+		min, max   float64
+	}{
+		// U = 25, mean 12.5, sigma sqrt(25 / 12 * 11): z = 12 / 4.787 = 2.507.
+		{name: "slower", base: seconds(1, 2, 3, 4, 5), cand: seconds(6, 7, 8, 9, 10), min: 0.0060, max: 0.0062},
+		{name: "faster", base: seconds(6, 7, 8, 9, 10), cand: seconds(1, 2, 3, 4, 5), min: 0.99, max: 1},
+		{name: "interleaved", base: seconds(1, 3, 5, 7, 9), cand: seconds(2, 4, 6, 8, 10), min: 0.3, max: 0.5},
+		{name: "all tied", base: seconds(5, 5, 5, 5, 5), cand: seconds(5, 5, 5, 5, 5), min: 1, max: 1},
+		// One slow outlier does not make the candidate slower.
+		{name: "outlier", base: seconds(2, 3, 4, 5, 6), cand: seconds(1, 2, 3, 4, 600), min: 0.5, max: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if p := latencyIncreaseP(tc.base, tc.cand); p < tc.min || p > tc.max || math.IsNaN(p) {
+				t.Errorf("latencyIncreaseP = %v, expected it in [%v, %v]", p, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+func TestRunNegativeRecordsHistory(t *testing.T) {
+	useProvider(t, newFakeProvider())
+	saved := *historyFile
+	*historyFile = filepath.Join(t.TempDir(), "history.jsonl")
+	t.Cleanup(func() { *historyFile = saved })
+
+	if err := runNegative(context.Background()); err != nil {
+		t.Fatalf("runNegative failed: %v", err)
+	}
+	records, err := loadHistory(*historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Suite != "negative" {
+		t.Fatalf("history has %+v, expected one negative run", records)
+	}
+	if !records[0].Passed || len(records[0].Steps) == 0 {
+		t.Errorf("negative run recorded as %+v, expected it passed with its checks as steps", records[0])
+	}
+}
@@ -0,0 +1,345 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	kubectlPath     = flag.String("kubectl", "kubectl", "kubectl binary the k8s subcommand drives the cluster with.")
+	k8sNamespace    = flag.String("k8s-namespace", "default", "Namespace the k8s subcommand creates its PVC and pods in.")
+	k8sStorageClass = flag.String("k8s-storage-class", "", "Storage class of the dynamically provisioned PVC. Empty uses the cluster default.")
+	k8sStaticPD     = flag.String("k8s-static-pd", "", "Name of a pre-created PD to expose through a static PV instead of provisioning a new one.")
+	k8sCSIDriver    = flag.String("k8s-csi-driver", "pd.csi.storage.gke.io", "CSI driver of the static PV.")
+	k8sSizeGB       = flag.Int64("k8s-size-gb", 10, "Requested size of the PVC in GB.")
+	k8sNodes        = flag.String("k8s-nodes", testInstance0Name+","+testInstance1Name, "Comma separated nodes the writer and reader pods are pinned to.")
+	k8sImage        = flag.String("k8s-image", "busybox", "Image of the writer and reader pods.")
+	k8sPodTimeout   = flag.Duration("k8s-pod-timeout", 5*time.Minute, "How long to wait for a pod to be Running.")
+	k8sPollInterval = flag.Duration("k8s-poll-interval", time.Second, "How often pod phases are polled.")
+)
+
+// k8sVolumeMountPath is where the test pods mount the PVC.
+const k8sVolumeMountPath = "/data"
+
+// k8sRun performs the cross-node handoff of the lifecycle through the
+// Kubernetes API: the cluster attaches, mounts and detaches the PD as pods
+// come and go.
+type k8sRun struct {
+	name   string
+	pvName string
+	nodes  [2]string
+	// detail is set by a running step to report a measurement.
+	detail string
+}
+
+func runK8s(ctx context.Context) error {
+	nodes := strings.Split(*k8sNodes, ",")
+	if len(nodes) != 2 {
+		return fmt.Errorf("-k8s-nodes needs exactly two nodes, got %q", *k8sNodes)
+	}
+	r := newK8sRun(generatePdName(), [2]string{nodes[0], nodes[1]})
+
+	log.Printf("***Running Kubernetes handoff of PVC %q from %q to %q\r\n", r.name, r.nodes[0], r.nodes[1])
+	start := time.Now()
+	results, err := executeSteps(ctx, r.steps(), r.cleanupSteps(), &r.detail, r.pdName(), r.nodes[:])
+	recordHistory("k8s", "", r.name, start, results, err, false /* resumed */)
+	fmt.Printf("Kubernetes run report for PVC %q, nodes %s+%s\n", r.name, r.nodes[0], r.nodes[1])
+	printStepTable(os.Stdout, results)
+	status := "PASSED"
+	if err != nil {
+		status = fmt.Sprintf("FAILED (%v)", err)
+	}
+	fmt.Printf("Total %v: %s\n", time.Since(start).Round(time.Millisecond), status)
+	return err
+}
+
+func newK8sRun(name string, nodes [2]string) *k8sRun {
+	r := &k8sRun{name: name, nodes: nodes}
+	if *k8sStaticPD != "" {
+		r.pvName = r.name
+	}
+	return r
+}
+
+// pdName returns the PD behind the PVC. A dynamically provisioned PD is named
+// by the provisioner, so its artifacts are filed under the PVC name and miss
+// the device.
+func (r *k8sRun) pdName() string {
+	if *k8sStaticPD != "" {
+		return *k8sStaticPD
+	}
+	return r.name
+}
+
+func (r *k8sRun) writerPod() string { return r.name + "-writer" }
+func (r *k8sRun) readerPod() string { return r.name + "-reader" }
+
+func (r *k8sRun) steps() []step {
+	var steps []step
+	if r.pvName != "" {
+		steps = append(steps, step{
+			name: fmt.Sprintf("create static PV for PD %q", *k8sStaticPD),
+			kind: "create",
+			run:  func(ctx context.Context) error { return kubectlApply(ctx, r.persistentVolume()) },
+		})
+	}
+	testFile := path.Join(k8sVolumeMountPath, testFileName)
+	steps = append(steps,
+		step{
+			name: fmt.Sprintf("create PVC %q", r.name),
+			kind: "create",
+			run:  func(ctx context.Context) error { return kubectlApply(ctx, r.persistentVolumeClaim()) },
+		},
+		r.startPod(r.writerPod(), r.nodes[0]),
+		step{
+			name: fmt.Sprintf("write on %q", r.nodes[0]),
+			kind: "write",
+			run: func(ctx context.Context) error {
+				_, err := kubectl(ctx, "exec", r.writerPod(), "--", "sh", "-c",
+					fmt.Sprintf("printf %%s '%s' > %s && sync", testFileContent, testFile))
+				return err
+			},
+		},
+		step{
+			name: fmt.Sprintf("delete pod %q", r.writerPod()),
+			kind: "detach",
+			run:  func(ctx context.Context) error { return deletePod(ctx, r.writerPod()) },
+		},
+		r.startPod(r.readerPod(), r.nodes[1]),
+		step{
+			name: fmt.Sprintf("read on %q", r.nodes[1]),
+			kind: "read",
+			run: func(ctx context.Context) error {
+				output, err := kubectlOutput(ctx, "exec", r.readerPod(), "--", "cat", testFile)
+				if err != nil {
+					return err
+				}
+				if content := string(output); content != testFileContent {
+					return fmt.Errorf("read file content differs. Expected: <%s> Actual: <%s>", testFileContent, content)
+				}
+				return nil
+			},
+		},
+	)
+	return steps
+}
+
+// cleanupSteps remove everything the run created. They tolerate objects that
+// were never created, so they also clean up after a failed step.
+func (r *k8sRun) cleanupSteps() []step {
+	steps := []step{
+		{
+			name: fmt.Sprintf("delete pod %q", r.readerPod()),
+			kind: "detach",
+			run:  func(ctx context.Context) error { return deletePod(ctx, r.readerPod()) },
+		},
+		{
+			name: fmt.Sprintf("delete pod %q", r.writerPod()),
+			kind: "detach",
+			run:  func(ctx context.Context) error { return deletePod(ctx, r.writerPod()) },
+		},
+		{
+			name: fmt.Sprintf("delete PVC %q", r.name),
+			kind: "delete",
+			run: func(ctx context.Context) error {
+				_, err := kubectl(ctx, "delete", "pvc", r.name, "--ignore-not-found", "--wait=true")
+				return err
+			},
+		},
+	}
+	if r.pvName != "" {
+		// The PV is Retain, so deleting it leaves the pre-created PD alone.
+		steps = append(steps, step{
+			name: fmt.Sprintf("delete static PV %q", r.pvName),
+			kind: "delete",
+			run: func(ctx context.Context) error {
+				_, err := kubectl(ctx, "delete", "pv", r.pvName, "--ignore-not-found", "--wait=true")
+				return err
+			},
+		})
+	}
+	return steps
+}
+
+// startPod creates a pod mounting the PVC pinned to nodeName and waits for it
+// to be Running. The time from creation to Running covers scheduling, attach
+// and mount, and is reported as the step detail.
+func (r *k8sRun) startPod(podName, nodeName string) step {
+	return step{
+		name: fmt.Sprintf("start pod %q on %q", podName, nodeName),
+		kind: "attach",
+		run: func(ctx context.Context) error {
+			created := time.Now()
+			if err := kubectlApply(ctx, r.pod(podName, nodeName)); err != nil {
+				return err
+			}
+			if err := waitForPodRunning(ctx, podName); err != nil {
+				return err
+			}
+			r.detail = fmt.Sprintf("Running after %v", time.Since(created).Round(time.Millisecond))
+			return nil
+		},
+	}
+}
+
+func (r *k8sRun) persistentVolume() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolume",
+		"metadata":   k8sMetadata(r.pvName),
+		"spec": map[string]interface{}{
+			"capacity":                      map[string]string{"storage": fmt.Sprintf("%dGi", *k8sSizeGB)},
+			"accessModes":                   []string{"ReadWriteOnce"},
+			"persistentVolumeReclaimPolicy": "Retain",
+			"storageClassName":              "",
+			"csi": map[string]interface{}{
+				"driver":       *k8sCSIDriver,
+				"volumeHandle": fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProjectID, *gceZone, *k8sStaticPD),
+				"fsType":       testFSType,
+			},
+		},
+	}
+}
+
+func (r *k8sRun) persistentVolumeClaim() map[string]interface{} {
+	spec := map[string]interface{}{
+		"accessModes": []string{"ReadWriteOnce"},
+		"resources": map[string]interface{}{
+			"requests": map[string]string{"storage": fmt.Sprintf("%dGi", *k8sSizeGB)},
+		},
+	}
+	if r.pvName != "" {
+		// Bind to the static PV only, never to a provisioned one.
+		spec["volumeName"] = r.pvName
+		spec["storageClassName"] = ""
+	} else if *k8sStorageClass != "" {
+		spec["storageClassName"] = *k8sStorageClass
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolumeClaim",
+		"metadata":   k8sMetadata(r.name),
+		"spec":       spec,
+	}
+}
+
+// pod returns a pod that mounts the PVC and idles, so the test can exec into
+// it. A short grace period keeps deletion, and therefore the handoff, fast.
+func (r *k8sRun) pod(podName, nodeName string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   k8sMetadata(podName),
+		"spec": map[string]interface{}{
+			"nodeSelector":                  map[string]string{"kubernetes.io/hostname": nodeName},
+			"terminationGracePeriodSeconds": 1,
+			"containers": []interface{}{
+				map[string]interface{}{
+					"name":         "test",
+					"image":        *k8sImage,
+					"command":      []string{"sleep", "3600"},
+					"volumeMounts": []interface{}{map[string]string{"name": "data", "mountPath": k8sVolumeMountPath}},
+				},
+			},
+			"volumes": []interface{}{
+				map[string]interface{}{
+					"name":                  "data",
+					"persistentVolumeClaim": map[string]string{"claimName": r.name},
+				},
+			},
+		},
+	}
+}
+
+// k8sMetadata names an object and labels it with the run ID.
+func k8sMetadata(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   name,
+		"labels": map[string]string{runLabelKey: runID},
+	}
+}
+
+// kubectl runs kubectl in the test namespace.
+func kubectl(ctx context.Context, args ...string) ([]byte, error) {
+	return executeCmd(ctx, *kubectlPath, append([]string{"--namespace", *k8sNamespace}, args...)...)
+}
+
+// kubectlOutput runs kubectl in the test namespace for output that is
+// parsed or compared, which must not include the warnings kubectl writes to
+// stderr.
+func kubectlOutput(ctx context.Context, args ...string) ([]byte, error) {
+	return executeCmdStdout(ctx, *kubectlPath, append([]string{"--namespace", *k8sNamespace}, args...)...)
+}
+
+// kubectlApply writes obj to a temporary manifest and applies it.
+func kubectlApply(ctx context.Context, obj map[string]interface{}) error {
+	manifest, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "k8s-manifest-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.WriteString(f, string(manifest)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	_, err = kubectl(ctx, "apply", "-f", f.Name())
+	return err
+}
+
+func deletePod(ctx context.Context, podName string) error {
+	_, err := kubectl(ctx, "delete", "pod", podName, "--ignore-not-found", "--wait=true")
+	return err
+}
+
+// waitForPodRunning polls the phase of podName until it is Running. On
+// timeout the pod's events are included in the error, since they carry the
+// attach and mount failures.
+func waitForPodRunning(ctx context.Context, podName string) error {
+	phase := ""
+	for start := time.Now(); time.Since(start) < *k8sPodTimeout; time.Sleep(*k8sPollInterval) {
+		output, err := kubectlOutput(ctx, "get", "pod", podName, "-o", "jsonpath={.status.phase}")
+		if err != nil {
+			log.Printf("Couldn't get pod %q. Retrying (%v)\r\n", podName, err)
+			continue
+		}
+		switch phase = strings.TrimSpace(string(output)); phase {
+		case "Running":
+			return nil
+		case "Succeeded", "Failed":
+			return fmt.Errorf("pod %q is %s, expected Running", podName, phase)
+		}
+	}
+	events, _ := kubectl(ctx, "get", "events", "--field-selector", "involvedObject.name="+podName)
+	return fmt.Errorf("pod %q not Running after %v (phase %q): %w\nevents:\n%s", podName, *k8sPodTimeout, phase, errOperationTimeout, events)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKubectl keeps every applied object as a file in $FAKE_KUBECTL_STATE
+// and the PVC contents in its volume directory. Like a real kubectl talking
+// to a cluster with deprecated settings, it warns on stderr on every exec.
+// With FAKE_KUBECTL_LOSE_DATA set, deleting a pod truncates the files on the
+// volume.
+const fakeKubectl = `#!/bin/sh
+state="$FAKE_KUBECTL_STATE"
+echo "$*" >> "$state/calls"
+[ "$1" = "--namespace" ] && shift 2
+command="$1"
+shift
+case "$command" in
+apply)
+	kind=$(sed -n 's/^  "kind": "\(.*\)",$/\1/p' "$2")
+	name=$(sed -n 's/^    "name": "\(.*\)"$/\1/p' "$2" | head -n 1)
+	cp "$2" "$state/$kind-$name"
+	cp "$2" "$state/applied-$kind-$name"
+	echo "$kind/$name created"
+	;;
+get)
+	case "$1" in
+	pod)
+		if [ ! -f "$state/Pod-$2" ]; then
+			echo "Error from server (NotFound): pods \"$2\" not found" >&2
+			exit 1
+		fi
+		printf Running
+		;;
+	events)
+		echo "No resources found"
+		;;
+	esac
+	;;
+exec)
+	pod="$1"
+	shift 2
+	if [ ! -f "$state/Pod-$pod" ]; then
+		echo "Error from server (NotFound): pods \"$pod\" not found" >&2
+		exit 1
+	fi
+	echo "Warning: Use tokens from the TokenRequest API instead of auto-generated secret-based tokens." >&2
+	mkdir -p "$state/volume"
+	case "$1" in
+	sh) sh -c "$(printf '%s' "$3" | sed "s#/data/#$state/volume/#g")" ;;
+	cat) cat "$state/volume/${2#/data/}" ;;
+	esac
+	;;
+delete)
+	case "$1" in
+	pod) kind=Pod ;;
+	pvc) kind=PersistentVolumeClaim ;;
+	pv) kind=PersistentVolume ;;
+	esac
+	rm -f "$state/$kind-$2"
+	if [ "$kind" = Pod ] && [ -n "$FAKE_KUBECTL_LOSE_DATA" ]; then
+		for f in "$state"/volume/*; do
+			[ -f "$f" ] && : > "$f"
+		done
+	fi
+	;;
+*)
+	echo "fake kubectl: unsupported command $command" >&2
+	exit 1
+	;;
+esac
+`
+
+// useFakeKubectl points -kubectl at a fresh fakeKubectl and returns its
+// state directory.
+func useFakeKubectl(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "kubectl")
+	if err := os.WriteFile(script, []byte(fakeKubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(dir, "state")
+	if err := os.Mkdir(state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_KUBECTL_STATE", state)
+
+	savedPath, savedInterval := *kubectlPath, *k8sPollInterval
+	*kubectlPath = script
+	*k8sPollInterval = time.Millisecond
+	t.Cleanup(func() { *kubectlPath, *k8sPollInterval = savedPath, savedInterval })
+	return state
+}
+
+// podNode returns the node the applied manifest pins podName to.
+func podNode(t *testing.T, state, podName string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(state, "applied-Pod-"+podName))
+	if err != nil {
+		t.Fatalf("pod %q was never applied: %v", podName, err)
+	}
+	var pod struct {
+		Spec struct {
+			NodeSelector map[string]string `json:"nodeSelector"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		t.Fatal(err)
+	}
+	return pod.Spec.NodeSelector["kubernetes.io/hostname"]
+}
+
+// remainingObjects returns the objects the fake cluster still holds.
+func remainingObjects(t *testing.T, state string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []string
+	for _, e := range entries {
+		for _, kind := range []string{"Pod-", "PersistentVolumeClaim-", "PersistentVolume-"} {
+			if strings.HasPrefix(e.Name(), kind) {
+				objects = append(objects, e.Name())
+			}
+		}
+	}
+	return objects
+}
+
+func TestK8sHandoff(t *testing.T) {
+	for _, staticPD := range []string{"", "pre-created-pd"} {
+		t.Run("static PD "+staticPD, func(t *testing.T) {
+			state := useFakeKubectl(t)
+			saved := *k8sStaticPD
+			*k8sStaticPD = staticPD
+			t.Cleanup(func() { *k8sStaticPD = saved })
+
+			r := newK8sRun(generatePdName(), [2]string{"node-a", "node-b"})
+			results, err := executeSteps(context.Background(), r.steps(), r.cleanupSteps(), &r.detail, r.pdName(), r.nodes[:])
+			if err != nil {
+				for _, result := range results {
+					t.Logf("%s: %v", result.Name, result.Err)
+				}
+				t.Fatalf("handoff failed: %v", err)
+			}
+
+			if node := podNode(t, state, r.writerPod()); node != "node-a" {
+				t.Errorf("writer pod pinned to %q, expected node-a", node)
+			}
+			if node := podNode(t, state, r.readerPod()); node != "node-b" {
+				t.Errorf("reader pod pinned to %q, expected node-b", node)
+			}
+			_, err = os.Stat(filepath.Join(state, "applied-PersistentVolume-"+r.name))
+			if staticPD != "" && err != nil {
+				t.Errorf("no static PV was applied: %v", err)
+			}
+			if staticPD == "" && err == nil {
+				t.Errorf("a static PV was applied without -k8s-static-pd")
+			}
+			for _, result := range results {
+				if strings.HasPrefix(result.Name, "start pod") && !strings.HasPrefix(result.Detail, "Running after") {
+					t.Errorf("step %q reported %q, expected the time to Running", result.Name, result.Detail)
+				}
+			}
+			if objects := remainingObjects(t, state); len(objects) != 0 {
+				t.Errorf("cleanup left objects behind: %v", objects)
+			}
+		})
+	}
+}
+
+func TestK8sHandoffDetectsLostData(t *testing.T) {
+	state := useFakeKubectl(t)
+	t.Setenv("FAKE_KUBECTL_LOSE_DATA", "1")
+
+	r := newK8sRun(generatePdName(), [2]string{"node-a", "node-b"})
+	results, err := executeSteps(context.Background(), r.steps(), r.cleanupSteps(), &r.detail, r.pdName(), r.nodes[:])
+	if err == nil {
+		t.Fatalf("handoff passed although the reader could not see the written file")
+	}
+	if !strings.Contains(err.Error(), "differs") {
+		t.Errorf("handoff failed with %v, expected the read step to report the changed content", err)
+	}
+	if last := results[len(results)-1]; last.Kind != "delete" || last.Err != nil {
+		t.Errorf("last step is %+v, expected the PVC deletion to run and succeed", last)
+	}
+	if objects := remainingObjects(t, state); len(objects) != 0 {
+		t.Errorf("cleanup left objects behind: %v", objects)
+	}
+}
@@ -1,6 +1,6 @@
 for (( ; ; ))
 do
-  go run gcePDCreateAttachMount.go
+  go run *.go
 
   if [ $? -ne 0 ]
   then
@@ -0,0 +1,145 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+)
+
+var luksKeyFile = flag.String("luks-key-file", "", "Encrypt the PD with LUKS using the key in this local file. Empty disables encryption.")
+
+// Key files are copied to tmpfs on the instance so they never reach a
+// persistent disk.
+const remoteKeyDir = "/run"
+
+func luksMapperName(pdName string) string {
+	return "luks-" + pdName
+}
+
+func luksMapperPath(pdName string) string {
+	return path.Join("/dev/mapper", luksMapperName(pdName))
+}
+
+func remoteKeyPath(pdName string) string {
+	return path.Join(remoteKeyDir, luksMapperName(pdName)+".key")
+}
+
+// openLUKS copies keyFile to instanceName and opens devPath as
+// /dev/mapper/luks-<pdName>. If format is set and devPath is not a LUKS
+// device yet, it is luksFormatted first. Without format a device that is not
+// LUKS is an error, so an unexpected device is never overwritten.
+func openLUKS(ctx context.Context, pdName, devPath, keyFile, instanceName string, readOnly, format bool) error {
+	log.Printf("Opening LUKS device %q as %q on %q\r\n", devPath, luksMapperName(pdName), instanceName)
+	defer fmt.Println("------------")
+
+	if _, err := os.Stat(keyFile); err != nil {
+		return fmt.Errorf("LUKS key file: %v", err)
+	}
+	keyPath := remoteKeyPath(pdName)
+	if err := provider.CopyToInstance(ctx, keyFile, keyPath, instanceName); err != nil {
+		log.Printf(
+			"Failed copying LUKS key to %q on %q. error: %v\r\n",
+			keyPath,
+			instanceName,
+			err)
+		return err
+	}
+	if _, err := runOnInstance(ctx, "chmod 600 "+keyPath, instanceName); err != nil {
+		return err
+	}
+
+	if _, err := runOnInstance(ctx, "cryptsetup isLuks "+devPath, instanceName); err != nil {
+		if !format {
+			return fmt.Errorf("%q on %q is not a LUKS device: %v", devPath, instanceName, err)
+		}
+		log.Printf("Formatting %q on %q with LUKS\r\n", devPath, instanceName)
+		remoteCommand := fmt.Sprintf("cryptsetup luksFormat --batch-mode --key-file %s %s", keyPath, devPath)
+		if _, err := runOnInstance(ctx, remoteCommand, instanceName); err != nil {
+			log.Printf(
+				"Failed to luksFormat %q on %q. error: %v\r\n",
+				devPath,
+				instanceName,
+				err)
+			return err
+		}
+	}
+
+	readOnlyFlag := ""
+	if readOnly {
+		readOnlyFlag = "--readonly "
+	}
+	remoteCommand := fmt.Sprintf("cryptsetup luksOpen %s--key-file %s %s %s", readOnlyFlag, keyPath, devPath, luksMapperName(pdName))
+	if _, err := runOnInstance(ctx, remoteCommand, instanceName); err != nil {
+		log.Printf(
+			"Failed to luksOpen %q on %q. error: %v\r\n",
+			devPath,
+			instanceName,
+			err)
+		return err
+	}
+	log.Printf("Opened %q as %q on %q\r\n", devPath, luksMapperPath(pdName), instanceName)
+	return nil
+}
+
+// closeLUKS closes the mapping of pdName on instanceName and removes the key
+// copied there. It must run before detach, or the mapping keeps pointing at
+// a device that is gone.
+func closeLUKS(ctx context.Context, pdName, instanceName string) error {
+	log.Printf("Closing LUKS mapping %q on %q\r\n", luksMapperName(pdName), instanceName)
+	defer fmt.Println("------------")
+
+	_, err := runOnInstance(ctx, "cryptsetup luksClose "+luksMapperName(pdName), instanceName)
+	if err != nil {
+		log.Printf(
+			"Failed to luksClose %q on %q. error: %v\r\n",
+			luksMapperName(pdName),
+			instanceName,
+			err)
+	}
+	// Remove the key even if the close failed: retrying the close does not
+	// need it.
+	if _, rmErr := runOnInstance(ctx, "rm -f "+remoteKeyPath(pdName), instanceName); rmErr != nil {
+		log.Printf("Failed to remove LUKS key from %q: %v\r\n", instanceName, rmErr)
+	}
+	return err
+}
+
+// verifyCiphertext checks that content is readable through the mapper device
+// but does not appear as plaintext anywhere on the raw device. The first
+// check makes sure the second one would find the content if it leaked.
+func verifyCiphertext(ctx context.Context, pdName, devPath, content, instanceName string) error {
+	log.Printf("Verifying %q is encrypted on %q on %q\r\n", content, devPath, instanceName)
+	defer fmt.Println("------------")
+
+	if _, err := runOnInstance(ctx, fmt.Sprintf("grep -a -q -F '%s' %s", content, luksMapperPath(pdName)), instanceName); err != nil {
+		return fmt.Errorf("content %q not found on the mapper device %q on %q: %v", content, luksMapperPath(pdName), instanceName, err)
+	}
+
+	// grep exits 1 when nothing matched and 2 on errors, so tell those apart
+	// explicitly instead of treating any failure as "not found".
+	remoteCommand := fmt.Sprintf("grep -a -c -F '%s' %s; test $? -eq 1", content, devPath)
+	if _, err := runOnInstance(ctx, remoteCommand, instanceName); err != nil {
+		return fmt.Errorf("plaintext %q found on the raw device %q on %q, or the device could not be read: %v", content, devPath, instanceName, err)
+	}
+	log.Printf("No plaintext %q on raw device %q on %q\r\n", content, devPath, instanceName)
+	return nil
+}
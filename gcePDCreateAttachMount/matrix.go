@@ -0,0 +1,342 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// matrixConfig declares the axes of a test matrix. Every combination of axis
+// values becomes a cell, minus cells matching an exclude rule, plus the cells
+// described by include rules. For example:
+//
+//	{
+//	  "axes": {
+//	    "fsType": ["ext4", "xfs"],
+//	    "mode": ["rw", "ro"],
+//	    "diskType": ["pd-standard", "pd-ssd", "pd-balanced"],
+//	    "sizeGB": [10],
+//	    "instancePairs": [["instance-a", "instance-b"]]
+//	  },
+//	  "exclude": [{"fsType": "xfs", "mode": "ro"}],
+//	  "include": [{"fsType": "ext4", "diskType": "pd-ssd", "sizeGB": 500}],
+//	  "mountOptions": ["noatime", "discard"],
+//	  "fsGroup": 2000,
+//	  "fsGroupChangePolicy": "OnRootMismatch",
+//	  "replicaZones": ["us-central1-b", "us-central1-c"],
+//	  "disk": {"snapshot": "ext4-seed", "labels": {"team": "storage"}, "physicalBlockSizeBytes": 16384},
+//	  "benchmark": {"patterns": ["randread"], "blockSizes": ["4k"], "queueDepths": [32], "runtimeSeconds": 30, "fileSize": "1G"},
+//	  "parallelism": 2
+//	}
+type matrixConfig struct {
+	Axes    matrixAxes   `json:"axes"`
+	Include []matrixRule `json:"include"`
+	Exclude []matrixRule `json:"exclude"`
+	// MountOptions apply to every cell.
+	MountOptions []string `json:"mountOptions"`
+	// FSGroup, FSGroupChangePolicy and FSGroupFiles apply to every cell.
+	FSGroup             *int64 `json:"fsGroup"`
+	FSGroupChangePolicy string `json:"fsGroupChangePolicy"`
+	FSGroupFiles        int    `json:"fsGroupFiles"`
+	// LUKSKeyFile encrypts every cell with the key in this local file.
+	LUKSKeyFile string `json:"luksKeyFile"`
+	// ReplicaZones makes every cell's disk a regional PD.
+	ReplicaZones []string `json:"replicaZones"`
+	// Disk sets the source, labels, physical block size and encryption key
+	// of every cell's disk. Type and size come from the axes.
+	Disk diskSpec `json:"disk"`
+	// Benchmark runs in every cell.
+	Benchmark   *benchmarkSpec `json:"benchmark"`
+	Parallelism int            `json:"parallelism"`
+}
+
+type matrixAxes struct {
+	FSTypes       []string    `json:"fsType"`
+	Modes         []string    `json:"mode"`
+	DiskTypes     []string    `json:"diskType"`
+	SizesGB       []int       `json:"sizeGB"`
+	InstancePairs [][2]string `json:"instancePairs"`
+}
+
+// matrixRule matches or describes a matrix cell. Unset fields match any value
+// in exclude rules, and take the first value of the axis in include rules.
+type matrixRule struct {
+	FSType       string    `json:"fsType"`
+	Mode         string    `json:"mode"`
+	DiskType     string    `json:"diskType"`
+	SizeGB       int       `json:"sizeGB"`
+	InstancePair [2]string `json:"instancePair"`
+}
+
+func (r matrixRule) matches(s scenario) bool {
+	if r.FSType != "" && r.FSType != s.FSType {
+		return false
+	}
+	if r.Mode != "" && r.Mode != modeString(s.ReadOnly) {
+		return false
+	}
+	if r.DiskType != "" && r.DiskType != s.Disk.Type {
+		return false
+	}
+	if r.SizeGB != 0 && r.SizeGB != s.Disk.SizeGB {
+		return false
+	}
+	if r.InstancePair[0] != "" && r.InstancePair != s.Instances {
+		return false
+	}
+	return true
+}
+
+func loadMatrixConfig(configPath string) (*matrixConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading matrix config %q failed: %v", configPath, err)
+	}
+
+	config := &matrixConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("parsing matrix config %q failed: %v", configPath, err)
+	}
+
+	// Axes that are not declared collapse to the defaults of a plain run.
+	defaults := defaultScenario()
+	if len(config.Axes.FSTypes) == 0 {
+		config.Axes.FSTypes = []string{defaults.FSType}
+	}
+	if len(config.Axes.Modes) == 0 {
+		config.Axes.Modes = []string{modeString(defaults.ReadOnly)}
+	}
+	if len(config.Axes.DiskTypes) == 0 {
+		config.Axes.DiskTypes = []string{defaults.Disk.Type}
+	}
+	if len(config.Axes.SizesGB) == 0 {
+		config.Axes.SizesGB = []int{defaults.Disk.SizeGB}
+	}
+	if len(config.Axes.InstancePairs) == 0 {
+		config.Axes.InstancePairs = [][2]string{defaults.Instances}
+	}
+	if config.Parallelism < 1 {
+		config.Parallelism = 1
+	}
+
+	if config.FSGroup != nil {
+		if config.FSGroupChangePolicy == "" {
+			config.FSGroupChangePolicy = fsGroupPolicyAlways
+		}
+		if err := validateFSGroupPolicy(config.FSGroupChangePolicy); err != nil {
+			return nil, fmt.Errorf("%v in matrix config %q", err, configPath)
+		}
+	}
+
+	for _, size := range config.Axes.SizesGB {
+		disk := config.cell(scenario{Disk: diskSpec{SizeGB: size}}).Disk
+		if err := disk.validate(); err != nil {
+			return nil, fmt.Errorf("%v in matrix config %q", err, configPath)
+		}
+	}
+
+	if config.Benchmark != nil {
+		if err := config.Benchmark.validate(); err != nil {
+			return nil, fmt.Errorf("%v in matrix config %q", err, configPath)
+		}
+	}
+
+	for _, mode := range config.Axes.Modes {
+		if mode != "rw" && mode != "ro" {
+			return nil, fmt.Errorf("invalid mode %q in matrix config %q, must be rw or ro", mode, configPath)
+		}
+	}
+	return config, nil
+}
+
+// expand returns the cartesian product of the axes with the include and
+// exclude rules applied.
+func (c *matrixConfig) expand() []scenario {
+	var cells []scenario
+	for _, fsType := range c.Axes.FSTypes {
+		for _, mode := range c.Axes.Modes {
+			for _, diskType := range c.Axes.DiskTypes {
+				for _, size := range c.Axes.SizesGB {
+					for _, pair := range c.Axes.InstancePairs {
+						cells = append(cells, c.cell(scenario{
+							FSType:    fsType,
+							ReadOnly:  mode == "ro",
+							Disk:      diskSpec{Type: diskType, SizeGB: size},
+							Instances: pair,
+						}))
+					}
+				}
+			}
+		}
+	}
+
+	var kept []scenario
+	for _, cell := range cells {
+		excluded := false
+		for _, rule := range c.Exclude {
+			if rule.matches(cell) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, cell)
+		}
+	}
+
+	for _, rule := range c.Include {
+		cell := c.cell(scenario{
+			FSType:    c.Axes.FSTypes[0],
+			ReadOnly:  c.Axes.Modes[0] == "ro",
+			Disk:      diskSpec{Type: c.Axes.DiskTypes[0], SizeGB: c.Axes.SizesGB[0]},
+			Instances: c.Axes.InstancePairs[0],
+		})
+		if rule.FSType != "" {
+			cell.FSType = rule.FSType
+		}
+		if rule.Mode != "" {
+			cell.ReadOnly = rule.Mode == "ro"
+		}
+		if rule.DiskType != "" {
+			cell.Disk.Type = rule.DiskType
+		}
+		if rule.SizeGB != 0 {
+			cell.Disk.SizeGB = rule.SizeGB
+		}
+		if rule.InstancePair[0] != "" {
+			cell.Instances = rule.InstancePair
+		}
+
+		duplicate := false
+		for _, existing := range kept {
+			if reflect.DeepEqual(existing, cell) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, cell)
+		}
+	}
+
+	return kept
+}
+
+// cell fills in the settings that apply to every cell of the matrix.
+func (c *matrixConfig) cell(s scenario) scenario {
+	s.MountOptions = c.MountOptions
+	s.FSGroup = c.FSGroup
+	s.FSGroupChangePolicy = c.FSGroupChangePolicy
+	s.FSGroupFiles = c.FSGroupFiles
+	s.LUKSKeyFile = c.LUKSKeyFile
+	s.Disk.ReplicaZones = c.ReplicaZones
+	s.Disk.Image = c.Disk.Image
+	s.Disk.Snapshot = c.Disk.Snapshot
+	s.Disk.Labels = c.Disk.Labels
+	s.Disk.PhysicalBlockSizeBytes = c.Disk.PhysicalBlockSizeBytes
+	s.Disk.KMSKey = c.Disk.KMSKey
+	s.Benchmark = c.Benchmark
+	return s
+}
+
+func runMatrix(ctx context.Context, configPath string) error {
+	config, err := loadMatrixConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	cells := config.expand()
+	for _, cell := range cells {
+		if err := provider.ValidateScenario(cell); err != nil {
+			return fmt.Errorf("%v; exclude it in matrix config %q", err, configPath)
+		}
+	}
+	log.Printf("***Running %d matrix cells with parallelism %d\r\n", len(cells), config.Parallelism)
+
+	// Every cell gets its own disk, named after the run and the cell index so
+	// parallel cells never collide.
+	baseName := generatePdName()
+	results := make([]*runResult, len(cells))
+	sem := make(chan struct{}, config.Parallelism)
+	var wg sync.WaitGroup
+	for i, cell := range cells {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, cell scenario) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runScenario(ctx, cell, fmt.Sprintf("%s-%d", baseName, i))
+		}(i, cell)
+	}
+	wg.Wait()
+
+	printMatrixTable(os.Stdout, results)
+	if config.Benchmark != nil {
+		printBenchmarkTable(os.Stdout, results)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d matrix cells failed", failed, len(results))
+	}
+	return nil
+}
+
+func printMatrixTable(w io.Writer, results []*runResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FSTYPE\tMODE\tDISK TYPE\tSIZE\tINSTANCES\tRESULT\tTOTAL\tCREATE\tATTACH\tMOUNT\tDETACH\tDELETE")
+	for _, r := range results {
+		s := r.Scenario
+		diskType := s.Disk.Type
+		if diskType == "" {
+			diskType = "default"
+		}
+		status := "PASS"
+		if r.Err != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%dGB\t%s,%s\t%s\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			s.FSType,
+			modeString(s.ReadOnly),
+			diskType,
+			s.Disk.SizeGB,
+			s.Instances[0],
+			s.Instances[1],
+			status,
+			r.Duration.Round(time.Second),
+			r.kindLatency("create").Round(time.Millisecond),
+			r.kindLatency("attach").Round(time.Millisecond),
+			r.kindLatency("mount").Round(time.Millisecond),
+			r.kindLatency("detach").Round(time.Millisecond),
+			r.kindLatency("delete").Round(time.Millisecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// loadTestMatrix loads config from a temporary matrix config file.
+func loadTestMatrix(t *testing.T, config string) (*matrixConfig, error) {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "matrix.json")
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return loadMatrixConfig(configPath)
+}
+
+func cellNames(cells []scenario) []string {
+	var names []string
+	for _, cell := range cells {
+		names = append(names, cell.String())
+	}
+	return names
+}
+
+func TestMatrixExpand(t *testing.T) {
+	config, err := loadTestMatrix(t, `{
+		"axes": {
+			"fsType": ["ext4", "xfs"],
+			"mode": ["rw", "ro"],
+			"diskType": ["pd-standard", "pd-ssd"],
+			"sizeGB": [10],
+			"instancePairs": [["node-a", "node-b"]]
+		},
+		"exclude": [
+			{"fsType": "xfs", "mode": "ro"},
+			{"diskType": "pd-ssd", "mode": "ro"}
+		],
+		"include": [
+			{"fsType": "ext4", "diskType": "pd-ssd", "sizeGB": 500},
+			{"fsType": "xfs"},
+			{"mode": "ro", "diskType": "pd-ssd", "instancePair": ["node-c", "node-d"]}
+		],
+		"mountOptions": ["noatime"]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"ext4/rw/pd-standard/10GB/node-a+node-b/noatime",
+		"ext4/rw/pd-ssd/10GB/node-a+node-b/noatime",
+		"ext4/ro/pd-standard/10GB/node-a+node-b/noatime",
+		"xfs/rw/pd-standard/10GB/node-a+node-b/noatime",
+		"xfs/rw/pd-ssd/10GB/node-a+node-b/noatime",
+		// Include rules take unset fields from the first axis values.
+		"ext4/rw/pd-ssd/500GB/node-a+node-b/noatime",
+		// The xfs include duplicates a product cell and is dropped, while
+		// includes are added even where an exclude rule matches.
+		"ext4/ro/pd-ssd/10GB/node-c+node-d/noatime",
+	}
+	if actual := cellNames(config.expand()); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expand() returned\n%q\nexpected\n%q", actual, expected)
+	}
+}
+
+func TestMatrixAxesDefaultToAPlainRun(t *testing.T) {
+	config, err := loadTestMatrix(t, `{"axes": {"fsType": ["ext4", "xfs"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells := config.expand()
+	if len(cells) != 2 {
+		t.Fatalf("expand() returned %q, expected one cell per file system", cellNames(cells))
+	}
+	defaults := defaultScenario()
+	for i, fsType := range []string{"ext4", "xfs"} {
+		cell := cells[i]
+		if cell.FSType != fsType || cell.ReadOnly != defaults.ReadOnly || cell.Disk.Type != defaults.Disk.Type || cell.Disk.SizeGB != defaults.Disk.SizeGB || cell.Instances != defaults.Instances {
+			t.Errorf("cell %d is %s, expected %s with the defaults of a plain run", i, cell, fsType)
+		}
+	}
+	if config.Parallelism != 1 {
+		t.Errorf("parallelism is %d, expected 1", config.Parallelism)
+	}
+}
+
+func TestMatrixRuleMatches(t *testing.T) {
+	s := scenario{FSType: "xfs", ReadOnly: true, Disk: diskSpec{Type: "pd-ssd", SizeGB: 10}, Instances: [2]string{"node-a", "node-b"}}
+	for _, tc := range []struct {
+		name    string
+		rule    matrixRule
+		matches bool
+	}{
+		{name: "empty", rule: matrixRule{}, matches: true},
+		{name: "all fields", rule: matrixRule{FSType: "xfs", Mode: "ro", DiskType: "pd-ssd", SizeGB: 10, InstancePair: [2]string{"node-a", "node-b"}}, matches: true},
+		{name: "fsType", rule: matrixRule{FSType: "ext4"}},
+		{name: "mode", rule: matrixRule{FSType: "xfs", Mode: "rw"}},
+		{name: "diskType", rule: matrixRule{DiskType: "pd-standard"}},
+		{name: "size", rule: matrixRule{SizeGB: 500}},
+		{name: "instance pair", rule: matrixRule{InstancePair: [2]string{"node-b", "node-a"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if matches := tc.rule.matches(s); matches != tc.matches {
+				t.Errorf("%+v matches %s = %v, expected %v", tc.rule, s, matches, tc.matches)
+			}
+		})
+	}
+}
+
+func TestMatrixCellAppliesSharedSettings(t *testing.T) {
+	gid := int64(2000)
+	config, err := loadTestMatrix(t, `{
+		"axes": {"sizeGB": [10, 20]},
+		"mountOptions": ["discard"],
+		"fsGroup": 2000,
+		"disk": {"snapshot": "ext4-seed", "labels": {"team": "storage"}, "physicalBlockSizeBytes": 16384}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cell := range config.expand() {
+		if !reflect.DeepEqual(cell.MountOptions, []string{"discard"}) || cell.FSGroup == nil || *cell.FSGroup != gid || cell.FSGroupChangePolicy != fsGroupPolicyAlways {
+			t.Errorf("cell %s does not carry the shared mount options and fsGroup", cell)
+		}
+		if cell.Disk.Snapshot != "ext4-seed" || cell.Disk.Labels["team"] != "storage" || cell.Disk.PhysicalBlockSizeBytes != 16384 {
+			t.Errorf("cell %s has disk %+v, expected the shared disk settings", cell, cell.Disk)
+		}
+	}
+}
+
+func TestLoadMatrixConfigRejectsInvalidConfigs(t *testing.T) {
+	for name, config := range map[string]string{
+		"invalid mode":     `{"axes": {"mode": ["rw", "wo"]}}`,
+		"invalid size":     `{"axes": {"sizeGB": [0]}}`,
+		"invalid policy":   `{"fsGroup": 2000, "fsGroupChangePolicy": "Sometimes"}`,
+		"malformed config": `{"axes": `,
+	} {
+		if _, err := loadTestMatrix(t, config); err == nil {
+			t.Errorf("%s: loadMatrixConfig accepted %s", name, config)
+		}
+	}
+}
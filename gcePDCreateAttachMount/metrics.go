@@ -0,0 +1,186 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const metricsNamespace = "pd_lifecycle"
+
+// Upper bounds, in seconds, of the latency histogram buckets. Attach and
+// detach routinely take tens of seconds so the buckets go up to 5 minutes.
+var latencyBuckets = []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+var metrics = newMetricsRegistry()
+
+// metricsRegistry holds the soak test metrics and renders them in the
+// Prometheus text exposition format.
+type metricsRegistry struct {
+	mu          sync.Mutex
+	operations  map[operationKey]uint64
+	latencies   map[string]*histogram
+	leakedDisks map[string]bool
+	lastFailure time.Time
+}
+
+type operationKey struct {
+	operation string
+	result    string
+}
+
+type histogram struct {
+	counts []uint64 // one per latencyBuckets entry, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		operations:  make(map[operationKey]uint64),
+		latencies:   make(map[string]*histogram),
+		leakedDisks: make(map[string]bool),
+	}
+}
+
+// observeOperation records the result and latency of a single operation
+// that started at start and finished now.
+func (m *metricsRegistry) observeOperation(operation string, start time.Time, err error) {
+	m.observeLatency(operation, time.Since(start), err)
+}
+
+// observeLatency records the result of an operation that took latency.
+func (m *metricsRegistry) observeLatency(operation string, latency time.Duration, err error) {
+	elapsed := latency.Seconds()
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operations[operationKey{operation, result}]++
+	if err != nil {
+		m.lastFailure = time.Now()
+	}
+
+	h, ok := m.latencies[operation]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[operation] = h
+	}
+	for i, bound := range latencyBuckets {
+		if elapsed <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += elapsed
+}
+
+// setDiskLeaked marks pdName as leaked (created but could not be cleaned up)
+// or clears the mark once the disk has been deleted.
+func (m *metricsRegistry) setDiskLeaked(pdName string, leaked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if leaked {
+		m.leakedDisks[pdName] = true
+	} else {
+		delete(m.leakedDisks, pdName)
+	}
+}
+
+func (m *metricsRegistry) render() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# HELP %s_operations_total Number of PD lifecycle operations by type and result.\n", metricsNamespace)
+	fmt.Fprintf(&buf, "# TYPE %s_operations_total counter\n", metricsNamespace)
+	keys := make([]operationKey, 0, len(m.operations))
+	for key := range m.operations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].result < keys[j].result
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s_operations_total{operation=%q,result=%q} %d\n", metricsNamespace, key.operation, key.result, m.operations[key])
+	}
+
+	fmt.Fprintf(&buf, "# HELP %s_operation_duration_seconds Latency of PD lifecycle operations.\n", metricsNamespace)
+	fmt.Fprintf(&buf, "# TYPE %s_operation_duration_seconds histogram\n", metricsNamespace)
+	operations := make([]string, 0, len(m.latencies))
+	for operation := range m.latencies {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		h := m.latencies[operation]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&buf, "%s_operation_duration_seconds_bucket{operation=%q,le=\"%g\"} %d\n", metricsNamespace, operation, bound, cumulative)
+		}
+		fmt.Fprintf(&buf, "%s_operation_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", metricsNamespace, operation, h.count)
+		fmt.Fprintf(&buf, "%s_operation_duration_seconds_sum{operation=%q} %g\n", metricsNamespace, operation, h.sum)
+		fmt.Fprintf(&buf, "%s_operation_duration_seconds_count{operation=%q} %d\n", metricsNamespace, operation, h.count)
+	}
+
+	fmt.Fprintf(&buf, "# HELP %s_leaked_disks Number of disks created by the test that could not be deleted.\n", metricsNamespace)
+	fmt.Fprintf(&buf, "# TYPE %s_leaked_disks gauge\n", metricsNamespace)
+	fmt.Fprintf(&buf, "%s_leaked_disks %d\n", metricsNamespace, len(m.leakedDisks))
+
+	fmt.Fprintf(&buf, "# HELP %s_last_failure_timestamp_seconds Unix time of the last failed operation, 0 if none.\n", metricsNamespace)
+	fmt.Fprintf(&buf, "# TYPE %s_last_failure_timestamp_seconds gauge\n", metricsNamespace)
+	lastFailure := int64(0)
+	if !m.lastFailure.IsZero() {
+		lastFailure = m.lastFailure.Unix()
+	}
+	fmt.Fprintf(&buf, "%s_last_failure_timestamp_seconds %d\n", metricsNamespace, lastFailure)
+
+	return buf.Bytes()
+}
+
+func startMetricsServer(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(metrics.render())
+	})
+
+	addr := fmt.Sprintf(":%d", port)
+	log.Printf("Serving metrics on %s/metrics\r\n", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server on %s stopped: %v\r\n", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRenderEmpty(t *testing.T) {
+	expected := `# HELP pd_lifecycle_operations_total Number of PD lifecycle operations by type and result.
+# TYPE pd_lifecycle_operations_total counter
+# HELP pd_lifecycle_operation_duration_seconds Latency of PD lifecycle operations.
+# TYPE pd_lifecycle_operation_duration_seconds histogram
+# HELP pd_lifecycle_leaked_disks Number of disks created by the test that could not be deleted.
+# TYPE pd_lifecycle_leaked_disks gauge
+pd_lifecycle_leaked_disks 0
+# HELP pd_lifecycle_last_failure_timestamp_seconds Unix time of the last failed operation, 0 if none.
+# TYPE pd_lifecycle_last_failure_timestamp_seconds gauge
+pd_lifecycle_last_failure_timestamp_seconds 0
+`
+	if actual := string(newMetricsRegistry().render()); actual != expected {
+		t.Errorf("render() of an empty registry returned\n%s\nexpected\n%s", actual, expected)
+	}
+}
+
+func TestMetricsRender(t *testing.T) {
+	m := newMetricsRegistry()
+	before := time.Now().Unix()
+	m.observeLatency("attach", 250*time.Millisecond, nil)
+	m.observeLatency("attach", 4*time.Second, errors.New("attach failed"))
+	// Slower than the largest bucket: only counted in +Inf.
+	m.observeLatency("attach", 400*time.Second, nil)
+	// On a bucket bound: counted in that bucket.
+	m.observeLatency("detach", time.Second, nil)
+	after := time.Now().Unix()
+
+	m.setDiskLeaked("pd-1", true)
+	m.setDiskLeaked("pd-2", true)
+	m.setDiskLeaked("pd-2", true)
+	m.setDiskLeaked("pd-1", false)
+	m.setDiskLeaked("pd-3", false)
+
+	output := string(m.render())
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	last := lines[len(lines)-1]
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(last, "pd_lifecycle_last_failure_timestamp_seconds "), 10, 64)
+	if err != nil || timestamp < before || timestamp > after {
+		t.Errorf("last line is %q, expected the last failure timestamp between %d and %d", last, before, after)
+	}
+
+	expected := `# HELP pd_lifecycle_operations_total Number of PD lifecycle operations by type and result.
+# TYPE pd_lifecycle_operations_total counter
+pd_lifecycle_operations_total{operation="attach",result="failure"} 1
+pd_lifecycle_operations_total{operation="attach",result="success"} 2
+pd_lifecycle_operations_total{operation="detach",result="success"} 1
+# HELP pd_lifecycle_operation_duration_seconds Latency of PD lifecycle operations.
+# TYPE pd_lifecycle_operation_duration_seconds histogram
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="0.5"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="1"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="2.5"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="5"} 2
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="10"} 2
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="20"} 2
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="30"} 2
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="60"} 2
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="120"} 2
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="300"} 2
+pd_lifecycle_operation_duration_seconds_bucket{operation="attach",le="+Inf"} 3
+pd_lifecycle_operation_duration_seconds_sum{operation="attach"} 404.25
+pd_lifecycle_operation_duration_seconds_count{operation="attach"} 3
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="0.5"} 0
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="1"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="2.5"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="5"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="10"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="20"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="30"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="60"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="120"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="300"} 1
+pd_lifecycle_operation_duration_seconds_bucket{operation="detach",le="+Inf"} 1
+pd_lifecycle_operation_duration_seconds_sum{operation="detach"} 1
+pd_lifecycle_operation_duration_seconds_count{operation="detach"} 1
+# HELP pd_lifecycle_leaked_disks Number of disks created by the test that could not be deleted.
+# TYPE pd_lifecycle_leaked_disks gauge
+pd_lifecycle_leaked_disks 1
+# HELP pd_lifecycle_last_failure_timestamp_seconds Unix time of the last failed operation, 0 if none.
+# TYPE pd_lifecycle_last_failure_timestamp_seconds gauge
+`
+	if actual := strings.TrimSuffix(output, last+"\n"); actual != expected {
+		t.Errorf("render() returned\n%s\nexpected\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,466 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	modelSeed        = flag.Int64("model-seed", 0, "Seed for the model subcommand's operation sequence. 0 picks one from the clock.")
+	modelSteps       = flag.Int("model-steps", 30, "Number of operations the model subcommand generates.")
+	modelDisks       = flag.Int("model-disks", 2, "Number of disks the model subcommand operates on.")
+	modelInvalid     = flag.Bool("model-invalid", false, "Also generate operations the model expects to fail.")
+	modelInvalidRate = flag.Float64("model-invalid-rate", 0.2, "Fraction of operations that are expected to fail when -model-invalid is set.")
+	modelMaxShrinks  = flag.Int("model-max-shrinks", 40, "Maximum number of replays spent shrinking a failing sequence.")
+	modelInstances   = flag.String("model-instances", testInstance0Name+","+testInstance1Name, "Comma separated instances the model subcommand attaches disks to.")
+)
+
+var modelOpKinds = []string{"create", "attach", "mount", "bind", "write", "read", "unbind", "unmount", "detach", "delete"}
+
+// modelOp is a single generated operation. Disk and Instance index into the
+// disks and instances of a model run.
+type modelOp struct {
+	Kind     string
+	Disk     int
+	Instance int
+	ReadOnly bool
+	// Content is written by write operations.
+	Content string
+}
+
+func (op modelOp) String() string {
+	switch op.Kind {
+	case "create", "delete":
+		return fmt.Sprintf("%s(d%d)", op.Kind, op.Disk)
+	case "attach", "mount", "bind":
+		return fmt.Sprintf("%s(d%d, i%d, %s)", op.Kind, op.Disk, op.Instance, modeString(op.ReadOnly))
+	case "write":
+		return fmt.Sprintf("write(d%d, i%d, %q)", op.Disk, op.Instance, op.Content)
+	}
+	return fmt.Sprintf("%s(d%d, i%d)", op.Kind, op.Disk, op.Instance)
+}
+
+// modelDisk is the model's view of one disk.
+type modelDisk struct {
+	exists    bool
+	formatted bool
+	// attachments maps instance index to whether it is attached read-only.
+	attachments map[int]bool
+	mounted     map[int]bool
+	bound       map[int]bool
+	// content is the last content written to the test file, "" if none.
+	content string
+}
+
+// lifecycleModel predicts the outcome of operations on disks, attachments and
+// mounts.
+type lifecycleModel struct {
+	disks []*modelDisk
+}
+
+func newLifecycleModel(numDisks int) *lifecycleModel {
+	m := &lifecycleModel{}
+	for i := 0; i < numDisks; i++ {
+		m.disks = append(m.disks, &modelDisk{
+			attachments: make(map[int]bool),
+			mounted:     make(map[int]bool),
+			bound:       make(map[int]bool),
+		})
+	}
+	return m
+}
+
+// predict returns whether op is expected to succeed. ok is false for
+// operations whose outcome the model cannot predict, such as detaching a
+// disk that is still mounted.
+func (m *lifecycleModel) predict(op modelOp) (success, ok bool) {
+	d := m.disks[op.Disk]
+	readOnly, attached := d.attachments[op.Instance]
+
+	switch op.Kind {
+	case "create":
+		return !d.exists, true
+	case "delete":
+		return d.exists && len(d.attachments) == 0, true
+	case "attach":
+		if !d.exists || attached {
+			return false, true
+		}
+		for _, ro := range d.attachments {
+			// A read-write attachment excludes every other attachment.
+			if !ro || !op.ReadOnly {
+				return false, true
+			}
+		}
+		return true, true
+	case "detach":
+		if attached && d.mounted[op.Instance] {
+			return false, false
+		}
+		return attached, true
+	case "mount":
+		if attached && op.ReadOnly != readOnly {
+			// Mounting read-write on a read-only attachment silently falls
+			// back to read-only.
+			return false, false
+		}
+		if !attached || d.mounted[op.Instance] {
+			return false, true
+		}
+		// A read-only mount cannot format the disk.
+		return !readOnly || d.formatted, true
+	case "bind":
+		if attached && op.ReadOnly != readOnly {
+			return false, false
+		}
+		return d.mounted[op.Instance] && !d.bound[op.Instance], true
+	case "write":
+		return d.bound[op.Instance] && !readOnly, true
+	case "read":
+		return d.bound[op.Instance] && d.content != "", true
+	case "unbind":
+		return d.bound[op.Instance], true
+	case "unmount":
+		if d.bound[op.Instance] {
+			return false, false
+		}
+		return d.mounted[op.Instance], true
+	}
+	return false, false
+}
+
+// apply updates the model for an operation that succeeded.
+func (m *lifecycleModel) apply(op modelOp) {
+	d := m.disks[op.Disk]
+	switch op.Kind {
+	case "create":
+		d.exists = true
+	case "delete":
+		*d = *newLifecycleModel(1).disks[0]
+	case "attach":
+		d.attachments[op.Instance] = op.ReadOnly
+	case "detach":
+		delete(d.attachments, op.Instance)
+	case "mount":
+		d.mounted[op.Instance] = true
+		d.formatted = true
+	case "bind":
+		d.bound[op.Instance] = true
+	case "write":
+		d.content = op.Content
+	case "unbind":
+		delete(d.bound, op.Instance)
+	case "unmount":
+		delete(d.mounted, op.Instance)
+	}
+}
+
+// generateModelOps returns a random sequence of operations. Operations are
+// drawn from those the model predicts to succeed, or with invalid set, from
+// those it predicts to fail at invalidRate.
+func generateModelOps(seed int64, steps, numDisks, numInstances int, invalid bool, invalidRate float64) []modelOp {
+	r := rand.New(rand.NewSource(seed))
+	m := newLifecycleModel(numDisks)
+
+	var ops []modelOp
+	for len(ops) < steps {
+		wantSuccess := !invalid || r.Float64() >= invalidRate
+
+		var candidates []modelOp
+		for _, kind := range modelOpKinds {
+			for disk := 0; disk < numDisks; disk++ {
+				for instance := 0; instance < numInstances; instance++ {
+					for _, readOnly := range []bool{false, true} {
+						if readOnly && kind != "attach" && kind != "mount" && kind != "bind" {
+							continue
+						}
+						op := modelOp{Kind: kind, Disk: disk, Instance: instance, ReadOnly: readOnly}
+						if success, ok := m.predict(op); ok && success == wantSuccess {
+							candidates = append(candidates, op)
+						}
+					}
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		op := candidates[r.Intn(len(candidates))]
+		if op.Kind == "write" {
+			op.Content = fmt.Sprintf("model %d op %d", seed, len(ops))
+		}
+		if success, _ := m.predict(op); success {
+			m.apply(op)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// modelDivergence describes the first operation whose observed outcome did
+// not match the model.
+type modelDivergence struct {
+	index    int
+	op       modelOp
+	expected bool
+	err      error
+	detail   string
+}
+
+func (d *modelDivergence) String() string {
+	if d.detail != "" {
+		return fmt.Sprintf("op %d %v: %s", d.index, d.op, d.detail)
+	}
+	return fmt.Sprintf("op %d %v: expected success=%v, got error %v", d.index, d.op, d.expected, d.err)
+}
+
+// modelExecutor runs model operations against real disks and instances.
+type modelExecutor struct {
+	pdNames   []string
+	instances []string
+	// steps, if set, records every operation for the history file.
+	steps *stepRecorder
+}
+
+// run executes ops in order, comparing each outcome with the model, and
+// cleans up everything it created before returning. It returns the first
+// divergence, or nil if the sequence matched the model. ok is false if the
+// sequence contains an operation the model cannot predict.
+func (e *modelExecutor) run(ctx context.Context, ops []modelOp) (divergence *modelDivergence, ok bool) {
+	defer e.cleanup(ctx)
+
+	m := newLifecycleModel(len(e.pdNames))
+	for i, op := range ops {
+		expected, predictable := m.predict(op)
+		if !predictable {
+			return nil, false
+		}
+
+		log.Printf("***Model op %d: %v (expect success=%v)\r\n", i, op, expected)
+		start := time.Now()
+		content, err := e.execute(ctx, op, expected)
+		if (err == nil) != expected {
+			divergence = &modelDivergence{index: i, op: op, expected: expected, err: err}
+		} else if op.Kind == "read" && expected && content != m.disks[op.Disk].content {
+			divergence = &modelDivergence{
+				index:    i,
+				op:       op,
+				expected: expected,
+				detail:   fmt.Sprintf("read %q, model expected %q", content, m.disks[op.Disk].content),
+			}
+		}
+		if e.steps != nil {
+			// An operation the model expects to fail passes by failing.
+			var stepErr error
+			if divergence != nil {
+				stepErr = errors.New(divergence.String())
+			}
+			e.steps.record(op.String(), op.Kind, start, stepErr)
+		}
+		if divergence != nil {
+			return divergence, true
+		}
+		if expected {
+			m.apply(op)
+		}
+	}
+	return nil, true
+}
+
+// execute runs a single operation. Operations expected to succeed go through
+// the retrying helpers; those expected to fail are attempted once so the
+// failure is not retried away.
+func (e *modelExecutor) execute(ctx context.Context, op modelOp, expected bool) (string, error) {
+	pdName := e.pdNames[op.Disk]
+	instanceName := e.instances[op.Instance]
+	devGlobalMountPath := getDeviceGlobalMountPath(pdName)
+	finalMountPath := getFinalMountPath(pdName)
+
+	switch op.Kind {
+	case "create":
+		if expected {
+			_, err := createPDWithRetry(ctx, pdName, defaultScenario().Disk)
+			return "", err
+		}
+		return "", provider.CreateVolume(ctx, pdName, defaultScenario().Disk)
+	case "delete":
+		if expected {
+			return "", deletePDWithRetry(ctx, pdName)
+		}
+		return "", provider.DeleteVolume(ctx, pdName)
+	case "attach":
+		if expected {
+			return "", attachDiskWithRetry(ctx, pdName, instanceName, op.ReadOnly)
+		}
+		return "", provider.AttachVolume(ctx, pdName, instanceName, op.ReadOnly)
+	case "detach":
+		if expected {
+			return "", detachDiskWithRetry(ctx, pdName, instanceName)
+		}
+		return "", provider.DetachVolume(ctx, pdName, instanceName)
+	case "mount":
+		devPath, err := provider.DevicePath(pdName, instanceName)
+		if err != nil {
+			return "", err
+		}
+		return "", mountDevice(ctx, devPath, devGlobalMountPath, instanceName, testFSType, op.ReadOnly, nil /* mountOptions */)
+	case "bind":
+		return "", bindMountToFinalPath(ctx, devGlobalMountPath, finalMountPath, instanceName, op.ReadOnly, nil /* mountOptions */)
+	case "write":
+		_, err := WriteContentToFile(ctx, op.Content, path.Join(finalMountPath, testFileName), instanceName)
+		return "", err
+	case "read":
+		return ReadContentsFromFile(ctx, path.Join(finalMountPath, testFileName), instanceName)
+	case "unbind":
+		_, err := removeBindMount(ctx, finalMountPath, instanceName)
+		return "", err
+	case "unmount":
+		_, err := unmountDevice(ctx, devGlobalMountPath, instanceName)
+		return "", err
+	}
+	return "", fmt.Errorf("unknown model operation %q", op.Kind)
+}
+
+// cleanup removes every mount, attachment and disk a run may have left
+// behind. It does not trust the model, since a divergence means the model and
+// the environment disagree.
+func (e *modelExecutor) cleanup(ctx context.Context) {
+	log.Println("***Cleaning up model run")
+	for _, pdName := range e.pdNames {
+		users, err := provider.VolumeUsers(ctx, pdName)
+		if err != nil {
+			// The disk does not exist, so nothing can be attached or mounted.
+			continue
+		}
+		for _, instanceName := range e.instances {
+			removeBindMount(ctx, getFinalMountPath(pdName), instanceName)
+			unmountDevice(ctx, getDeviceGlobalMountPath(pdName), instanceName)
+		}
+		for _, user := range users {
+			detachDiskWithRetry(ctx, pdName, user)
+		}
+		if err := deletePDWithRetry(ctx, pdName); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// shrinkModelOps looks for a shorter sequence that still diverges from the
+// model by repeatedly dropping chunks of operations, halving the chunk size
+// whenever no chunk can be dropped.
+func shrinkModelOps(ops []modelOp, maxReplays int, diverges func([]modelOp) bool) []modelOp {
+	replays := 0
+	for chunk := len(ops) / 2; chunk >= 1 && replays < maxReplays; {
+		removed := false
+		for start := 0; start+chunk <= len(ops) && replays < maxReplays; start += chunk {
+			candidate := append(append([]modelOp(nil), ops[:start]...), ops[start+chunk:]...)
+			replays++
+			if diverges(candidate) {
+				log.Printf("***Shrunk failing sequence from %d to %d ops\r\n", len(ops), len(candidate))
+				ops = candidate
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			chunk /= 2
+		}
+	}
+	return ops
+}
+
+func runModel(ctx context.Context) error {
+	seed := *modelSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	instances := strings.Split(*modelInstances, ",")
+	ops := generateModelOps(seed, *modelSteps, *modelDisks, len(instances), *modelInvalid, *modelInvalidRate)
+	log.Printf("***Model run with seed %d: %d ops on %d disks\r\n", seed, len(ops), *modelDisks)
+
+	baseName := generatePdName()
+	replay := 0
+	newExecutor := func() *modelExecutor {
+		e := &modelExecutor{instances: instances}
+		for i := 0; i < *modelDisks; i++ {
+			e.pdNames = append(e.pdNames, fmt.Sprintf("%s-m%d-%d", baseName, replay, i))
+		}
+		replay++
+		return e
+	}
+
+	start := time.Now()
+	first := newExecutor()
+	first.steps = &stepRecorder{}
+	divergence, _ := first.run(ctx, ops)
+	var runErr error
+	if divergence != nil {
+		runErr = errors.New(divergence.String())
+	}
+	// Shrinking replays are not recorded: they would skew the failure rates.
+	recordHistory("model", modelRerunFlags(seed), baseName, start, first.steps.results(), runErr, false /* resumed */)
+	if divergence == nil {
+		log.Printf("***Model run with seed %d matched the model\r\n", seed)
+		return nil
+	}
+	log.Printf("***Divergence with seed %d at %v\r\n", seed, divergence)
+
+	// Nothing after the divergence can matter.
+	failing := ops[:divergence.index+1]
+	shrunk := shrinkModelOps(failing, *modelMaxShrinks, func(candidate []modelOp) bool {
+		d, ok := newExecutor().run(ctx, candidate)
+		return ok && d != nil
+	})
+
+	return fmt.Errorf(
+		"model divergence with seed %d (rerun with %s): %v\nminimal failing sequence:\n  %s",
+		seed,
+		modelRerunFlags(seed),
+		divergence,
+		joinModelOps(shrunk, "\n  "))
+}
+
+// modelRerunFlags returns the flags that make the model subcommand generate
+// the same operation sequence against the same instances again.
+func modelRerunFlags(seed int64) string {
+	return fmt.Sprintf(
+		"-model-seed=%d -model-steps=%d -model-disks=%d -model-instances=%s -model-invalid=%v -model-invalid-rate=%v",
+		seed,
+		*modelSteps,
+		*modelDisks,
+		*modelInstances,
+		*modelInvalid,
+		*modelInvalidRate)
+}
+
+func joinModelOps(ops []modelOp, sep string) string {
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = op.String()
+	}
+	return strings.Join(s, sep)
+}
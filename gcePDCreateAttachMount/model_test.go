@@ -0,0 +1,109 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateModelOpsIsDeterministic(t *testing.T) {
+	for _, invalid := range []bool{false, true} {
+		ops := generateModelOps(42, 50, 2, 2, invalid, 0.3)
+		if len(ops) != 50 {
+			t.Fatalf("generated %d ops, expected 50", len(ops))
+		}
+		if again := generateModelOps(42, 50, 2, 2, invalid, 0.3); !reflect.DeepEqual(again, ops) {
+			t.Errorf("seed 42 with invalid=%v generated\n  %s\nthen\n  %s", invalid, joinModelOps(ops, "\n  "), joinModelOps(again, "\n  "))
+		}
+		if other := generateModelOps(43, 50, 2, 2, invalid, 0.3); reflect.DeepEqual(other, ops) {
+			t.Errorf("seeds 42 and 43 with invalid=%v generated the same sequence", invalid)
+		}
+	}
+}
+
+func TestGenerateModelOpsFollowsTheModel(t *testing.T) {
+	ops := generateModelOps(7, 100, 3, 2, false /* invalid */, 0)
+	m := newLifecycleModel(3)
+	for i, op := range ops {
+		success, ok := m.predict(op)
+		if !ok || !success {
+			t.Fatalf("op %d %v is not predicted to succeed", i, op)
+		}
+		m.apply(op)
+	}
+}
+
+func TestModelRerunFlagsReproduceTheSequence(t *testing.T) {
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "model-") {
+			names = append(names, f.Name)
+		}
+	})
+	saved := make(map[string]string)
+	for _, name := range names {
+		saved[name] = flag.Lookup(name).Value.String()
+	}
+	t.Cleanup(func() {
+		for name, value := range saved {
+			flag.Set(name, value)
+		}
+	})
+	generate := func(seed int64) []modelOp {
+		return generateModelOps(seed, *modelSteps, *modelDisks, len(strings.Split(*modelInstances, ",")), *modelInvalid, *modelInvalidRate)
+	}
+
+	for name, value := range map[string]string{
+		"model-steps":        "17",
+		"model-disks":        "3",
+		"model-instances":    "node-a,node-b,node-c",
+		"model-invalid":      "true",
+		"model-invalid-rate": "0.45",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := generate(1234)
+	rerun := modelRerunFlags(1234)
+
+	for name, value := range saved {
+		flag.Set(name, value)
+	}
+	for _, arg := range strings.Fields(rerun) {
+		kv := strings.SplitN(strings.TrimPrefix(arg, "-"), "=", 2)
+		if len(kv) != 2 {
+			t.Fatalf("rerun flag %q is not -name=value", arg)
+		}
+		if err := flag.Set(kv[0], kv[1]); err != nil {
+			t.Fatalf("rerun flag %q: %v", arg, err)
+		}
+	}
+	seed := *modelSeed
+	if seed != 1234 {
+		t.Errorf("rerun flags %q set seed %d, expected 1234", rerun, seed)
+	}
+	if *modelInstances != "node-a,node-b,node-c" {
+		t.Errorf("rerun flags %q set instances %q, expected node-a,node-b,node-c", rerun, *modelInstances)
+	}
+	if actual := generate(seed); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("rerun flags %q generated\n  %s\nexpected\n  %s", rerun, joinModelOps(actual, "\n  "), joinModelOps(expected, "\n  "))
+	}
+}
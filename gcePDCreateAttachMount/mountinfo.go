@@ -0,0 +1,204 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// mountInfo is one line of /proc/self/mountinfo.
+type mountInfo struct {
+	MountPoint string
+	Root       string
+	FSType     string
+	Source     string
+	// MountOptions are the per-mount options, e.g. ro or noatime.
+	MountOptions []string
+	// SuperOptions are the per-superblock options, e.g. discard or uid=.
+	SuperOptions []string
+}
+
+// Per-mount flags. On a bind mount these only take effect through a
+// remount; everything else comes from the shared superblock.
+var perMountOptions = map[string]bool{
+	"ro":          true,
+	"rw":          true,
+	"noatime":     true,
+	"nodiratime":  true,
+	"relatime":    true,
+	"strictatime": true,
+	"nosuid":      true,
+	"nodev":       true,
+	"noexec":      true,
+}
+
+// Options mount(8) consumes itself rather than passing to the kernel.
+var nonKernelOptions = map[string]bool{
+	"defaults": true,
+	"bind":     true,
+	"remount":  true,
+}
+
+// Options the kernel leaves out of mountinfo when they are the default, so
+// their absence does not mean they were dropped.
+var defaultHiddenOptions = map[string]bool{
+	"rw":           true,
+	"relatime":     true,
+	"async":        true,
+	"barrier":      true,
+	"data=ordered": true,
+}
+
+// parseMountInfo parses the contents of /proc/self/mountinfo.
+func parseMountInfo(content string) ([]mountInfo, error) {
+	var infos []mountInfo
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(line)
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 6 || len(fields) < sep+4 {
+			return nil, fmt.Errorf("malformed mountinfo line %q", line)
+		}
+		infos = append(infos, mountInfo{
+			Root:         unescapeMountInfo(fields[3]),
+			MountPoint:   unescapeMountInfo(fields[4]),
+			MountOptions: strings.Split(fields[5], ","),
+			FSType:       fields[sep+1],
+			Source:       unescapeMountInfo(fields[sep+2]),
+			SuperOptions: strings.Split(fields[sep+3], ","),
+		})
+	}
+	return infos, nil
+}
+
+// unescapeMountInfo undoes the octal escaping of spaces, tabs, newlines and
+// backslashes in mountinfo paths.
+func unescapeMountInfo(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
+
+// getMountInfo returns the mountinfo entries of instanceName.
+func getMountInfo(ctx context.Context, instanceName string) ([]mountInfo, error) {
+	outputBytes, err := runOnInstance(ctx, "cat /proc/self/mountinfo", instanceName)
+	if err != nil {
+		return nil, err
+	}
+	return parseMountInfo(string(outputBytes))
+}
+
+// findMountInfo returns the topmost mount at mountPath, or nil if nothing is
+// mounted there.
+func findMountInfo(infos []mountInfo, mountPath string) *mountInfo {
+	mountPath = strings.TrimSuffix(mountPath, "/")
+	var found *mountInfo
+	for i := range infos {
+		if infos[i].MountPoint == mountPath {
+			found = &infos[i]
+		}
+	}
+	return found
+}
+
+// verifyMountOptions checks that every requested option is in effect on
+// mountPath according to mountinfo. A bind mount applies per-mount flags
+// through a remount but cannot change superblock options at all, so for bind
+// mounts superblock options the kernel dropped are returned as a report
+// rather than failing the check.
+func verifyMountOptions(ctx context.Context, mountPath, instanceName string, requested []string, bind bool) (string, error) {
+	log.Printf("Verifying mount options %v of %q on %q\r\n", requested, mountPath, instanceName)
+
+	infos, err := getMountInfo(ctx, instanceName)
+	if err != nil {
+		return "", err
+	}
+	info := findMountInfo(infos, mountPath)
+	if info == nil {
+		return "", fmt.Errorf("%q is not mounted on %q", mountPath, instanceName)
+	}
+	return checkMountOptions(info, requested, bind)
+}
+
+// checkMountOptions compares the options of a mountinfo entry with the
+// requested ones. See verifyMountOptions.
+func checkMountOptions(info *mountInfo, requested []string, bind bool) (string, error) {
+	effective := make(map[string]bool)
+	for _, option := range append(info.MountOptions, info.SuperOptions...) {
+		effective[normalizeMountOption(option)] = true
+	}
+
+	var dropped []string
+	for _, option := range requested {
+		option = normalizeMountOption(option)
+		if nonKernelOptions[option] || effective[option] {
+			continue
+		}
+		if defaultHiddenOptions[option] {
+			log.Printf("Mount option %q of %q is not listed, assuming the kernel hides it as a default\r\n", option, info.MountPoint)
+			continue
+		}
+		dropped = append(dropped, option)
+	}
+	if len(dropped) == 0 {
+		return "", nil
+	}
+
+	if !bind {
+		return "", fmt.Errorf(
+			"mount %q is missing options %v; effective mount options %v, superblock options %v",
+			info.MountPoint,
+			dropped,
+			info.MountOptions,
+			info.SuperOptions)
+	}
+	var perMount, super []string
+	for _, option := range dropped {
+		if perMountOptions[option] {
+			perMount = append(perMount, option)
+		} else {
+			super = append(super, option)
+		}
+	}
+	if len(perMount) > 0 {
+		return "", fmt.Errorf(
+			"bind mount %q is missing per-mount options %v after its remount; effective mount options %v",
+			info.MountPoint,
+			perMount,
+			info.MountOptions)
+	}
+	return fmt.Sprintf("bind mount dropped superblock options %v", super), nil
+}
+
+// normalizeMountOption strips the quotes the kernel adds around SELinux
+// context values.
+func normalizeMountOption(option string) string {
+	if i := strings.Index(option, "="); i >= 0 {
+		return option[:i+1] + strings.Trim(option[i+1:], `"`)
+	}
+	return option
+}
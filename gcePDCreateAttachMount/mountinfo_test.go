@@ -0,0 +1,205 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	content := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,discard
+36 22 8:16 / /mnt/disks/pd rw,noatime shared:20 master:3 - xfs /dev/sdb rw,attr2,inode64,noquota
+37 22 8:16 /sub\040dir /var/lib/my\040pods\011x ro,nosuid - xfs /dev/sdb rw,context="system_u:object_r:container_file_t:s0"
+38 22 0:45 / /mnt/no-optional rw - tmpfs back\134slash rw
+
+`
+	infos, err := parseMountInfo(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []mountInfo{
+		{MountPoint: "/", Root: "/", FSType: "ext4", Source: "/dev/sda1", MountOptions: []string{"rw", "relatime"}, SuperOptions: []string{"rw", "discard"}},
+		{MountPoint: "/mnt/disks/pd", Root: "/", FSType: "xfs", Source: "/dev/sdb", MountOptions: []string{"rw", "noatime"}, SuperOptions: []string{"rw", "attr2", "inode64", "noquota"}},
+		{MountPoint: "/var/lib/my pods\tx", Root: "/sub dir", FSType: "xfs", Source: "/dev/sdb", MountOptions: []string{"ro", "nosuid"}, SuperOptions: []string{"rw", `context="system_u:object_r:container_file_t:s0"`}},
+		{MountPoint: "/mnt/no-optional", Root: "/", FSType: "tmpfs", Source: `back\slash`, MountOptions: []string{"rw"}, SuperOptions: []string{"rw"}},
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Errorf("parseMountInfo returned\n%+v\nexpected\n%+v", infos, expected)
+	}
+
+	for _, line := range []string{
+		"36 22 8:16 / /mnt/disks/pd rw,noatime shared:20 xfs /dev/sdb rw",
+		"36 22 8:16 / /mnt/disks/pd rw - xfs /dev/sdb",
+		"36 22 8:16 / - xfs /dev/sdb rw",
+	} {
+		if _, err := parseMountInfo(line); err == nil {
+			t.Errorf("parseMountInfo accepted malformed line %q", line)
+		}
+	}
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	for escaped, expected := range map[string]string{
+		`/mnt/disks/pd`:           "/mnt/disks/pd",
+		`/mnt/a\040b`:             "/mnt/a b",
+		`/mnt/a\011b\012c`:        "/mnt/a\tb\nc",
+		`/mnt/back\134slash`:      `/mnt/back\slash`,
+		`/mnt/back\134040literal`: `/mnt/back\040literal`,
+	} {
+		if got := unescapeMountInfo(escaped); got != expected {
+			t.Errorf("unescapeMountInfo(%q) = %q, expected %q", escaped, got, expected)
+		}
+	}
+}
+
+func TestCheckMountOptions(t *testing.T) {
+	deviceMount := &mountInfo{
+		MountPoint:   "/mnt/disks/pd",
+		MountOptions: []string{"rw", "noatime"},
+		SuperOptions: []string{"rw", "discard", `context="system_u:object_r:container_file_t:s0"`},
+	}
+	bindMount := &mountInfo{
+		MountPoint:   "/mnt/disks/pd-final",
+		MountOptions: []string{"ro", "nosuid"},
+		SuperOptions: []string{"rw", "discard"},
+	}
+	for _, tc := range []struct {
+		name      string
+		info      *mountInfo
+		requested []string
+		bind      bool
+		report    string
+		fails     bool
+	}{
+		{
+			name:      "all in effect",
+			info:      deviceMount,
+			requested: []string{"defaults", "noatime", "discard", "context=system_u:object_r:container_file_t:s0"},
+		},
+		{
+			name:      "hidden defaults",
+			info:      deviceMount,
+			requested: []string{"rw", "relatime", "data=ordered"},
+		},
+		{
+			name:      "device mount missing a superblock option",
+			info:      deviceMount,
+			requested: []string{"nobarrier"},
+			fails:     true,
+		},
+		{
+			name:      "device mount missing a per-mount option",
+			info:      deviceMount,
+			requested: []string{"ro"},
+			fails:     true,
+		},
+		{
+			name:      "bind mount with its flags",
+			info:      bindMount,
+			requested: []string{"bind", "ro", "nosuid", "discard"},
+			bind:      true,
+		},
+		{
+			name:      "bind mount dropping superblock options",
+			info:      bindMount,
+			requested: []string{"ro", "nobarrier", "uid=1000"},
+			bind:      true,
+			report:    "bind mount dropped superblock options [nobarrier uid=1000]",
+		},
+		{
+			name:      "bind mount missing a per-mount option",
+			info:      bindMount,
+			requested: []string{"ro", "noexec", "nobarrier"},
+			bind:      true,
+			fails:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := checkMountOptions(tc.info, tc.requested, tc.bind)
+			if (err != nil) != tc.fails {
+				t.Errorf("checkMountOptions returned error %v, expected failure=%v", err, tc.fails)
+			}
+			if report != tc.report {
+				t.Errorf("checkMountOptions reported %q, expected %q", report, tc.report)
+			}
+		})
+	}
+}
+
+func TestIsBind(t *testing.T) {
+	bind, remount := isBind([]string{"bind", "ro", "noatime", "discard", "uid=1000", "nosuid"})
+	if !bind {
+		t.Errorf("isBind did not detect the bind option")
+	}
+	if expected := []string{"remount", "bind", "ro", "noatime", "nosuid"}; !reflect.DeepEqual(remount, expected) {
+		t.Errorf("bind remount options are %v, expected %v", remount, expected)
+	}
+	if bind, _ := isBind([]string{"ro", "discard"}); bind {
+		t.Errorf("isBind detected a bind mount without the bind option")
+	}
+}
+
+func TestBindMountAppliesOnlyPerMountOptions(t *testing.T) {
+	ctx := context.Background()
+	p := newFakeProvider()
+	useProvider(t, p)
+	pdName := generatePdName()
+	globalPath, finalPath := getDeviceGlobalMountPath(pdName), getFinalMountPath(pdName)
+	options := []string{"noatime", "discard"}
+	if err := p.CreateVolume(ctx, pdName, diskSpec{SizeGB: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AttachVolume(ctx, pdName, "node-a", false /* readOnly */); err != nil {
+		t.Fatal(err)
+	}
+	if err := mountDevice(ctx, getPDDevPath(pdName), globalPath, "node-a", testFSType, false /* readOnly */, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindMountToFinalPath(ctx, globalPath, finalPath, "node-a", true /* readOnly */, options); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := getMountInfo(ctx, "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	final := findMountInfo(infos, finalPath)
+	if final == nil || !hasOption(final.MountOptions, "ro") || !hasOption(final.MountOptions, "noatime") {
+		t.Fatalf("bind mount is %+v, expected it read-only with noatime", final)
+	}
+	// The remount applied to the bind mount only: the superblock and the
+	// device mount stay writable.
+	global := findMountInfo(infos, globalPath)
+	if global == nil || hasOption(global.MountOptions, "ro") || hasOption(global.SuperOptions, "ro") {
+		t.Errorf("device mount is %+v after the read-only bind mount, expected it writable", global)
+	}
+	if !hasOption(final.SuperOptions, "discard") {
+		t.Errorf("bind mount superblock options are %v, expected the device mount's discard", final.SuperOptions)
+	}
+	if report, err := verifyMountOptions(ctx, finalPath, "node-a", append(options, "ro"), true /* bind */); err != nil || report != "" {
+		t.Errorf("verifyMountOptions = %q, %v; expected every option in effect", report, err)
+	}
+	if _, err := WriteContentToFile(ctx, "data", globalPath+"/"+testFileName, "node-a"); err != nil {
+		t.Errorf("writing through the device mount failed: %v", err)
+	}
+	if _, err := WriteContentToFile(ctx, "data", finalPath+"/"+testFileName, "node-a"); err == nil || !strings.Contains(err.Error(), "Read-only") {
+		t.Errorf("writing through the read-only bind mount returned %v, expected a read-only error", err)
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// negativeCheck is an unsafe or invalid operation that must fail with a
+// specific class of error and leave the fixture as it was.
+type negativeCheck struct {
+	name     string
+	expected string
+	action   func(ctx context.Context) error
+	// verify, if set, checks that the failed action changed nothing.
+	verify func(ctx context.Context) error
+}
+
+type negativeResult struct {
+	Name     string
+	Expected string
+	// Actual is the class of the action's error, or "success".
+	Actual   string
+	Err      error
+	Duration time.Duration
+	// Failure says why the check failed, "" if it passed.
+	Failure string
+	// ArtifactsDir holds diagnostics collected when the check failed.
+	ArtifactsDir string
+}
+
+// negativeFixture is the state the checks run against: a PD attached
+// read-write to the first instance, mounted at its global mount path with the
+// test file written, and bind-mounted read-only to the final path.
+type negativeFixture struct {
+	pdName          string
+	host0, host1    string
+	globalMountPath string
+	finalMountPath  string
+}
+
+func runNegative(ctx context.Context) error {
+	s := defaultScenario()
+	pdName := generatePdName()
+	f := &negativeFixture{
+		pdName:          pdName,
+		host0:           s.Instances[0],
+		host1:           s.Instances[1],
+		globalMountPath: getDeviceGlobalMountPath(pdName),
+		finalMountPath:  getFinalMountPath(pdName),
+	}
+
+	log.Printf("***Setting up negative-path fixture with PD %q on %q\r\n", f.pdName, f.host0)
+	defer f.teardown(ctx)
+	instances := []string{f.host0, f.host1}
+	setupStart := time.Now()
+	if err := f.setup(ctx, s); err != nil {
+		collectArtifacts(ctx, f.pdName, 0, "setup", instances, setupStart)
+		err = fmt.Errorf("setting up negative-path fixture failed: %v", err)
+		setup := stepResult{Name: "setup", Kind: "setup", Duration: time.Since(setupStart), Err: err}
+		recordHistory("negative", s.String(), f.pdName, setupStart, []stepResult{setup}, err, false /* resumed */)
+		return err
+	}
+
+	var results []negativeResult
+	for i, check := range f.checks(s.FSType) {
+		start := time.Now()
+		result := runNegativeCheck(ctx, check)
+		if result.Failure != "" {
+			// Index 0 is the setup.
+			result.ArtifactsDir = collectArtifacts(ctx, f.pdName, i+1, check.name, instances, start)
+		}
+		results = append(results, result)
+	}
+	printNegativeReport(os.Stdout, results)
+
+	failed := 0
+	var steps []stepResult
+	for _, r := range results {
+		// A check's kind is the error class it expects.
+		step := stepResult{Name: r.Name, Kind: r.Expected, Duration: r.Duration}
+		if r.Failure != "" {
+			failed++
+			step.Err = errors.New(r.Failure)
+			if r.Err != nil {
+				step.Err = fmt.Errorf("%s: %w", r.Failure, r.Err)
+			}
+		}
+		steps = append(steps, step)
+	}
+	var err error
+	if failed > 0 {
+		err = fmt.Errorf("%d of %d negative-path checks failed", failed, len(results))
+	}
+	recordHistory("negative", s.String(), f.pdName, setupStart, steps, err, false /* resumed */)
+	return err
+}
+
+func (f *negativeFixture) setup(ctx context.Context, s scenario) error {
+	if _, err := createPDWithRetry(ctx, f.pdName, s.Disk); err != nil {
+		return err
+	}
+	if err := attachDiskWithRetry(ctx, f.pdName, f.host0, false /* readOnly */); err != nil {
+		return err
+	}
+	devPath, err := provider.DevicePath(f.pdName, f.host0)
+	if err != nil {
+		return err
+	}
+	if err := mountDevice(ctx, devPath, f.globalMountPath, f.host0, s.FSType, false /* readOnly */, nil); err != nil {
+		return err
+	}
+	if _, err := WriteContentToFile(ctx, testFileContent, path.Join(f.globalMountPath, testFileName), f.host0); err != nil {
+		return err
+	}
+	return bindMountToFinalPath(ctx, f.globalMountPath, f.finalMountPath, f.host0, true /* readOnly */, nil)
+}
+
+// teardown undoes as much of the fixture as exists.
+func (f *negativeFixture) teardown(ctx context.Context) {
+	removeBindMount(ctx, f.finalMountPath, f.host0)
+	unmountDevice(ctx, f.globalMountPath, f.host0)
+	detachDiskWithRetry(ctx, f.pdName, f.host0)
+	deletePDWithRetry(ctx, f.pdName)
+}
+
+func (f *negativeFixture) checks(fsType string) []negativeCheck {
+	checks := []negativeCheck{
+		{
+			name:     "attach RW to a second instance while attached RW",
+			expected: errClassInUse,
+			action: func(ctx context.Context) error {
+				return provider.AttachVolume(ctx, f.pdName, f.host1, false /* readOnly */)
+			},
+			verify: func(ctx context.Context) error {
+				return provider.VerifyAttachment(ctx, f.pdName, f.host1, false /* attached */, false /* readOnly */)
+			},
+		},
+		{
+			name:     "write to a read-only bind mount",
+			expected: errClassReadOnly,
+			action: func(ctx context.Context) error {
+				_, err := WriteContentToFile(ctx, "overwritten", path.Join(f.finalMountPath, testFileName), f.host0)
+				return err
+			},
+			verify: func(ctx context.Context) error {
+				if err := f.verifyContent(ctx); err != nil {
+					return err
+				}
+				// Only the bind mount is read-only: the device mount it
+				// shares a superblock with must stay writable.
+				_, err := WriteContentToFile(ctx, testFileContent, path.Join(f.globalMountPath, testFileName), f.host0)
+				return err
+			},
+		},
+		{
+			name:     "mount a non-existent device",
+			expected: errClassNotFound,
+			action: func(ctx context.Context) error {
+				missingPath := path.Join(globalMountPath, f.pdName+"-missing")
+				if _, err := runMkDir(ctx, missingPath, f.host0); err != nil {
+					return err
+				}
+				defer runRmDir(ctx, missingPath, f.host0)
+				_, err := mount(ctx, path.Join(diskByIdPath, f.pdName+"-missing"), missingPath, f.host0, fsType, nil)
+				return err
+			},
+		},
+		{
+			name:     "unmount a path that is not mounted",
+			expected: errClassNotMounted,
+			action: func(ctx context.Context) error {
+				unmountedPath := path.Join(globalMountPath, f.pdName+"-unmounted")
+				if _, err := runMkDir(ctx, unmountedPath, f.host0); err != nil {
+					return err
+				}
+				defer runRmDir(ctx, unmountedPath, f.host0)
+				_, _, err := unmount(ctx, unmountedPath, f.host0)
+				return err
+			},
+		},
+		{
+			name:     "delete an attached disk",
+			expected: errClassInUse,
+			action:   func(ctx context.Context) error { return provider.DeleteVolume(ctx, f.pdName) },
+			verify: func(ctx context.Context) error {
+				users, err := provider.VolumeUsers(ctx, f.pdName)
+				if err != nil {
+					return err
+				}
+				if len(users) != 1 || users[0] != f.host0 {
+					return fmt.Errorf("PD %q users are %v, expected only %q", f.pdName, users, f.host0)
+				}
+				return nil
+			},
+		},
+		{
+			name:     "format a mounted device",
+			expected: errClassMounted,
+			action: func(ctx context.Context) error {
+				devPath, err := provider.DevicePath(f.pdName, f.host0)
+				if err != nil {
+					return err
+				}
+				_, err = format(ctx, devPath, f.host0, fsType)
+				return err
+			},
+			verify: f.verifyContent,
+		},
+	}
+	if *crossZoneInstance != "" {
+		checks = append(checks, negativeCheck{
+			name:     "attach a zonal disk to an instance in another zone",
+			expected: errClassZoneMismatch,
+			action: func(ctx context.Context) error {
+				return provider.AttachVolume(ctx, f.pdName, *crossZoneInstance, false /* readOnly */)
+			},
+			verify: func(ctx context.Context) error {
+				return provider.VerifyAttachment(ctx, f.pdName, *crossZoneInstance, false /* attached */, false /* readOnly */)
+			},
+		})
+	}
+	return checks
+}
+
+// verifyContent checks that the test file written during setup is intact.
+func (f *negativeFixture) verifyContent(ctx context.Context) error {
+	content, err := ReadContentsFromFile(ctx, path.Join(f.globalMountPath, testFileName), f.host0)
+	if err != nil {
+		return err
+	}
+	if content != testFileContent {
+		return fmt.Errorf("read file content differs. Expected: <%s> Actual: <%s>", testFileContent, content)
+	}
+	return nil
+}
+
+func runNegativeCheck(ctx context.Context, check negativeCheck) negativeResult {
+	log.Printf("***Negative check %q, expecting a %s error\r\n", check.name, check.expected)
+	start := time.Now()
+	err := traced(ctx, check.name, spanAttrs{Step: check.name}, check.action)
+	result := negativeResult{
+		Name:     check.name,
+		Expected: check.expected,
+		Actual:   classifyError(err),
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	switch {
+	case err == nil:
+		result.Actual = "success"
+		result.Failure = "unsafe operation succeeded"
+	case result.Actual != check.expected:
+		result.Failure = "failed with an unexpected error"
+		log.Printf("Negative check %q failed with a %s error: %v\r\n", check.name, result.Actual, err)
+	}
+	if check.verify != nil {
+		if verifyErr := check.verify(ctx); verifyErr != nil {
+			result.Failure = strings.TrimPrefix(result.Failure+"; state changed: "+verifyErr.Error(), "; ")
+		}
+	}
+	if result.Failure != "" {
+		log.Printf("Negative check %q failed: %s\r\n", check.name, result.Failure)
+	}
+	return result
+}
+
+func printNegativeReport(w io.Writer, results []negativeResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tEXPECTED\tACTUAL\tDURATION\tRESULT\tARTIFACTS")
+	for _, r := range results {
+		status := "ok"
+		if r.Failure != "" {
+			status = "FAILED: " + r.Failure
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\t%s\n", r.Name, r.Expected, r.Actual, r.Duration.Round(time.Millisecond), status, r.ArtifactsDir)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// specRecordingProvider records the disk specs volumes are created with.
+type specRecordingProvider struct {
+	*fakeProvider
+	specs []diskSpec
+}
+
+func (p *specRecordingProvider) CreateVolume(ctx context.Context, name string, spec diskSpec) error {
+	p.specs = append(p.specs, spec)
+	return p.fakeProvider.CreateVolume(ctx, name, spec)
+}
+
+func TestRunNegative(t *testing.T) {
+	p := &specRecordingProvider{fakeProvider: newFakeProvider()}
+	useProvider(t, p)
+	savedSize, savedLabels := *diskSizeGB, *diskLabels
+	*diskSizeGB, *diskLabels = 37, "team=storage"
+	t.Cleanup(func() { *diskSizeGB, *diskLabels = savedSize, savedLabels })
+
+	if err := runNegative(context.Background()); err != nil {
+		t.Fatalf("runNegative failed: %v", err)
+	}
+	if len(p.specs) != 1 || p.specs[0].SizeGB != 37 || p.specs[0].Labels["team"] != "storage" {
+		t.Errorf("fixture PD was created with %+v, expected the scenario disk spec", p.specs)
+	}
+	if len(p.volumes) != 0 {
+		t.Errorf("runNegative left volumes behind: %v", p.volumes)
+	}
+}
+
+func TestNegativeReadOnlyBindLeavesDeviceMountWritable(t *testing.T) {
+	ctx := context.Background()
+	useProvider(t, newFakeProvider())
+	s := defaultScenario()
+	pdName := generatePdName()
+	f := &negativeFixture{
+		pdName:          pdName,
+		host0:           s.Instances[0],
+		host1:           s.Instances[1],
+		globalMountPath: getDeviceGlobalMountPath(pdName),
+		finalMountPath:  getFinalMountPath(pdName),
+	}
+	t.Cleanup(func() { f.teardown(ctx) })
+	if err := f.setup(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, check := range f.checks(s.FSType) {
+		if check.expected != errClassReadOnly {
+			continue
+		}
+		if result := runNegativeCheck(ctx, check); result.Failure != "" {
+			t.Errorf("check %q failed: %s (%v)", check.name, result.Failure, result.Err)
+		}
+		return
+	}
+	t.Fatalf("no read-only check in the catalog")
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	asyncOps              = flag.Bool("async", false, "Issue create/attach/detach/delete with --async and poll the operation until it is DONE.")
+	operationTimeout      = flag.Duration("operation-timeout", 10*time.Minute, "How long to poll an async operation before declaring it hung.")
+	operationPollInterval = flag.Duration("operation-poll-interval", 2*time.Second, "Interval between polls of an async operation.")
+)
+
+// Operation names look like operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7.
+var operationNameRE = regexp.MustCompile(`\boperation-[0-9a-z-]+`)
+
+// gceOperation is the subset of a compute zone or region operation the tool inspects.
+type gceOperation struct {
+	Name          string `json:"name"`
+	OperationType string `json:"operationType"`
+	Status        string `json:"status"`
+	InsertTime    string `json:"insertTime"`
+	StartTime     string `json:"startTime"`
+	EndTime       string `json:"endTime"`
+	Error         *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+	HTTPErrorStatusCode int    `json:"httpErrorStatusCode"`
+	HTTPErrorMessage    string `json:"httpErrorMessage"`
+}
+
+// err returns the errors reported by a DONE operation, or nil if it
+// succeeded.
+func (op *gceOperation) err() error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(op.Error.Errors))
+	for _, e := range op.Error.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", e.Code, e.Message))
+	}
+	return fmt.Errorf(
+		"operation %s (%s) failed with HTTP %d %s: %s",
+		op.Name,
+		op.OperationType,
+		op.HTTPErrorStatusCode,
+		op.HTTPErrorMessage,
+		strings.Join(msgs, "; "))
+}
+
+// controlPlaneLatency is the time GCE spent on the operation, from insertion
+// to completion, independent of how long the client waited.
+func (op *gceOperation) controlPlaneLatency() (time.Duration, bool) {
+	insert, err := time.Parse(time.RFC3339, op.InsertTime)
+	if err != nil {
+		return 0, false
+	}
+	end, err := time.Parse(time.RFC3339, op.EndTime)
+	if err != nil {
+		return 0, false
+	}
+	return end.Sub(insert), true
+}
+
+// executeGCloudOperation runs a mutating gcloud compute command. With -async
+// the command returns as soon as GCE accepts it and the resulting operation is
+// polled until DONE; otherwise gcloud waits for it itself. location is the
+// --zone or --region flag the operation runs in.
+func executeGCloudOperation(ctx context.Context, operation, location string, cmdArgs []string) ([]byte, error) {
+	if !*asyncOps {
+		return executeGCloudCmd(ctx, cmdArgs)
+	}
+
+	cmdArgs = append(cmdArgs, "--async", "--format=json")
+	outputBytes, cmdErr := executeGCloudCmdStdout(ctx, cmdArgs)
+	if cmdErr != nil {
+		return outputBytes, cmdErr
+	}
+
+	opName := operationNameRE.FindString(string(outputBytes))
+	if opName == "" {
+		return outputBytes, fmt.Errorf("no operation ID in output of async %s: %s", operation, string(outputBytes))
+	}
+
+	op, err := waitForOperation(ctx, opName, location)
+	if op != nil {
+		if latency, ok := op.controlPlaneLatency(); ok {
+			log.Printf("Operation %s (%s) control plane latency %v\r\n", op.Name, operation, latency)
+			metrics.observeLatency(operation+"_control_plane", latency, err)
+		}
+	}
+	return outputBytes, err
+}
+
+// waitForOperation polls opName until it is DONE or -operation-timeout
+// passes. The last observed state of the operation is returned along with any
+// error, so callers can inspect a hung or failed operation. A poll that fails,
+// or returns output that does not parse, is retried.
+func waitForOperation(ctx context.Context, opName, location string) (*gceOperation, error) {
+	log.Printf("Waiting for operation %s (%s)\r\n", opName, location)
+	defer fmt.Println("------------")
+
+	start := time.Now()
+	var op *gceOperation
+	for {
+		cmdArgs := []string{
+			"compute",
+			"--project=" + testProjectID,
+			"operations",
+			"describe",
+			opName,
+			location,
+			"--format=json"}
+		outputBytes, cmdErr := executeGCloudCmdStdout(ctx, cmdArgs)
+		polled := &gceOperation{}
+		if cmdErr != nil {
+			log.Printf("Failed to describe operation %s: %v\r\n", opName, cmdErr)
+		} else if err := json.Unmarshal(outputBytes, polled); err != nil {
+			log.Printf("Parsing operation %s failed: %v\noutput: %s\r\n", opName, err, string(outputBytes))
+		} else {
+			op = polled
+			if op.Status == "DONE" {
+				log.Printf("Operation %s is DONE after %v\r\n", opName, time.Since(start))
+				return op, op.err()
+			}
+		}
+
+		if time.Since(start) > *operationTimeout {
+			status := "unknown"
+			if op != nil {
+				status = op.Status
+			}
+			return op, fmt.Errorf("operation %s still %s after %v: %w", opName, status, *operationTimeout, errOperationTimeout)
+		}
+		time.Sleep(*operationPollInterval)
+	}
+}
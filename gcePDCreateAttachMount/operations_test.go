@@ -0,0 +1,195 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Recorded output of gcloud compute operations describe for an attach that
+// failed and one that succeeded.
+const (
+	failedAttachOperation = `{
+  "endTime": "2016-10-04T16:50:31.123-07:00",
+  "error": {
+    "errors": [
+      {
+        "code": "RESOURCE_IN_USE_BY_ANOTHER_RESOURCE",
+        "message": "The disk resource 'projects/saads-vms2/zones/us-central1-b/disks/pd-1' is already being used by 'projects/saads-vms2/zones/us-central1-b/instances/node-a'"
+      }
+    ]
+  },
+  "httpErrorMessage": "BAD REQUEST",
+  "httpErrorStatusCode": 400,
+  "id": "8217046317245113413",
+  "insertTime": "2016-10-04T16:50:14.135-07:00",
+  "kind": "compute#operation",
+  "name": "operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7",
+  "operationType": "attachDisk",
+  "progress": 100,
+  "selfLink": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/operations/operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7",
+  "startTime": "2016-10-04T16:50:14.412-07:00",
+  "status": "DONE",
+  "targetLink": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/instances/node-b",
+  "user": "saadali@google.com",
+  "zone": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b"
+}`
+	doneAttachOperation = `{
+  "endTime": "2016-10-04T16:52:03.870-07:00",
+  "id": "4467393823516937062",
+  "insertTime": "2016-10-04T16:51:44.505-07:00",
+  "kind": "compute#operation",
+  "name": "operation-1475625104505-53e1e6373f5b0-5b4b5f2c-b8d9e8a1",
+  "operationType": "attachDisk",
+  "progress": 100,
+  "startTime": "2016-10-04T16:51:44.829-07:00",
+  "status": "DONE",
+  "targetLink": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/instances/node-a",
+  "zone": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b"
+}`
+)
+
+func parseOperation(t *testing.T, recorded string) *gceOperation {
+	t.Helper()
+	op := &gceOperation{}
+	if err := json.Unmarshal([]byte(recorded), op); err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func TestGCEOperationErr(t *testing.T) {
+	err := parseOperation(t, failedAttachOperation).err()
+	expected := "operation operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7 (attachDisk) failed with HTTP 400 BAD REQUEST: RESOURCE_IN_USE_BY_ANOTHER_RESOURCE: The disk resource 'projects/saads-vms2/zones/us-central1-b/disks/pd-1' is already being used by 'projects/saads-vms2/zones/us-central1-b/instances/node-a'"
+	if err == nil || err.Error() != expected {
+		t.Errorf("err() = %v, expected %s", err, expected)
+	}
+	if class := classifyError(err); class != errClassInUse {
+		t.Errorf("failed operation classified as %q, expected %q", class, errClassInUse)
+	}
+
+	if err := parseOperation(t, doneAttachOperation).err(); err != nil {
+		t.Errorf("err() of a successful operation = %v", err)
+	}
+	if err := (&gceOperation{Error: &struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}{}}).err(); err != nil {
+		t.Errorf("err() of an operation with an empty error list = %v", err)
+	}
+}
+
+func TestGCEOperationControlPlaneLatency(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		op       *gceOperation
+		latency  time.Duration
+		reported bool
+	}{
+		{name: "failed", op: parseOperation(t, failedAttachOperation), latency: 16988 * time.Millisecond, reported: true},
+		{name: "done", op: parseOperation(t, doneAttachOperation), latency: 19365 * time.Millisecond, reported: true},
+		{name: "running", op: &gceOperation{InsertTime: "2016-10-04T16:51:44.505-07:00", Status: "RUNNING"}},
+		{name: "malformed", op: &gceOperation{InsertTime: "yesterday", EndTime: "2016-10-04T16:51:44.505-07:00"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			latency, reported := tc.op.controlPlaneLatency()
+			if latency != tc.latency || reported != tc.reported {
+				t.Errorf("controlPlaneLatency() = %v, %v; expected %v, %v", latency, reported, tc.latency, tc.reported)
+			}
+		})
+	}
+}
+
+func TestOperationNameRE(t *testing.T) {
+	for _, tc := range []struct {
+		output   string
+		expected string
+	}{
+		{
+			// gcloud compute instances attach-disk --async --format=json
+			output: `[
+  {
+    "insertTime": "2016-10-04T16:51:44.505-07:00",
+    "name": "operation-1475625104505-53e1e6373f5b0-5b4b5f2c-b8d9e8a1",
+    "operationType": "attachDisk",
+    "status": "PENDING"
+  }
+]`,
+			expected: "operation-1475625104505-53e1e6373f5b0-5b4b5f2c-b8d9e8a1",
+		},
+		{
+			output:   "Detach disk in progress for [https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/operations/operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7].",
+			expected: "operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7",
+		},
+		{output: "Created [https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/disks/pd-1]."},
+		{output: "[]"},
+	} {
+		if actual := operationNameRE.FindString(tc.output); actual != tc.expected {
+			t.Errorf("operation name in %q is %q, expected %q", tc.output, actual, tc.expected)
+		}
+	}
+}
+
+// fakeOperationPolls answers gcloud with a warning on stderr, output that
+// does not parse on the first describe, the operation RUNNING on the second
+// and the recorded operation after that.
+const fakeOperationPolls = `echo "WARNING: Some requests generated warnings:" >&2
+echo " - The resource is deprecated." >&2
+case "$*" in
+*--async*)
+	echo '[{"name": "operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7", "status": "PENDING"}]'
+	;;
+*"operations describe"*)
+	echo poll >> "$FAKE_GCLOUD_STATE/polls"
+	case $(wc -l < "$FAKE_GCLOUD_STATE/polls") in
+	1) echo '{"name": "operation-' ;;
+	2) echo '{"name": "operation-1475625014135-53e1e5e10a2c3-4e0a1f5d-0c91a1d7", "status": "RUNNING"}' ;;
+	*) cat "$FAKE_GCLOUD_STATE/operation.json" ;;
+	esac
+	;;
+esac
+`
+
+func TestExecuteGCloudOperationPollsUntilDone(t *testing.T) {
+	state := useFakeGCloud(t, fakeOperationPolls)
+	if err := os.WriteFile(filepath.Join(state, "operation.json"), []byte(failedAttachOperation), 0644); err != nil {
+		t.Fatal(err)
+	}
+	savedAsync, savedInterval := *asyncOps, *operationPollInterval
+	*asyncOps, *operationPollInterval = true, time.Millisecond
+	t.Cleanup(func() { *asyncOps, *operationPollInterval = savedAsync, savedInterval })
+
+	_, err := executeGCloudOperation(context.Background(), "attach", "--zone=us-central1-b", []string{"compute", "instances", "attach-disk"})
+	if err == nil || !strings.Contains(err.Error(), "RESOURCE_IN_USE_BY_ANOTHER_RESOURCE") {
+		t.Errorf("executeGCloudOperation returned %v, expected the error of the recorded operation", err)
+	}
+	polls, err := os.ReadFile(filepath.Join(state, "polls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(polls), "\n"); n != 3 {
+		t.Errorf("operation was polled %d times, expected 3", n)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Binaries the lifecycle runs on every instance.
+var requiredBinaries = []string{"mkfs.ext4", "mkfs.xfs", "fsck", "lsblk", "blkid", "mount", "umount"}
+
+// Filesystems the lifecycle formats disks with. Each must be built in or
+// loadable as a kernel module on every instance.
+var requiredFilesystems = []string{"ext4", "xfs"}
+
+type preflightCheck struct {
+	name   string
+	err    error
+	detail string
+}
+
+type preflightChecklist struct {
+	checks []preflightCheck
+}
+
+func (c *preflightChecklist) add(name, detail string, err error) {
+	c.checks = append(c.checks, preflightCheck{name: name, detail: detail, err: err})
+}
+
+func (c *preflightChecklist) failed() int {
+	n := 0
+	for _, check := range c.checks {
+		if check.err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *preflightChecklist) print(w io.Writer) {
+	for _, check := range c.checks {
+		if check.err != nil {
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", check.name, check.err)
+			continue
+		}
+		fmt.Fprintf(w, "[PASS] %s: %s\n", check.name, check.detail)
+	}
+}
+
+// runPreflight validates the environment a lifecycle run depends on and
+// prints a checklist. It does not create any disks.
+func runPreflight(ctx context.Context) error {
+	checklist := &preflightChecklist{}
+
+	instances := configuredInstances()
+	provider.Preflight(ctx, checklist, instances)
+	for _, instanceName := range instances {
+		checkInstance(ctx, checklist, instanceName)
+	}
+
+	checklist.print(os.Stdout)
+	if failed := checklist.failed(); failed > 0 {
+		return fmt.Errorf("%d of %d preflight checks failed", failed, len(checklist.checks))
+	}
+	return nil
+}
+
+// configuredInstances returns every instance the configured subcommands may
+// touch, without duplicates.
+func configuredInstances() []string {
+	seen := make(map[string]bool)
+	var instances []string
+	pair := defaultScenario().Instances
+	candidates := append(pair[:], strings.Split(*stressInstances, ",")...)
+	candidates = append(candidates, *attachLimitInstance)
+	for _, instanceName := range candidates {
+		if instanceName == "" || seen[instanceName] {
+			continue
+		}
+		seen[instanceName] = true
+		instances = append(instances, instanceName)
+	}
+	return instances
+}
+
+// checkGCEInstance checks that instanceName exists and is running in the
+// configured zone.
+func checkGCEInstance(ctx context.Context, checklist *preflightChecklist, instanceName string) {
+	prefix := "instance " + instanceName
+	instance, err := describeInstance(ctx, instanceName)
+	if err != nil {
+		checklist.add(prefix+" exists", "", err)
+		return
+	}
+	checklist.add(prefix+" exists", "found", nil)
+
+	err = nil
+	if instance.Status != "RUNNING" {
+		err = fmt.Errorf("status is %s", instance.Status)
+	}
+	checklist.add(prefix+" running", instance.Status, err)
+
+	err = nil
+	wantZone := instanceZone(instanceName)
+	if zone := path.Base(instance.Zone); zone != wantZone {
+		err = fmt.Errorf("instance is in zone %q, the tool is configured for %q", zone, wantZone)
+	}
+	checklist.add(prefix+" zone", wantZone, err)
+}
+
+// checkInstance checks that the lifecycle can run its commands on
+// instanceName.
+func checkInstance(ctx context.Context, checklist *preflightChecklist, instanceName string) {
+	prefix := "instance " + instanceName
+	lines, err := probeInstance(ctx, "id -u", instanceName)
+	if err == nil && (len(lines) != 1 || lines[0] != "0") {
+		err = fmt.Errorf("remote commands run as uid %s, not root", strings.Join(lines, " "))
+	}
+	checklist.add(prefix+" root access", "ssh as root works", err)
+
+	script := fmt.Sprintf(
+		"for b in %s; do command -v $b >/dev/null || echo $b; done",
+		strings.Join(requiredBinaries, " "))
+	missing, err := probeInstance(ctx, script, instanceName)
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("missing binaries: %s", strings.Join(missing, ", "))
+	}
+	checklist.add(prefix+" binaries", strings.Join(requiredBinaries, ", "), err)
+
+	script = fmt.Sprintf(
+		"for fs in %s; do grep -qw $fs /proc/filesystems || modinfo $fs >/dev/null 2>&1 || echo $fs; done",
+		strings.Join(requiredFilesystems, " "))
+	missing, err = probeInstance(ctx, script, instanceName)
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("filesystems neither built in nor available as modules: %s", strings.Join(missing, ", "))
+	}
+	checklist.add(prefix+" kernel modules", strings.Join(requiredFilesystems, ", "), err)
+}
+
+// probeMarker starts every line a probe prints to stdout. The output of a
+// remote command also holds what ssh itself prints, such as "Warning:
+// Permanently added ... to the list of known hosts", so probes only parse the
+// marked lines.
+const probeMarker = "preflight-probe: "
+
+// probeInstance runs script on instanceName and returns the non-empty lines
+// it printed to stdout.
+func probeInstance(ctx context.Context, script, instanceName string) ([]string, error) {
+	command := fmt.Sprintf("(%s) | sed 's/^/%s/'", script, probeMarker)
+	output, err := runOnInstance(ctx, command, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.HasPrefix(line, probeMarker) {
+			continue
+		}
+		if line = strings.TrimSpace(strings.TrimPrefix(line, probeMarker)); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+type gceQuota struct {
+	Metric string  `json:"metric"`
+	Limit  float64 `json:"limit"`
+	Usage  float64 `json:"usage"`
+}
+
+// checkDiskQuota checks that the region has the disk quota for the largest
+// set of disks any subcommand creates.
+func checkDiskQuota(ctx context.Context, checklist *preflightChecklist, spec diskSpec) {
+	region := zoneRegion(*gceZone)
+	cmdArgs := []string{
+		"compute",
+		"--project=" + testProjectID,
+		"regions",
+		"describe",
+		region,
+		"--format=json"}
+	outputBytes, err := executeGCloudCmdStdout(ctx, cmdArgs)
+	if err != nil {
+		checklist.add("disk quota", "", err)
+		return
+	}
+
+	var description struct {
+		Quotas []gceQuota `json:"quotas"`
+	}
+	if err := json.Unmarshal(outputBytes, &description); err != nil {
+		checklist.add("disk quota", "", fmt.Errorf("parsing region %q failed: %v", region, err))
+		return
+	}
+	detail, err := checkQuotaHeadroom(description.Quotas, region, spec)
+	checklist.add("disk quota", detail, err)
+}
+
+// diskQuotaNeed is the disk space a subcommand has in use at most.
+type diskQuotaNeed struct {
+	subcommand string
+	disks      int
+	sizeGB     int
+}
+
+// diskQuotaNeeds returns what each subcommand creating disks of spec needs.
+func diskQuotaNeeds(spec diskSpec) []diskQuotaNeed {
+	return []diskQuotaNeed{
+		{subcommand: "run", disks: 1, sizeGB: spec.SizeGB},
+		{subcommand: "model", disks: *modelDisks, sizeGB: *modelDisks * spec.SizeGB},
+		{subcommand: "stress", disks: *stressDisks, sizeGB: *stressDisks * spec.SizeGB},
+		{subcommand: "attach-limit", disks: *attachLimitMax, sizeGB: *attachLimitMax * spec.SizeGB},
+	}
+}
+
+// checkQuotaHeadroom checks quotas leave room for the subcommand needing the
+// most disk space.
+func checkQuotaHeadroom(quotas []gceQuota, region string, spec diskSpec) (string, error) {
+	metric := "DISKS_TOTAL_GB"
+	if spec.Type == "pd-ssd" || spec.Type == "pd-balanced" {
+		metric = "SSD_TOTAL_GB"
+	}
+	var largest diskQuotaNeed
+	for _, need := range diskQuotaNeeds(spec) {
+		if need.sizeGB > largest.sizeGB {
+			largest = need
+		}
+	}
+	for _, quota := range quotas {
+		if quota.Metric != metric {
+			continue
+		}
+		headroom := quota.Limit - quota.Usage
+		detail := fmt.Sprintf("%s headroom %.0fGB in %s", metric, headroom, region)
+		if headroom < float64(largest.sizeGB) {
+			return "", fmt.Errorf("%s, %s needs %dGB for %d disks of %dGB", detail, largest.subcommand, largest.sizeGB, largest.disks, spec.SizeGB)
+		}
+		return fmt.Sprintf("%s, %s needs %dGB", detail, largest.subcommand, largest.sizeGB), nil
+	}
+	return "", fmt.Errorf("quota %s not reported for region %q", metric, region)
+}
+
+// gcloudValue runs a gcloud command that prints a single value. Notices
+// gcloud writes to stderr, such as "Your active configuration is: [default]",
+// are not part of the value.
+func gcloudValue(ctx context.Context, cmdArgs []string) (string, error) {
+	outputBytes, err := executeGCloudCmdStdout(ctx, cmdArgs)
+	return strings.TrimSpace(string(outputBytes)), err
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckInstance(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		uid        string
+		missing    []string
+		sshWarning bool
+		// failures maps the failing checks to their expected error.
+		failures map[string]string
+	}{
+		{name: "ready"},
+		{name: "ssh warning", sshWarning: true},
+		{
+			name:     "not root",
+			uid:      "1000",
+			failures: map[string]string{"root access": "remote commands run as uid 1000, not root"},
+		},
+		{
+			name:       "not root with ssh warning",
+			uid:        "1000",
+			sshWarning: true,
+			failures:   map[string]string{"root access": "remote commands run as uid 1000, not root"},
+		},
+		{
+			name:       "missing binaries with ssh warning",
+			missing:    []string{"mkfs.xfs", "blkid"},
+			sshWarning: true,
+			failures:   map[string]string{"binaries": "missing binaries: mkfs.xfs, blkid"},
+		},
+		{
+			name:     "missing file system",
+			missing:  []string{"xfs"},
+			failures: map[string]string{"kernel modules": "filesystems neither built in nor available as modules: xfs"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newFakeProvider()
+			useProvider(t, p)
+			inst := p.instance("node-a")
+			inst.uid = tc.uid
+			inst.sshWarning = tc.sshWarning
+			inst.missing = make(map[string]bool)
+			for _, name := range tc.missing {
+				inst.missing[name] = true
+			}
+
+			checklist := &preflightChecklist{}
+			checkInstance(context.Background(), checklist, "node-a")
+			if len(checklist.checks) != 3 {
+				t.Fatalf("checkInstance added %d checks, expected 3", len(checklist.checks))
+			}
+			for _, check := range checklist.checks {
+				expected, fails := tc.failures[strings.TrimPrefix(check.name, "instance node-a ")]
+				switch {
+				case !fails && check.err != nil:
+					t.Errorf("check %q failed: %v", check.name, check.err)
+				case fails && (check.err == nil || check.err.Error() != expected):
+					t.Errorf("check %q returned %v, expected %q", check.name, check.err, expected)
+				}
+			}
+			if failed := checklist.failed(); failed != len(tc.failures) {
+				t.Errorf("%d checks failed, expected %d", failed, len(tc.failures))
+			}
+		})
+	}
+}
+
+func TestCheckQuotaHeadroom(t *testing.T) {
+	savedModel, savedStress, savedLimit := *modelDisks, *stressDisks, *attachLimitMax
+	*modelDisks, *stressDisks, *attachLimitMax = 2, 4, 16
+	t.Cleanup(func() { *modelDisks, *stressDisks, *attachLimitMax = savedModel, savedStress, savedLimit })
+
+	quotas := []gceQuota{
+		{Metric: "CPUS", Limit: 24, Usage: 8},
+		{Metric: "DISKS_TOTAL_GB", Limit: 4096, Usage: 3996},
+		{Metric: "SSD_TOTAL_GB", Limit: 2048, Usage: 48},
+	}
+	for _, tc := range []struct {
+		name   string
+		spec   diskSpec
+		detail string
+		fails  string
+	}{
+		{
+			name:   "room for attach-limit",
+			spec:   diskSpec{Type: "pd-ssd", SizeGB: 10},
+			detail: "SSD_TOTAL_GB headroom 2000GB in us-central1, attach-limit needs 160GB",
+		},
+		{
+			name: "room for one disk but not for attach-limit",
+			spec: diskSpec{Type: "pd-standard", SizeGB: 10},
+			// A plain run fits, but attach-limit would run out partway.
+			fails: "DISKS_TOTAL_GB headroom 100GB in us-central1, attach-limit needs 160GB for 16 disks of 10GB",
+		},
+		{
+			name:  "balanced disks count against SSD quota",
+			spec:  diskSpec{Type: "pd-balanced", SizeGB: 200},
+			fails: "SSD_TOTAL_GB headroom 2000GB in us-central1, attach-limit needs 3200GB for 16 disks of 200GB",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			detail, err := checkQuotaHeadroom(quotas, "us-central1", tc.spec)
+			switch {
+			case tc.fails == "" && (err != nil || detail != tc.detail):
+				t.Errorf("checkQuotaHeadroom() = %q, %v; expected %q", detail, err, tc.detail)
+			case tc.fails != "" && (err == nil || err.Error() != tc.fails):
+				t.Errorf("checkQuotaHeadroom() returned %v, expected %q", err, tc.fails)
+			}
+		})
+	}
+
+	*attachLimitMax = 1
+	if detail, err := checkQuotaHeadroom(quotas, "us-central1", diskSpec{SizeGB: 10}); err != nil || !strings.Contains(detail, "stress needs 40GB") {
+		t.Errorf("checkQuotaHeadroom() = %q, %v; expected stress to need the most", detail, err)
+	}
+	if _, err := checkQuotaHeadroom(quotas[:1], "us-central1", diskSpec{SizeGB: 10}); err == nil {
+		t.Errorf("checkQuotaHeadroom() passed without the disk quota")
+	}
+}
+
+// fakeGCloudNotices answers gcloud like a real gcloud with a configuration
+// that needs attention: the values are on stdout and notices on stderr.
+const fakeGCloudNotices = `echo "Your active configuration is: [default]" >&2
+echo "WARNING: Property validation for compute/region was skipped." >&2
+case "$*" in
+"config get-value project") echo saads-vms2 ;;
+*"regions describe"*) echo '{"name": "us-central1", "quotas": [{"limit": 4096.0, "metric": "DISKS_TOTAL_GB", "usage": 96.0}]}' ;;
+*) exit 1 ;;
+esac
+`
+
+func TestGCloudOutputIgnoresNotices(t *testing.T) {
+	ctx := context.Background()
+	useFakeGCloud(t, fakeGCloudNotices)
+	if project, err := gcloudValue(ctx, []string{"config", "get-value", "project"}); err != nil || project != testProjectID {
+		t.Errorf("gcloudValue() = %q, %v; expected %q", project, err, testProjectID)
+	}
+
+	checklist := &preflightChecklist{}
+	checkDiskQuota(ctx, checklist, diskSpec{SizeGB: 1})
+	if len(checklist.checks) != 1 || checklist.checks[0].err != nil {
+		t.Errorf("checkDiskQuota added %+v, expected a passing quota check", checklist.checks)
+	}
+}
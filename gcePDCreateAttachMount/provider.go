@@ -0,0 +1,85 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+var providerName = flag.String("provider", "gce", "Volume provider the lifecycle runs against: gce, or ebs for EBS semantics against a local fake API.")
+
+// VolumeProvider is the cloud-specific part of the lifecycle: managing
+// volumes, attaching them to instances, locating their devices and running
+// commands on instances. Everything from formatting onwards is the same for
+// every provider.
+//
+// Volumes are identified by the name the tool gives them, and instances by
+// the name they are reached at. Providers that use other identifiers map
+// them internally.
+type VolumeProvider interface {
+	Name() string
+	CreateVolume(ctx context.Context, name string, spec diskSpec) error
+	DeleteVolume(ctx context.Context, name string) error
+	// AttachVolume returns once the cloud reports the volume attached.
+	AttachVolume(ctx context.Context, name, instanceName string, readOnly bool) error
+	// DetachVolume returns once the cloud reports the volume detached.
+	DetachVolume(ctx context.Context, name, instanceName string) error
+	// VerifyAttachment checks that the cloud agrees the volume is attached
+	// to instanceName in the given mode, or not attached at all.
+	VerifyAttachment(ctx context.Context, name, instanceName string, attached, readOnly bool) error
+	// VolumeUsers returns the instances the volume is attached to. It fails
+	// if the volume does not exist.
+	VolumeUsers(ctx context.Context, name string) ([]string, error)
+	// DevicePath returns the block device the volume shows up as on
+	// instanceName.
+	DevicePath(name, instanceName string) (string, error)
+	// VolumeLabels returns the labels, or tags, of the volume.
+	VolumeLabels(ctx context.Context, name string) (map[string]string, error)
+	// CSIVolumeID returns the ID the provider's CSI driver knows the volume
+	// by.
+	CSIVolumeID(name string) (string, error)
+	RunOnInstance(ctx context.Context, command, instanceName string) ([]byte, error)
+	CopyToInstance(ctx context.Context, localPath, remotePath, instanceName string) error
+	// ValidateScenario rejects scenarios the provider cannot run, before
+	// any volume is created.
+	ValidateScenario(s scenario) error
+	// Preflight adds the provider's checks of its own environment and of
+	// the cloud side of instances to checklist.
+	Preflight(ctx context.Context, checklist *preflightChecklist, instances []string)
+}
+
+// provider is the VolumeProvider selected with -provider.
+var provider VolumeProvider = gceProvider{}
+
+func newVolumeProvider(name string) (VolumeProvider, error) {
+	switch name {
+	case "gce":
+		return gceProvider{}, nil
+	case "ebs":
+		return newEBSProvider()
+	}
+	return nil, fmt.Errorf("unknown volume provider %q, must be gce or ebs", name)
+}
+
+// runOnInstance runs command on instanceName through the selected provider.
+func runOnInstance(ctx context.Context, command, instanceName string) (output []byte, err error) {
+	ctx, sp := startSpan(ctx, "remote", spanAttrs{Instance: instanceName, Command: command})
+	defer func() { sp.end(err) }()
+	return provider.RunOnInstance(ctx, command, instanceName)
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	reconcileRetries = flag.Int("reconcile-retries", 3, "Attempts the reconciler makes at each operation before giving up on it for the pass.")
+	reconcileBackoff = flag.Duration("reconcile-backoff", 5*time.Second, "Delay between attempts at a failed reconciler operation.")
+	reconcilePasses  = flag.Int("reconcile-passes", 3, "Observe/plan/execute passes the reconciler makes before reporting that state did not converge.")
+	reconcileDryRun  = flag.Bool("reconcile-dry-run", false, "Only print the reconciler's plan for the first pass.")
+)
+
+func loadDesiredState(statePath string) (*desiredState, error) {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, err
+	}
+	var desired desiredState
+	if err := json.Unmarshal(data, &desired); err != nil {
+		return nil, fmt.Errorf("parsing desired state %q failed: %v", statePath, err)
+	}
+	return &desired, desired.validate()
+}
+
+// observeState reads the actual state of the desired volumes from the cloud
+// and from the mountinfo of every instance they are or should be on.
+func observeState(ctx context.Context, desired *desiredState) (actualState, error) {
+	actual := make(actualState)
+	mountInfos := make(map[string][]mountInfo)
+	for _, v := range desired.Volumes {
+		instances := make(map[string]*actualAttachment)
+		actual[v.Name] = instances
+		for _, a := range v.Attachments {
+			instances[a.Instance] = &actualAttachment{}
+		}
+
+		users, err := provider.VolumeUsers(ctx, v.Name)
+		if err != nil {
+			return nil, fmt.Errorf("observing volume %q failed: %v", v.Name, err)
+		}
+		for _, user := range users {
+			readOnly, err := observeAttachmentMode(ctx, &v, user)
+			if err != nil {
+				return nil, err
+			}
+			instances[user] = &actualAttachment{Attached: true, ReadOnly: readOnly}
+		}
+
+		for instanceName, a := range instances {
+			infos, ok := mountInfos[instanceName]
+			if !ok {
+				if infos, err = getMountInfo(ctx, instanceName); err != nil {
+					return nil, fmt.Errorf("observing mounts on %q failed: %v", instanceName, err)
+				}
+				mountInfos[instanceName] = infos
+			}
+			if info := findMountInfo(infos, getDeviceGlobalMountPath(v.Name)); info != nil {
+				a.Mounted = true
+				a.MountedReadOnly = hasOption(info.MountOptions, "ro")
+			}
+			a.Bound = findMountInfo(infos, getFinalMountPath(v.Name)) != nil
+		}
+	}
+	return actual, nil
+}
+
+// observeAttachmentMode returns whether v is attached read-only to
+// instanceName. The desired mode is checked first, so providers that do not
+// distinguish modes report the attachment as wanted.
+func observeAttachmentMode(ctx context.Context, v *desiredVolume, instanceName string) (bool, error) {
+	wantReadOnly := false
+	if want := v.attachment(instanceName); want != nil {
+		wantReadOnly = want.ReadOnly
+	}
+	if provider.VerifyAttachment(ctx, v.Name, instanceName, true /* attached */, wantReadOnly) == nil {
+		return wantReadOnly, nil
+	}
+	if err := provider.VerifyAttachment(ctx, v.Name, instanceName, true /* attached */, !wantReadOnly); err != nil {
+		return false, fmt.Errorf("observing attachment mode of volume %q on %q failed: %v", v.Name, instanceName, err)
+	}
+	return !wantReadOnly, nil
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// runReconcileOp executes op against the provider and the instance.
+func runReconcileOp(ctx context.Context, op reconcileOp) error {
+	name, instanceName := op.Volume, op.Instance
+	globalPath, finalPath := getDeviceGlobalMountPath(name), getFinalMountPath(name)
+	switch op.Kind {
+	case "attach":
+		if err := provider.AttachVolume(ctx, name, instanceName, op.ReadOnly); err != nil {
+			return err
+		}
+		return provider.VerifyAttachment(ctx, name, instanceName, true /* attached */, op.ReadOnly)
+	case "detach":
+		if err := provider.DetachVolume(ctx, name, instanceName); err != nil {
+			return err
+		}
+		return provider.VerifyAttachment(ctx, name, instanceName, false /* attached */, false /* readOnly */)
+	case "mount":
+		devPath, err := provider.DevicePath(name, instanceName)
+		if err != nil {
+			return err
+		}
+		return mountDevice(ctx, devPath, globalPath, instanceName, op.FSType, op.ReadOnly, nil)
+	case "unmount":
+		_, err := unmountDevice(ctx, globalPath, instanceName)
+		return err
+	case "bind":
+		return bindMountToFinalPath(ctx, globalPath, finalPath, instanceName, op.ReadOnly, nil)
+	case "unbind":
+		_, err := removeBindMount(ctx, finalPath, instanceName)
+		return err
+	}
+	return fmt.Errorf("unknown reconcile operation %v", op)
+}
+
+// reconcileOpResult records the execution of one operation.
+type reconcileOpResult struct {
+	Pass     int
+	Op       reconcileOp
+	Attempts int
+	Duration time.Duration
+	Err      error
+	// Skipped is set when an earlier operation on the same volume and
+	// instance failed, so this one could not succeed.
+	Skipped bool
+}
+
+// recordReconcileHistory records the operations a reconcile run executed as
+// its steps. Skipped operations never ran and are left out.
+func recordReconcileHistory(statePath string, start time.Time, results []reconcileOpResult, runErr error) {
+	var steps []stepResult
+	for _, r := range results {
+		if r.Skipped {
+			continue
+		}
+		steps = append(steps, stepResult{Name: r.Op.String(), Kind: r.Op.Kind, Duration: r.Duration, Err: r.Err})
+	}
+	recordHistory("reconcile", statePath, "", start, steps, runErr, false /* resumed */)
+}
+
+// executeReconcile runs plan, retrying each operation. Once an operation on a
+// volume and instance fails for good, the rest of the pass skips that pair;
+// the next pass observes what actually happened and plans again.
+func executeReconcile(ctx context.Context, pass int, plan []reconcileOp) []reconcileOpResult {
+	var results []reconcileOpResult
+	failed := make(map[string]bool)
+	for _, op := range plan {
+		key := op.Volume + "/" + op.Instance
+		result := reconcileOpResult{Pass: pass, Op: op}
+		if failed[key] {
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+
+		log.Printf("***Reconcile %v\r\n", op)
+		start := time.Now()
+		for result.Attempts = 1; ; result.Attempts++ {
+			result.Err = runReconcileOp(ctx, op)
+			if result.Err == nil || result.Attempts >= *reconcileRetries {
+				break
+			}
+			log.Printf("Reconcile %v failed (attempt %d). Sleeping %v (%v)\r\n", op, result.Attempts, *reconcileBackoff, result.Err)
+			time.Sleep(*reconcileBackoff)
+		}
+		result.Duration = time.Since(start)
+		if result.Err != nil {
+			log.Printf("Reconcile %v failed after %d attempts: %v\r\n", op, result.Attempts, result.Err)
+			failed[key] = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func runReconcile(ctx context.Context, statePath string) error {
+	if statePath == "" {
+		return fmt.Errorf("usage: reconcile <desired-state.json>")
+	}
+	desired, err := loadDesiredState(statePath)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var results []reconcileOpResult
+	// The pass after the last one only observes and plans, so the last pass
+	// can still converge.
+	for pass := 1; ; pass++ {
+		actual, err := observeState(ctx, desired)
+		if err != nil {
+			return err
+		}
+		plan := planReconcile(desired, actual)
+		if len(plan) == 0 {
+			printReconcileReport(os.Stdout, results)
+			fmt.Printf("Converged after %d passes\n", pass-1)
+			recordReconcileHistory(statePath, start, results, nil)
+			return nil
+		}
+		if pass > *reconcilePasses {
+			printReconcileReport(os.Stdout, results)
+			err := fmt.Errorf("state did not converge after %d passes, still planned: %v", *reconcilePasses, plan)
+			recordReconcileHistory(statePath, start, results, err)
+			return err
+		}
+		log.Printf("Reconcile pass %d plan: %v\r\n", pass, plan)
+		if *reconcileDryRun {
+			for _, op := range plan {
+				fmt.Println(op)
+			}
+			return nil
+		}
+		results = append(results, executeReconcile(ctx, pass, plan)...)
+	}
+}
+
+func printReconcileReport(w io.Writer, results []reconcileOpResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PASS\tOPERATION\tATTEMPTS\tDURATION\tRESULT")
+	for _, r := range results {
+		status := "ok"
+		switch {
+		case r.Skipped:
+			status = "skipped"
+		case r.Err != nil:
+			status = "FAILED: " + strings.SplitN(r.Err.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%d\t%v\t%s\n", r.Pass, r.Op, r.Attempts, r.Duration.Round(time.Millisecond), status)
+	}
+	tw.Flush()
+}
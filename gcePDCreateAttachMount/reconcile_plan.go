@@ -0,0 +1,184 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The planner is kept free of I/O so it can be tested on its own: it turns a
+// desired state and an observed actual state into a list of operations, and
+// reconcile.go observes and executes.
+//
+// It belongs in a reconciler package of its own, but the tool has no go.mod
+// and is built as a list of files (go build *.go), which cannot import a
+// package from a subdirectory. Until it has a module, the planner stays in
+// package main and imports nothing but fmt and sort, so it can move by
+// exporting desiredState, actualState, planReconcile and the two helpers it
+// borrows, modeString and testFSType.
+
+// desiredState declares where volumes should be attached and mounted, the
+// way the kubelet volume manager derives it from the pods on a node. Volumes
+// not listed are left alone; a listed volume without attachments is detached
+// everywhere.
+type desiredState struct {
+	Volumes []desiredVolume `json:"volumes"`
+}
+
+type desiredVolume struct {
+	Name string `json:"name"`
+	// FSType defaults to ext4.
+	FSType      string              `json:"fsType"`
+	Attachments []desiredAttachment `json:"attachments"`
+}
+
+type desiredAttachment struct {
+	Instance string `json:"instance"`
+	ReadOnly bool   `json:"readOnly"`
+	// Mount mounts the device at its global mount path.
+	Mount bool `json:"mount"`
+	// Bind bind-mounts the global mount path to the final path. It implies
+	// Mount.
+	Bind bool `json:"bind"`
+}
+
+func (d *desiredState) validate() error {
+	seen := make(map[string]bool)
+	for i := range d.Volumes {
+		v := &d.Volumes[i]
+		if v.Name == "" {
+			return fmt.Errorf("desired volume %d has no name", i)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("volume %q is declared more than once", v.Name)
+		}
+		seen[v.Name] = true
+		if v.FSType == "" {
+			v.FSType = testFSType
+		}
+		instances := make(map[string]bool)
+		for j := range v.Attachments {
+			a := &v.Attachments[j]
+			if a.Instance == "" {
+				return fmt.Errorf("attachment %d of volume %q has no instance", j, v.Name)
+			}
+			if instances[a.Instance] {
+				return fmt.Errorf("volume %q is attached to %q more than once", v.Name, a.Instance)
+			}
+			instances[a.Instance] = true
+			if a.Bind {
+				a.Mount = true
+			}
+			if !a.ReadOnly && len(v.Attachments) > 1 {
+				return fmt.Errorf("volume %q is attached read-write to %q and to other instances, which cannot converge", v.Name, a.Instance)
+			}
+		}
+	}
+	return nil
+}
+
+func (v *desiredVolume) attachment(instanceName string) *desiredAttachment {
+	for i := range v.Attachments {
+		if v.Attachments[i].Instance == instanceName {
+			return &v.Attachments[i]
+		}
+	}
+	return nil
+}
+
+// actualAttachment is the observed state of one volume on one instance.
+type actualAttachment struct {
+	Attached        bool
+	ReadOnly        bool
+	Mounted         bool
+	MountedReadOnly bool
+	Bound           bool
+}
+
+// actualState maps volume and then instance name to the observed state.
+type actualState map[string]map[string]*actualAttachment
+
+// reconcileOp is a single operation of a reconciler plan.
+type reconcileOp struct {
+	Kind     string
+	Volume   string
+	Instance string
+	ReadOnly bool
+	// FSType is the file system a mount operation formats the device with.
+	FSType string
+}
+
+func (op reconcileOp) String() string {
+	switch op.Kind {
+	case "attach", "mount", "bind":
+		return fmt.Sprintf("%s(%s, %s, %s)", op.Kind, op.Volume, op.Instance, modeString(op.ReadOnly))
+	}
+	return fmt.Sprintf("%s(%s, %s)", op.Kind, op.Volume, op.Instance)
+}
+
+// planReconcile returns the operations that take actual to desired. All
+// teardown comes before any setup, since a read-write attachment elsewhere
+// can block an attach. An attachment or mount in the wrong mode is torn down
+// and set up again, as is everything on top of it.
+func planReconcile(desired *desiredState, actual actualState) []reconcileOp {
+	var teardown, setup []reconcileOp
+	for _, v := range desired.Volumes {
+		v := v
+		instances := make([]string, 0, len(actual[v.Name]))
+		for instanceName := range actual[v.Name] {
+			instances = append(instances, instanceName)
+		}
+		sort.Strings(instances)
+
+		for _, instanceName := range instances {
+			a := actual[v.Name][instanceName]
+			want := v.attachment(instanceName)
+
+			detach := a.Attached && (want == nil || want.ReadOnly != a.ReadOnly)
+			unmount := a.Mounted && (want == nil || !want.Mount || detach || want.ReadOnly != a.MountedReadOnly)
+			unbind := a.Bound && (want == nil || !want.Bind || unmount)
+			if unbind {
+				teardown = append(teardown, newReconcileOp("unbind", &v, instanceName, false))
+			}
+			if unmount {
+				teardown = append(teardown, newReconcileOp("unmount", &v, instanceName, false))
+			}
+			if detach {
+				teardown = append(teardown, newReconcileOp("detach", &v, instanceName, false))
+			}
+
+			if want == nil {
+				continue
+			}
+			if !a.Attached || detach {
+				setup = append(setup, newReconcileOp("attach", &v, instanceName, want.ReadOnly))
+			}
+			if want.Mount && (!a.Mounted || unmount) {
+				setup = append(setup, newReconcileOp("mount", &v, instanceName, want.ReadOnly))
+			}
+			if want.Bind && (!a.Bound || unbind) {
+				setup = append(setup, newReconcileOp("bind", &v, instanceName, want.ReadOnly))
+			}
+		}
+	}
+	return append(teardown, setup...)
+}
+
+func newReconcileOp(kind string, v *desiredVolume, instanceName string, readOnly bool) reconcileOp {
+	return reconcileOp{Kind: kind, Volume: v.Name, Instance: instanceName, ReadOnly: readOnly, FSType: v.FSType}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func planStrings(plan []reconcileOp) []string {
+	var ops []string
+	for _, op := range plan {
+		ops = append(ops, op.String())
+	}
+	return ops
+}
+
+// TestPlannerHasNoIO keeps reconcile_plan.go ready to become a package of
+// its own.
+func TestPlannerHasNoIO(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "reconcile_plan.go", nil, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range f.Imports {
+		if importPath, _ := strconv.Unquote(spec.Path.Value); importPath != "fmt" && importPath != "sort" {
+			t.Errorf("reconcile_plan.go imports %s, expected only fmt and sort", importPath)
+		}
+	}
+}
+
+func TestPlanReconcile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		desired desiredVolume
+		actual  map[string]*actualAttachment
+		plan    []string
+	}{
+		{
+			name:    "converged",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", Bind: true}}},
+			actual:  map[string]*actualAttachment{"a": {Attached: true, Mounted: true, Bound: true}},
+		},
+		{
+			name:    "nothing set up",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", ReadOnly: true, Bind: true}}},
+			actual:  map[string]*actualAttachment{"a": {}},
+			plan:    []string{"attach(pd, a, ro)", "mount(pd, a, ro)", "bind(pd, a, ro)"},
+		},
+		{
+			name:    "missing mount",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", Mount: true}}},
+			actual:  map[string]*actualAttachment{"a": {Attached: true}},
+			plan:    []string{"mount(pd, a, rw)"},
+		},
+		{
+			name:    "extra attachment",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", ReadOnly: true}}},
+			actual: map[string]*actualAttachment{
+				"a": {Attached: true, ReadOnly: true},
+				"b": {Attached: true, Mounted: true},
+			},
+			plan: []string{"unmount(pd, b)", "detach(pd, b)"},
+		},
+		{
+			name:    "stray disk",
+			desired: desiredVolume{Name: "pd"},
+			actual:  map[string]*actualAttachment{"a": {Attached: true, Mounted: true, Bound: true}},
+			plan:    []string{"unbind(pd, a)", "unmount(pd, a)", "detach(pd, a)"},
+		},
+		{
+			name:    "attached in the wrong mode",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", ReadOnly: true, Bind: true}}},
+			actual:  map[string]*actualAttachment{"a": {Attached: true, Mounted: true, Bound: true}},
+			plan: []string{
+				"unbind(pd, a)", "unmount(pd, a)", "detach(pd, a)",
+				"attach(pd, a, ro)", "mount(pd, a, ro)", "bind(pd, a, ro)",
+			},
+		},
+		{
+			name:    "mounted in the wrong mode",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", ReadOnly: true, Mount: true}}},
+			actual:  map[string]*actualAttachment{"a": {Attached: true, ReadOnly: true, Mounted: true}},
+			plan:    []string{"unmount(pd, a)", "mount(pd, a, ro)"},
+		},
+		{
+			name:    "unwanted bind mount",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", Mount: true}}},
+			actual:  map[string]*actualAttachment{"a": {Attached: true, Mounted: true, Bound: true}},
+			plan:    []string{"unbind(pd, a)"},
+		},
+		{
+			name: "handoff tears down before setting up",
+			desired: desiredVolume{Name: "pd", Attachments: []desiredAttachment{
+				{Instance: "a", ReadOnly: true, Mount: true},
+				{Instance: "b", ReadOnly: true, Mount: true},
+			}},
+			actual: map[string]*actualAttachment{
+				"a": {Attached: true, Mounted: true},
+				"b": {},
+			},
+			plan: []string{
+				"unmount(pd, a)", "detach(pd, a)",
+				"attach(pd, a, ro)", "mount(pd, a, ro)", "attach(pd, b, ro)", "mount(pd, b, ro)",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			desired := &desiredState{Volumes: []desiredVolume{tc.desired}}
+			if err := desired.validate(); err != nil {
+				t.Fatal(err)
+			}
+			plan := planStrings(planReconcile(desired, actualState{"pd": tc.actual}))
+			if !reflect.DeepEqual(plan, tc.plan) {
+				t.Errorf("plan = %v, expected %v", plan, tc.plan)
+			}
+		})
+	}
+}
+
+func TestDesiredStateValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		volumes []desiredVolume
+		ok      bool
+	}{
+		{name: "empty", ok: true},
+		{name: "unnamed volume", volumes: []desiredVolume{{}}},
+		{name: "duplicate volume", volumes: []desiredVolume{{Name: "pd"}, {Name: "pd"}}},
+		{name: "attachment without instance", volumes: []desiredVolume{{Name: "pd", Attachments: []desiredAttachment{{}}}}},
+		{
+			name:    "attached twice to one instance",
+			volumes: []desiredVolume{{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", ReadOnly: true}, {Instance: "a", ReadOnly: true}}}},
+		},
+		{
+			name:    "read-write and shared",
+			volumes: []desiredVolume{{Name: "pd", Attachments: []desiredAttachment{{Instance: "a"}, {Instance: "b", ReadOnly: true}}}},
+		},
+		{
+			name:    "read-only and shared",
+			volumes: []desiredVolume{{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", ReadOnly: true}, {Instance: "b", ReadOnly: true}}}},
+			ok:      true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &desiredState{Volumes: tc.volumes}
+			if err := d.validate(); (err == nil) != tc.ok {
+				t.Errorf("validate() = %v, expected ok=%v", err, tc.ok)
+			}
+		})
+	}
+
+	d := &desiredState{Volumes: []desiredVolume{{Name: "pd", Attachments: []desiredAttachment{{Instance: "a", Bind: true}}}}}
+	if err := d.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if v := d.Volumes[0]; v.FSType != testFSType || !v.Attachments[0].Mount {
+		t.Errorf("validate() left %+v, expected the default file system and Bind to imply Mount", v)
+	}
+}
+
+// driftedProvider returns a fake provider holding three formatted volumes
+// that have drifted from driftedState: "extra" is also attached and mounted
+// on node-b, "unmounted" is attached but not mounted, and "stray" is still
+// attached, mounted and bind-mounted although nothing wants it.
+func driftedProvider(t *testing.T) *fakeProvider {
+	ctx := context.Background()
+	t.Helper()
+	p := newFakeProvider()
+	useProvider(t, p)
+	for _, name := range []string{"extra", "unmounted", "stray"} {
+		if err := p.CreateVolume(ctx, name, diskSpec{SizeGB: 10}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.format(name, testFSType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, setup := range []struct {
+		name, instance string
+		readOnly       bool
+		mount, bind    bool
+	}{
+		{name: "extra", instance: "node-a", readOnly: true, mount: true},
+		{name: "extra", instance: "node-b", readOnly: true, mount: true},
+		{name: "unmounted", instance: "node-a"},
+		{name: "stray", instance: "node-a", mount: true, bind: true},
+	} {
+		if err := p.AttachVolume(ctx, setup.name, setup.instance, setup.readOnly); err != nil {
+			t.Fatal(err)
+		}
+		globalPath := getDeviceGlobalMountPath(setup.name)
+		if setup.mount {
+			if err := mountDevice(ctx, getPDDevPath(setup.name), globalPath, setup.instance, testFSType, setup.readOnly, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if setup.bind {
+			if err := bindMountToFinalPath(ctx, globalPath, getFinalMountPath(setup.name), setup.instance, setup.readOnly, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return p
+}
+
+func driftedState(t *testing.T) *desiredState {
+	t.Helper()
+	desired := &desiredState{Volumes: []desiredVolume{
+		{Name: "extra", Attachments: []desiredAttachment{{Instance: "node-a", ReadOnly: true, Mount: true}}},
+		{Name: "unmounted", Attachments: []desiredAttachment{{Instance: "node-a", Bind: true}}},
+		{Name: "stray"},
+	}}
+	if err := desired.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return desired
+}
+
+func TestObserveAndPlanDriftedState(t *testing.T) {
+	driftedProvider(t)
+	desired := driftedState(t)
+
+	actual, err := observeState(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("observeState failed: %v", err)
+	}
+	expected := actualState{
+		"extra": {
+			"node-a": {Attached: true, ReadOnly: true, Mounted: true, MountedReadOnly: true},
+			"node-b": {Attached: true, ReadOnly: true, Mounted: true, MountedReadOnly: true},
+		},
+		"unmounted": {"node-a": {Attached: true}},
+		"stray":     {"node-a": {Attached: true, Mounted: true, Bound: true}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		for name, instances := range actual {
+			for instanceName, a := range instances {
+				t.Logf("observed %s on %s: %+v", name, instanceName, *a)
+			}
+		}
+		t.Fatalf("observed state differs from the drifted state")
+	}
+
+	plan := planStrings(planReconcile(desired, actual))
+	expectedPlan := []string{
+		"unmount(extra, node-b)", "detach(extra, node-b)",
+		"unbind(stray, node-a)", "unmount(stray, node-a)", "detach(stray, node-a)",
+		"mount(unmounted, node-a, rw)", "bind(unmounted, node-a, rw)",
+	}
+	if !reflect.DeepEqual(plan, expectedPlan) {
+		t.Errorf("plan = %v, expected %v", plan, expectedPlan)
+	}
+}
+
+// writeDesiredState writes desired to a file for runReconcile.
+func writeDesiredState(t *testing.T, desired *desiredState) string {
+	t.Helper()
+	data, err := json.Marshal(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(t.TempDir(), "desired.json")
+	if err := os.WriteFile(statePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return statePath
+}
+
+func setReconcilePasses(t *testing.T, passes int) {
+	t.Helper()
+	savedPasses, savedBackoff := *reconcilePasses, *reconcileBackoff
+	*reconcilePasses, *reconcileBackoff = passes, time.Millisecond
+	t.Cleanup(func() { *reconcilePasses, *reconcileBackoff = savedPasses, savedBackoff })
+}
+
+func TestRunReconcileConvergesInOnePass(t *testing.T) {
+	ctx := context.Background()
+	setReconcilePasses(t, 1)
+	p := driftedProvider(t)
+	desired := driftedState(t)
+
+	if err := runReconcile(ctx, writeDesiredState(t, desired)); err != nil {
+		t.Fatalf("runReconcile with -reconcile-passes=1 failed: %v", err)
+	}
+	actual, err := observeState(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := planReconcile(desired, actual); len(plan) != 0 {
+		t.Errorf("state still plans %v after reconciling", plan)
+	}
+	if users, _ := p.VolumeUsers(ctx, "stray"); len(users) != 0 {
+		t.Errorf("stray volume is still attached to %v", users)
+	}
+}
+
+func TestRunReconcileReportsNoConvergence(t *testing.T) {
+	setReconcilePasses(t, 0)
+	driftedProvider(t)
+
+	err := runReconcile(context.Background(), writeDesiredState(t, driftedState(t)))
+	if err == nil {
+		t.Fatalf("runReconcile without passes converged on a drifted state")
+	}
+	if expected := "did not converge after 0 passes"; !strings.Contains(err.Error(), expected) {
+		t.Errorf("runReconcile failed with %v, expected %q", err, expected)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	runPrefix   = flag.String("run-prefix", "test", "Prefix of the generated run ID, e.g. your username so your disks are recognizable.")
+	runIDFlag   = flag.String("run-id", "", "Run ID to use instead of generating one, to operate on the disks of an earlier run.")
+	runGuardOff = flag.Bool("no-run-guard", false, "Attach, detach and delete disks even if they do not carry the current run's label.")
+)
+
+// runLabelKey is the disk label holding the ID of the run that created the
+// disk.
+const runLabelKey = "run-id"
+
+// maxRunIDLength leaves room within GCE's 63 character name limit for the
+// suffixes disk names and LUKS mappings add to the run ID.
+const maxRunIDLength = 40
+
+// GCE resource names: a lowercase letter, then lowercase letters, digits and
+// dashes, not ending in a dash.
+var gceNameRE = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// runID identifies this invocation of the tool. It is part of every disk
+// name, labels every disk, and prefixes every log line.
+var runID string
+
+// diskSeq numbers the disks of a run.
+var diskSeq int64
+
+// initRunID sets runID from -run-id, or generates one from -run-prefix, the
+// time and a random suffix so parallel runs started in the same second do not
+// collide.
+func initRunID() error {
+	id := *runIDFlag
+	if id == "" {
+		if err := validateRunID(*runPrefix); err != nil {
+			return fmt.Errorf("invalid -run-prefix: %v", err)
+		}
+		suffix, err := randomSuffix(6)
+		if err != nil {
+			return err
+		}
+		id = fmt.Sprintf("%s-%s-%s", *runPrefix, time.Now().Format("20060102150405"), suffix)
+	}
+	return setRunID(id)
+}
+
+// setRunID makes id the current run ID, e.g. the ID of a resumed run.
+func setRunID(id string) error {
+	if err := validateRunID(id); err != nil {
+		return err
+	}
+	runID = id
+	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	log.SetPrefix("[" + runID + "] ")
+	return nil
+}
+
+func validateRunID(id string) error {
+	if len(id) > maxRunIDLength {
+		return fmt.Errorf("run ID %q is longer than %d characters", id, maxRunIDLength)
+	}
+	if !gceNameRE.MatchString(id) {
+		return fmt.Errorf("run ID %q must be lowercase letters, digits and dashes, starting with a letter and not ending with a dash", id)
+	}
+	return nil
+}
+
+func randomSuffix(n int) (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", fmt.Errorf("generating run ID failed: %v", err)
+		}
+		b[i] = alphabet[j.Int64()]
+	}
+	return string(b), nil
+}
+
+// errForeignVolume is returned for operations on volumes of another run.
+// Retrying them cannot help.
+var errForeignVolume = errors.New("volume belongs to another run")
+
+// runGuardedProvider labels the volumes it creates with the run ID, and
+// refuses to attach, detach or delete volumes that do not carry it, so a run
+// cannot touch another run's disks. The check costs one extra describe per
+// operation.
+type runGuardedProvider struct {
+	VolumeProvider
+}
+
+func (p runGuardedProvider) CreateVolume(ctx context.Context, name string, spec diskSpec) error {
+	labels := make(map[string]string, len(spec.Labels)+1)
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+	// The run label goes last so a user label cannot hide the volume from
+	// this run's guard, or hand it to another run.
+	labels[runLabelKey] = runID
+	spec.Labels = labels
+	return p.VolumeProvider.CreateVolume(ctx, name, spec)
+}
+
+func (p runGuardedProvider) DeleteVolume(ctx context.Context, name string) error {
+	if err := p.checkRunLabel(ctx, name); err != nil {
+		return err
+	}
+	return p.VolumeProvider.DeleteVolume(ctx, name)
+}
+
+func (p runGuardedProvider) AttachVolume(ctx context.Context, name, instanceName string, readOnly bool) error {
+	if err := p.checkRunLabel(ctx, name); err != nil {
+		return err
+	}
+	return p.VolumeProvider.AttachVolume(ctx, name, instanceName, readOnly)
+}
+
+func (p runGuardedProvider) DetachVolume(ctx context.Context, name, instanceName string) error {
+	if err := p.checkRunLabel(ctx, name); err != nil {
+		return err
+	}
+	return p.VolumeProvider.DetachVolume(ctx, name, instanceName)
+}
+
+func (p runGuardedProvider) checkRunLabel(ctx context.Context, name string) error {
+	if *runGuardOff {
+		return nil
+	}
+	labels, err := p.VolumeLabels(ctx, name)
+	if err != nil {
+		return err
+	}
+	if owner := labels[runLabelKey]; owner != runID {
+		return fmt.Errorf("refusing to touch volume %q: its %s label is %q, this run is %q (use -run-id to adopt its run): %w", name, runLabelKey, owner, runID, errForeignVolume)
+	}
+	return nil
+}
+
+// generatePdName returns a new disk name within the current run.
+func generatePdName() string {
+	return fmt.Sprintf("%s-%d", runID, atomic.AddInt64(&diskSeq, 1))
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateRunID(t *testing.T) {
+	for _, tc := range []struct {
+		id string
+		ok bool
+	}{
+		{id: "test-20161102150405-a1b2c3", ok: true},
+		{id: "a", ok: true},
+		{id: strings.Repeat("a", maxRunIDLength), ok: true},
+		{id: strings.Repeat("a", maxRunIDLength+1)},
+		{id: ""},
+		{id: "1test"},
+		{id: "-test"},
+		{id: "test-"},
+		{id: "Test"},
+		{id: "test_run"},
+		{id: "test.run"},
+	} {
+		if err := validateRunID(tc.id); (err == nil) != tc.ok {
+			t.Errorf("validateRunID(%q) = %v, expected ok=%v", tc.id, err, tc.ok)
+		}
+	}
+}
+
+func TestGeneratePdNameIsAValidRunName(t *testing.T) {
+	name := generatePdName()
+	if !strings.HasPrefix(name, runID+"-") || !gceNameRE.MatchString(name) {
+		t.Errorf("generatePdName() = %q, expected a GCE name within run %q", name, runID)
+	}
+	if next := generatePdName(); next == name {
+		t.Errorf("generatePdName() returned %q twice", name)
+	}
+}
+
+func TestRunGuardedProvider(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProvider()
+	p := runGuardedProvider{fake}
+
+	// A user label cannot override the run label.
+	if err := p.CreateVolume(ctx, "ours", diskSpec{SizeGB: 1, Labels: map[string]string{runLabelKey: "other-run", "team": "storage"}}); err != nil {
+		t.Fatal(err)
+	}
+	labels, err := p.VolumeLabels(ctx, "ours")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels[runLabelKey] != runID || labels["team"] != "storage" {
+		t.Errorf("created volume has labels %v, expected %s=%s and the user label", labels, runLabelKey, runID)
+	}
+	if err := p.AttachVolume(ctx, "ours", "node-a", false /* readOnly */); err != nil {
+		t.Errorf("attaching this run's volume failed: %v", err)
+	}
+
+	if err := fake.CreateVolume(ctx, "theirs", diskSpec{SizeGB: 1, Labels: map[string]string{runLabelKey: "other-run"}}); err != nil {
+		t.Fatal(err)
+	}
+	for name, operation := range map[string]func() error{
+		"attach": func() error { return p.AttachVolume(ctx, "theirs", "node-a", false /* readOnly */) },
+		"detach": func() error { return p.DetachVolume(ctx, "theirs", "node-a") },
+		"delete": func() error { return p.DeleteVolume(ctx, "theirs") },
+	} {
+		if err := operation(); !errors.Is(err, errForeignVolume) {
+			t.Errorf("%s of another run's volume returned %v, expected errForeignVolume", name, err)
+		}
+	}
+
+	saved := *runGuardOff
+	*runGuardOff = true
+	t.Cleanup(func() { *runGuardOff = saved })
+	if err := p.DeleteVolume(ctx, "theirs"); err != nil {
+		t.Errorf("delete with -no-run-guard failed: %v", err)
+	}
+}
+
+func TestDiskSpecReservesRunLabel(t *testing.T) {
+	spec := diskSpec{SizeGB: 1, Labels: map[string]string{runLabelKey: runID}}
+	if err := spec.validate(); err == nil {
+		t.Errorf("validate() accepted a user-supplied %s label", runLabelKey)
+	}
+}
@@ -0,0 +1,743 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var mountOptions = flag.String("mount-options", "", "Comma separated mount options for the device and bind mounts of a plain run, e.g. noatime,discard.")
+
+const (
+	testFileContent = "hello world"
+	testFileName    = "mytest.log"
+)
+
+// scenario describes a single PD lifecycle run: the disk to create, how to
+// format it, and the pair of instances the disk is handed off between.
+type scenario struct {
+	FSType    string
+	ReadOnly  bool
+	Disk      diskSpec
+	Instances [2]string
+	// MountOptions are passed to both the device mount and the bind mount,
+	// and verified from mountinfo afterwards.
+	MountOptions []string
+	// FSGroup, if set, is applied to the final mount path after every
+	// read-write bind mount, the way kubelet applies a pod's fsGroup.
+	FSGroup             *int64
+	FSGroupChangePolicy string
+	// FSGroupFiles files are created before the first fsGroup change so
+	// its cost can be measured against the file count.
+	FSGroupFiles int
+	// LUKSKeyFile, if set, is the local key file the PD is encrypted with.
+	LUKSKeyFile string
+	// Benchmark, if set, is run while the PD is mounted read-write on the
+	// first instance.
+	Benchmark *benchmarkSpec
+}
+
+type diskSpec struct {
+	// Type is the provider's disk type, e.g. pd-ssd or gp3. Empty uses the
+	// provider default.
+	Type   string `json:"type"`
+	SizeGB int    `json:"sizeGB"`
+	// ReplicaZones makes the disk a regional PD replicated across these two
+	// zones. Empty creates a zonal PD in -zone.
+	ReplicaZones []string `json:"replicaZones,omitempty"`
+	// Image or Snapshot, if set, is the source the disk is created from.
+	Image    string            `json:"image,omitempty"`
+	Snapshot string            `json:"snapshot,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// PhysicalBlockSizeBytes of 0 uses the provider default.
+	PhysicalBlockSizeBytes int `json:"physicalBlockSizeBytes,omitempty"`
+	// KMSKey is the resource name of a customer-managed encryption key.
+	KMSKey string `json:"kmsKey,omitempty"`
+}
+
+func defaultScenario() scenario {
+	s := scenario{
+		FSType:    testFSType,
+		Instances: [2]string{testInstance0Name, testInstance1Name},
+	}
+	if *mountOptions != "" {
+		s.MountOptions = strings.Split(*mountOptions, ",")
+	}
+	if *fsGroup >= 0 {
+		gid := *fsGroup
+		s.FSGroup = &gid
+		s.FSGroupChangePolicy = *fsGroupPolicy
+		s.FSGroupFiles = *fsGroupFiles
+	}
+	s.LUKSKeyFile = *luksKeyFile
+	disk, err := diskSpecFromFlags()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s.Disk = disk
+	benchmark, err := benchmarkFromFlags()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s.Benchmark = benchmark
+	return s
+}
+
+func (s scenario) String() string {
+	diskType := s.Disk.Type
+	if diskType == "" {
+		diskType = "default"
+	}
+	str := fmt.Sprintf("%s/%s/%s/%dGB/%s+%s", s.FSType, modeString(s.ReadOnly), diskType, s.Disk.SizeGB, s.Instances[0], s.Instances[1])
+	if len(s.Disk.ReplicaZones) > 0 {
+		str += "/regional=" + strings.Join(s.Disk.ReplicaZones, "+")
+	}
+	if len(s.MountOptions) > 0 {
+		str += "/" + strings.Join(s.MountOptions, ",")
+	}
+	if s.FSGroup != nil {
+		str += fmt.Sprintf("/fsGroup=%d:%s", *s.FSGroup, s.FSGroupChangePolicy)
+		if s.FSGroupFiles > 0 {
+			str += fmt.Sprintf(":%dfiles", s.FSGroupFiles)
+		}
+	}
+	if s.LUKSKeyFile != "" {
+		str += "/luks"
+	}
+	if s.Benchmark != nil {
+		str += "/bench"
+	}
+	return str
+}
+
+// step is a single operation in a lifecycle run.
+type step struct {
+	name string
+	// kind groups steps for latency reporting, e.g. "attach" or "mount".
+	kind string
+	run  func(ctx context.Context) error
+	// abort stops the run when the step fails. Later steps, including
+	// cleanup, are skipped.
+	abort bool
+	// bestEffort steps are logged on failure but do not fail the run.
+	bestEffort bool
+	// effect records what a successful step changed in the run state.
+	effect func(state *runState)
+}
+
+type stepResult struct {
+	Name     string
+	Kind     string
+	Duration time.Duration
+	Err      error
+	// Detail is a short step-specific measurement for the report.
+	Detail string
+	// ArtifactsDir holds diagnostics collected when the step failed.
+	ArtifactsDir string
+}
+
+type runResult struct {
+	Scenario scenario
+	PdName   string
+	Steps    []stepResult
+	// Benchmarks holds the results of the benchmark step, if it ran.
+	Benchmarks []benchmarkResult
+	Duration   time.Duration
+	Err        error
+}
+
+// kindLatency returns the mean duration of all steps of the given kind.
+func (r *runResult) kindLatency(kind string) time.Duration {
+	var total time.Duration
+	n := 0
+	for _, sr := range r.Steps {
+		if sr.Kind == kind {
+			total += sr.Duration
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / time.Duration(n)
+}
+
+// runScenario creates pdName and drives it through the lifecycle described by
+// s, recording the result of every step.
+func runScenario(ctx context.Context, s scenario, pdName string) *runResult {
+	if err := provider.ValidateScenario(s); err != nil {
+		return &runResult{Scenario: s, PdName: pdName, Err: err}
+	}
+	return executeRun(ctx, newRunState(s, pdName))
+}
+
+// executeRun runs the lifecycle steps recorded in state, starting after the
+// last completed one, and checkpoints state after every step.
+func executeRun(ctx context.Context, state *runState) *runResult {
+	s, pdName := state.Scenario, state.PdName
+	log.Printf("***Running scenario %v with PD %q from step %d\r\n", s, pdName, state.CompletedSteps)
+	result := &runResult{Scenario: s, PdName: pdName}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+	resumed := state.CompletedSteps > 0
+	defer func() {
+		rec := newHistoryRecord("lifecycle", s.String(), pdName, start, result.Steps, result.Err, resumed)
+		rec.addBenchmarks(s, result.Benchmarks)
+		appendHistory(rec)
+	}()
+
+	l := newLifecycle(s, pdName)
+	defer func() { result.Benchmarks = l.benchmarks }()
+	steps := l.steps()
+	failed := false
+	for i := state.CompletedSteps; i < len(steps); i++ {
+		st := steps[i]
+		log.Printf("***Step %q\r\n", st.name)
+		stepStart := time.Now()
+		l.detail = ""
+		err := traced(ctx, st.name, spanAttrs{Step: st.name, Disk: pdName}, st.run)
+		result.Steps = append(result.Steps, stepResult{
+			Name:     st.name,
+			Kind:     st.kind,
+			Duration: time.Since(stepStart),
+			Err:      err,
+			Detail:   l.detail,
+		})
+		if err == nil && st.effect != nil {
+			st.effect(state)
+		}
+		if err != nil {
+			log.Printf("Step %q failed: %v\r\n", st.name, err)
+			result.Steps[len(result.Steps)-1].ArtifactsDir = collectArtifacts(ctx, pdName, i, st.name, s.Instances[:], stepStart)
+			if st.abort {
+				if st.kind != "create" {
+					metrics.setDiskLeaked(pdName, true)
+				}
+				state.save()
+				result.Err = fmt.Errorf("step %q failed: %v", st.name, err)
+				return result
+			}
+			if !st.bestEffort {
+				failed = true
+			}
+		}
+
+		state.CompletedSteps = i + 1
+		state.save()
+	}
+
+	if !state.Created {
+		// The disk is gone, so there is nothing left to resume or tear down.
+		state.remove()
+	}
+	if failed {
+		result.Err = errors.New("lifecycle run had errors")
+	}
+	return result
+}
+
+// executeSteps runs steps until one fails, then every cleanup step
+// regardless. Steps report a measurement by setting *detail. A failed step
+// collects artifacts about pdName from instances. Unlike executeRun it keeps
+// no run state, so the run cannot be resumed.
+func executeSteps(ctx context.Context, steps, cleanup []step, detail *string, pdName string, instances []string) ([]stepResult, error) {
+	var results []stepResult
+	runStep := func(st step) error {
+		log.Printf("***Step %q\r\n", st.name)
+		stepStart := time.Now()
+		*detail = ""
+		err := traced(ctx, st.name, spanAttrs{Step: st.name}, st.run)
+		results = append(results, stepResult{
+			Name:     st.name,
+			Kind:     st.kind,
+			Duration: time.Since(stepStart),
+			Err:      err,
+			Detail:   *detail,
+		})
+		if err != nil {
+			log.Printf("Step %q failed: %v\r\n", st.name, err)
+			results[len(results)-1].ArtifactsDir = collectArtifacts(ctx, pdName, len(results)-1, st.name, instances, stepStart)
+		}
+		return err
+	}
+
+	var runErr error
+	for _, st := range steps {
+		if err := runStep(st); err != nil {
+			runErr = fmt.Errorf("step %q failed: %v", st.name, err)
+			break
+		}
+	}
+	cleanupFailed := false
+	for _, st := range cleanup {
+		if err := runStep(st); err != nil {
+			cleanupFailed = true
+		}
+	}
+	if runErr == nil && cleanupFailed {
+		runErr = errors.New("cleanup had errors")
+	}
+	return results, runErr
+}
+
+// lifecycle builds the steps for one scenario run.
+type lifecycle struct {
+	s                  scenario
+	pdName             string
+	devGlobalMountPath string
+	finalMountPath     string
+	// detail is set by a running step to report a measurement.
+	detail     string
+	benchmarks []benchmarkResult
+}
+
+func newLifecycle(s scenario, pdName string) *lifecycle {
+	return &lifecycle{
+		s:                  s,
+		pdName:             pdName,
+		devGlobalMountPath: getDeviceGlobalMountPath(pdName),
+		finalMountPath:     getFinalMountPath(pdName),
+	}
+}
+
+// mountDevicePath returns the device that is formatted and mounted on
+// instanceName: the PD itself, or the LUKS mapping on top of it.
+func (l *lifecycle) mountDevicePath(instanceName string) (string, error) {
+	if l.s.LUKSKeyFile != "" {
+		return luksMapperPath(l.pdName), nil
+	}
+	return provider.DevicePath(l.pdName, instanceName)
+}
+
+func (l *lifecycle) steps() []step {
+	host0, host1 := l.s.Instances[0], l.s.Instances[1]
+
+	steps := []step{l.create()}
+
+	// Attach PD RW to host0, write to it and hand it back.
+	attach0 := l.attach(host0, false /* readOnly */)
+	attach0.abort = true
+	steps = append(steps,
+		attach0,
+		l.listDisks(host0),
+		l.verifyDevice(host0),
+	)
+	steps = append(steps, l.openSteps(host0, false /* readOnly */, true /* format */)...)
+	steps = append(steps,
+		l.mount(host0, false /* readOnly */),
+		l.bind(host0, false /* readOnly */),
+	)
+	if l.s.FSGroup != nil && l.s.FSGroupFiles > 0 {
+		steps = append(steps, l.populate(host0))
+	}
+	steps = append(steps, l.fsGroupSteps(host0)...)
+	steps = append(steps, l.write(host0))
+	if l.s.LUKSKeyFile != "" {
+		steps = append(steps, l.verifyEncrypted(host0))
+	}
+	if l.s.Benchmark != nil {
+		steps = append(steps, l.benchmark(host0))
+	}
+	steps = append(steps,
+		l.read(host0),
+		l.sleep(3*time.Second),
+	)
+	steps = append(steps, l.teardown(host0)...)
+
+	if !l.s.ReadOnly {
+		// Attach PD RW to host1 and verify the data written on host0.
+		steps = append(steps,
+			l.attach(host1, false /* readOnly */),
+		)
+		steps = append(steps, l.openSteps(host1, false /* readOnly */, false /* format */)...)
+		steps = append(steps,
+			l.mount(host1, false /* readOnly */),
+			l.bind(host1, false /* readOnly */),
+		)
+		steps = append(steps, l.fsGroupSteps(host1)...)
+		steps = append(steps,
+			l.read(host1),
+			l.sleep(10*time.Second),
+		)
+		steps = append(steps, l.teardown(host1)...)
+	} else {
+		// Attach PD RO to both hosts at once and verify the data on each.
+		for _, host := range []string{host0, host1} {
+			steps = append(steps, l.attach(host, true /* readOnly */))
+			steps = append(steps, l.openSteps(host, true /* readOnly */, false /* format */)...)
+			steps = append(steps,
+				l.mount(host, true /* readOnly */),
+				l.bind(host, true /* readOnly */),
+			)
+		}
+		steps = append(steps, l.read(host0), l.read(host1), l.sleep(10*time.Second))
+		steps = append(steps, l.teardown(host0)...)
+		steps = append(steps, l.teardown(host1)...)
+	}
+
+	return append(steps, l.delete())
+}
+
+// teardown unmounts the PD on instanceName and detaches it.
+func (l *lifecycle) teardown(instanceName string) []step {
+	steps := []step{
+		l.removeBind(instanceName),
+		l.unmount(instanceName),
+	}
+	if l.s.LUKSKeyFile != "" {
+		steps = append(steps, l.closeLUKS(instanceName))
+	}
+	return append(steps, l.detach(instanceName))
+}
+
+func (l *lifecycle) create() step {
+	return step{
+		name:  "create PD",
+		kind:  "create",
+		abort: true,
+		run: func(ctx context.Context) error {
+			_, err := createPDWithRetry(ctx, l.pdName, l.s.Disk)
+			return err
+		},
+		effect: func(state *runState) {
+			state.Created = true
+		},
+	}
+}
+
+func (l *lifecycle) delete() step {
+	return step{
+		name: "delete PD",
+		kind: "delete",
+		run: func(ctx context.Context) error {
+			return deletePDWithRetry(ctx, l.pdName)
+		},
+		effect: func(state *runState) {
+			state.Created = false
+		},
+	}
+}
+
+func (l *lifecycle) attach(instanceName string, readOnly bool) step {
+	return step{
+		name: fmt.Sprintf("attach %s to %s", modeString(readOnly), instanceName),
+		kind: "attach",
+		run: func(ctx context.Context) error {
+			return attachDiskWithRetry(ctx, l.pdName, instanceName, readOnly)
+		},
+		effect: func(state *runState) {
+			state.Attachments[instanceName] = modeString(readOnly)
+		},
+	}
+}
+
+func (l *lifecycle) detach(instanceName string) step {
+	return step{
+		name: "detach from " + instanceName,
+		kind: "detach",
+		run: func(ctx context.Context) error {
+			return detachDiskWithRetry(ctx, l.pdName, instanceName)
+		},
+		effect: func(state *runState) {
+			delete(state.Attachments, instanceName)
+		},
+	}
+}
+
+func (l *lifecycle) listDisks(instanceName string) step {
+	return step{
+		name:       "list disks on " + instanceName,
+		kind:       "inspect",
+		bestEffort: true,
+		run: func(ctx context.Context) error {
+			o, err := runOnInstance(ctx, "ls "+diskByIdPath, instanceName)
+			log.Printf("ls %s\r\n%v", diskByIdPath, string(o))
+			return err
+		},
+	}
+}
+
+func (l *lifecycle) verifyDevice(instanceName string) step {
+	return step{
+		name: "verify block device on " + instanceName,
+		kind: "inspect",
+		run: func(ctx context.Context) error {
+			devPath, err := provider.DevicePath(l.pdName, instanceName)
+			if err != nil {
+				return err
+			}
+			return verifyBlockDevice(ctx, devPath, l.s.Disk, instanceName)
+		},
+	}
+}
+
+func (l *lifecycle) mount(instanceName string, readOnly bool) step {
+	return step{
+		name: fmt.Sprintf("mount device %s on %s", modeString(readOnly), instanceName),
+		kind: "mount",
+		run: func(ctx context.Context) error {
+			devPath, err := l.mountDevicePath(instanceName)
+			if err != nil {
+				return err
+			}
+			if err := mountDevice(ctx, devPath, l.devGlobalMountPath, instanceName, l.s.FSType, readOnly, l.s.MountOptions); err != nil {
+				return err
+			}
+			_, err = verifyMountOptions(ctx, l.devGlobalMountPath, instanceName, l.requestedOptions(readOnly), false /* bind */)
+			return err
+		},
+		effect: func(state *runState) {
+			state.addMount(instanceName, l.devGlobalMountPath)
+		},
+	}
+}
+
+func (l *lifecycle) bind(instanceName string, readOnly bool) step {
+	return step{
+		name: fmt.Sprintf("bind mount %s on %s", modeString(readOnly), instanceName),
+		kind: "bind",
+		run: func(ctx context.Context) error {
+			if err := bindMountToFinalPath(ctx, l.devGlobalMountPath, l.finalMountPath, instanceName, readOnly, l.s.MountOptions); err != nil {
+				return err
+			}
+			report, err := verifyMountOptions(ctx, l.finalMountPath, instanceName, l.requestedOptions(readOnly), true /* bind */)
+			l.detail = report
+			return err
+		},
+		effect: func(state *runState) {
+			state.addMount(instanceName, l.finalMountPath)
+		},
+	}
+}
+
+// requestedOptions returns the mount options a mount in the given mode must
+// end up with.
+func (l *lifecycle) requestedOptions(readOnly bool) []string {
+	options := append([]string(nil), l.s.MountOptions...)
+	if readOnly {
+		options = append(options, "ro")
+	}
+	return options
+}
+
+func (l *lifecycle) removeBind(instanceName string) step {
+	return step{
+		name:       "remove bind mount on " + instanceName,
+		kind:       "unmount",
+		bestEffort: true,
+		run: func(ctx context.Context) (err error) {
+			l.detail, err = removeBindMount(ctx, l.finalMountPath, instanceName)
+			return err
+		},
+		effect: func(state *runState) {
+			state.removeMount(instanceName, l.finalMountPath)
+		},
+	}
+}
+
+func (l *lifecycle) unmount(instanceName string) step {
+	return step{
+		name:       "unmount device on " + instanceName,
+		kind:       "unmount",
+		bestEffort: true,
+		run: func(ctx context.Context) (err error) {
+			l.detail, err = unmountDevice(ctx, l.devGlobalMountPath, instanceName)
+			return err
+		},
+		effect: func(state *runState) {
+			state.removeMount(instanceName, l.devGlobalMountPath)
+		},
+	}
+}
+
+// openSteps opens the LUKS mapping on instanceName if the scenario is
+// encrypted. Only the first open formats the device.
+func (l *lifecycle) openSteps(instanceName string, readOnly, format bool) []step {
+	if l.s.LUKSKeyFile == "" {
+		return nil
+	}
+	return []step{{
+		name: fmt.Sprintf("open LUKS %s on %s", modeString(readOnly), instanceName),
+		kind: "luks",
+		run: func(ctx context.Context) error {
+			devPath, err := provider.DevicePath(l.pdName, instanceName)
+			if err != nil {
+				return err
+			}
+			return openLUKS(ctx, l.pdName, devPath, l.s.LUKSKeyFile, instanceName, readOnly, format)
+		},
+		effect: func(state *runState) {
+			state.Mappings[instanceName] = luksMapperName(l.pdName)
+		},
+	}}
+}
+
+func (l *lifecycle) closeLUKS(instanceName string) step {
+	return step{
+		name: "close LUKS on " + instanceName,
+		kind: "luks",
+		run: func(ctx context.Context) error {
+			return closeLUKS(ctx, l.pdName, instanceName)
+		},
+		effect: func(state *runState) {
+			delete(state.Mappings, instanceName)
+		},
+	}
+}
+
+func (l *lifecycle) verifyEncrypted(instanceName string) step {
+	return step{
+		name: "verify ciphertext on " + instanceName,
+		kind: "luks",
+		run: func(ctx context.Context) error {
+			devPath, err := provider.DevicePath(l.pdName, instanceName)
+			if err != nil {
+				return err
+			}
+			return verifyCiphertext(ctx, l.pdName, devPath, testFileContent, instanceName)
+		},
+	}
+}
+
+func (l *lifecycle) benchmark(instanceName string) step {
+	return step{
+		name: "benchmark on " + instanceName,
+		kind: "bench",
+		run: func(ctx context.Context) error {
+			results, err := runBenchmark(ctx, l.s.Benchmark, l.finalMountPath, instanceName)
+			l.benchmarks = append(l.benchmarks, results...)
+			l.detail = fmt.Sprintf("%d fio jobs", len(results))
+			return err
+		},
+	}
+}
+
+// fsGroupSteps applies the scenario's fsGroup to the final mount path, if it
+// has one.
+func (l *lifecycle) fsGroupSteps(instanceName string) []step {
+	if l.s.FSGroup == nil {
+		return nil
+	}
+	return []step{{
+		name: fmt.Sprintf("apply fsGroup %d on %s", *l.s.FSGroup, instanceName),
+		kind: "fsgroup",
+		run: func(ctx context.Context) error {
+			result, err := applyFSGroup(ctx, l.finalMountPath, instanceName, *l.s.FSGroup, l.s.FSGroupChangePolicy)
+			if err != nil {
+				return err
+			}
+			l.detail = result.String()
+			return nil
+		},
+	}}
+}
+
+func (l *lifecycle) populate(instanceName string) step {
+	return step{
+		name: fmt.Sprintf("create %d files on %s", l.s.FSGroupFiles, instanceName),
+		kind: "io",
+		run: func(ctx context.Context) error {
+			return populateFiles(ctx, path.Join(l.finalMountPath, "fsgroup"), instanceName, l.s.FSGroupFiles)
+		},
+	}
+}
+
+func (l *lifecycle) write(instanceName string) step {
+	return step{
+		name: "write file on " + instanceName,
+		kind: "io",
+		run: func(ctx context.Context) error {
+			_, err := WriteContentToFile(ctx, testFileContent, path.Join(l.finalMountPath, testFileName), instanceName)
+			return err
+		},
+	}
+}
+
+func (l *lifecycle) read(instanceName string) step {
+	return step{
+		name: "read file on " + instanceName,
+		kind: "io",
+		run: func(ctx context.Context) error {
+			content, err := ReadContentsFromFile(ctx, path.Join(l.finalMountPath, testFileName), instanceName)
+			if err != nil {
+				return err
+			}
+			if content != testFileContent {
+				return fmt.Errorf("read file content differs. Expected: <%s> Actual: <%s>", testFileContent, content)
+			}
+			return nil
+		},
+	}
+}
+
+func (l *lifecycle) sleep(d time.Duration) step {
+	return step{
+		name:       "sleep before unmount",
+		kind:       "sleep",
+		bestEffort: true,
+		run: func(ctx context.Context) error {
+			log.Println("Sleeping before unmount")
+			time.Sleep(d)
+			return nil
+		},
+	}
+}
+
+func modeString(readOnly bool) string {
+	if readOnly {
+		return "ro"
+	}
+	return "rw"
+}
+
+func printRunReport(w io.Writer, r *runResult) {
+	fmt.Fprintf(w, "Run report for PD %q, scenario %v\n", r.PdName, r.Scenario)
+	printStepTable(w, r.Steps)
+
+	if len(r.Benchmarks) > 0 {
+		printBenchmarkTable(w, []*runResult{r})
+	}
+
+	status := "PASSED"
+	if r.Err != nil {
+		status = fmt.Sprintf("FAILED (%v)", r.Err)
+	}
+	fmt.Fprintf(w, "Total %v: %s\n", r.Duration.Round(time.Millisecond), status)
+}
+
+// printStepTable prints one line per step with its duration and outcome.
+func printStepTable(w io.Writer, steps []stepResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tDURATION\tRESULT\tDETAIL\tARTIFACTS")
+	for _, sr := range steps {
+		status := "ok"
+		if sr.Err != nil {
+			status = "FAILED"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%s\n", sr.Name, sr.Duration.Round(time.Millisecond), status, sr.Detail, sr.ArtifactsDir)
+	}
+	tw.Flush()
+}
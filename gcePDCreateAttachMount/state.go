@@ -0,0 +1,259 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var stateDir = flag.String("state-dir", "state", "Directory run state files are checkpointed into after every step. Empty disables checkpointing.")
+
+// runState is checkpointed to disk after every step so a run interrupted
+// mid-scenario can be resumed or torn down.
+type runState struct {
+	// RunID is the run that created the disk. Resuming or tearing down the
+	// run adopts it.
+	RunID    string
+	PdName   string
+	Scenario scenario
+	// CompletedSteps is the number of lifecycle steps that have run. A
+	// resumed run starts with the step at this index.
+	CompletedSteps int
+	// Created is true while the disk exists.
+	Created bool
+	// Attachments maps instance name to attach mode, "rw" or "ro".
+	Attachments map[string]string
+	// Mounts maps instance name to mounted paths, in mount order.
+	Mounts map[string][]string
+	// Mappings maps instance name to the open LUKS mapping of the PD.
+	Mappings  map[string]string
+	UpdatedAt time.Time
+
+	// path is where the state is checkpointed. Empty disables saving.
+	path string
+}
+
+func newRunState(s scenario, pdName string) *runState {
+	state := &runState{
+		RunID:       runID,
+		PdName:      pdName,
+		Scenario:    s,
+		Attachments: make(map[string]string),
+		Mounts:      make(map[string][]string),
+		Mappings:    make(map[string]string),
+	}
+	if *stateDir != "" {
+		state.path = filepath.Join(*stateDir, pdName+".json")
+	}
+	return state
+}
+
+func loadRunState(statePath string) (*runState, error) {
+	if statePath == "" {
+		return nil, errors.New("no state file given")
+	}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading state file %q failed: %v", statePath, err)
+	}
+
+	state := &runState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing state file %q failed: %v", statePath, err)
+	}
+	if state.Attachments == nil {
+		state.Attachments = make(map[string]string)
+	}
+	if state.Mounts == nil {
+		state.Mounts = make(map[string][]string)
+	}
+	if state.Mappings == nil {
+		state.Mappings = make(map[string]string)
+	}
+	state.path = statePath
+	// Regional PDs are addressed by region, which only the spec records.
+	registerDisk(state.PdName, state.Scenario.Disk)
+	if state.RunID != "" && *runIDFlag == "" {
+		if err := setRunID(state.RunID); err != nil {
+			return nil, fmt.Errorf("state file %q: %v", statePath, err)
+		}
+	}
+	return state, nil
+}
+
+func (st *runState) save() {
+	if st.path == "" {
+		return
+	}
+	st.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode run state for %q: %v\r\n", st.PdName, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(st.path), 0755); err != nil {
+		log.Printf("Failed to create state directory for %q: %v\r\n", st.path, err)
+		return
+	}
+	// Write to a temporary file and rename so a crash never leaves a
+	// truncated state file behind.
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Failed to write run state %q: %v\r\n", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		log.Printf("Failed to write run state %q: %v\r\n", st.path, err)
+	}
+}
+
+func (st *runState) remove() {
+	if st.path == "" {
+		return
+	}
+	if err := os.Remove(st.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove run state %q: %v\r\n", st.path, err)
+	}
+}
+
+func (st *runState) addMount(instanceName, mountPath string) {
+	st.Mounts[instanceName] = append(st.Mounts[instanceName], mountPath)
+}
+
+func (st *runState) removeMount(instanceName, mountPath string) {
+	mounts := st.Mounts[instanceName]
+	for i, m := range mounts {
+		if m == mountPath {
+			mounts = append(mounts[:i], mounts[i+1:]...)
+			break
+		}
+	}
+	if len(mounts) == 0 {
+		delete(st.Mounts, instanceName)
+		return
+	}
+	st.Mounts[instanceName] = mounts
+}
+
+// runResume continues the run recorded in statePath from the first step that
+// had not completed.
+func runResume(ctx context.Context, statePath string) (*runResult, error) {
+	state, err := loadRunState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("***Resuming run of PD %q at step %d\r\n", state.PdName, state.CompletedSteps)
+	return executeRun(ctx, state), nil
+}
+
+// runTeardown unmounts, detaches and deletes everything recorded in
+// statePath, without running any of the remaining lifecycle steps.
+func runTeardown(ctx context.Context, statePath string) error {
+	state, err := loadRunState(statePath)
+	if err != nil {
+		return err
+	}
+	log.Printf("***Tearing down run of PD %q\r\n", state.PdName)
+
+	failed := 0
+	for _, instanceName := range sortedKeys(state.Mounts) {
+		mounts := append([]string(nil), state.Mounts[instanceName]...)
+		// Bind mounts were recorded after the mounts they point at, so
+		// unmount in reverse order.
+		for i := len(mounts) - 1; i >= 0; i-- {
+			if _, err := unmountDevice(ctx, mounts[i], instanceName); err != nil {
+				log.Println(err)
+				failed++
+				continue
+			}
+			state.removeMount(instanceName, mounts[i])
+			state.save()
+		}
+	}
+
+	// LUKS mappings hold the device open, so close them before detaching.
+	for _, instanceName := range sortedKeys(state.Mappings) {
+		if err := closeLUKS(ctx, state.PdName, instanceName); err != nil {
+			log.Println(err)
+			failed++
+			continue
+		}
+		delete(state.Mappings, instanceName)
+		state.save()
+	}
+
+	if state.Created {
+		// Detach from everything the cloud reports, not just what was
+		// recorded: the process may have died after an attach went through
+		// but before its step completed.
+		attachments := make(map[string]bool)
+		for instanceName := range state.Attachments {
+			attachments[instanceName] = true
+		}
+		if users, err := provider.VolumeUsers(ctx, state.PdName); err == nil {
+			for _, user := range users {
+				attachments[user] = true
+			}
+		} else {
+			log.Printf("Failed to describe PD %q, detaching recorded attachments only: %v\r\n", state.PdName, err)
+		}
+
+		for _, instanceName := range sortedKeys(attachments) {
+			if err := detachDiskWithRetry(ctx, state.PdName, instanceName); err != nil {
+				log.Println(err)
+				failed++
+				continue
+			}
+			delete(state.Attachments, instanceName)
+			state.save()
+		}
+
+		if err := deletePDWithRetry(ctx, state.PdName); err != nil {
+			log.Println(err)
+			failed++
+		} else {
+			state.Created = false
+		}
+	}
+
+	if failed > 0 {
+		state.save()
+		return fmt.Errorf("teardown of PD %q had %d failures, state kept in %q", state.PdName, failed, statePath)
+	}
+	state.remove()
+	log.Printf("***Teardown of PD %q complete\r\n", state.PdName)
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
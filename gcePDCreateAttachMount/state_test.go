@@ -0,0 +1,131 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRunStateRoundTrip(t *testing.T) {
+	gid := int64(2000)
+	s := scenario{
+		FSType:       "xfs",
+		ReadOnly:     true,
+		Disk:         diskSpec{Type: "pd-ssd", SizeGB: 20, Labels: map[string]string{"team": "storage"}, PhysicalBlockSizeBytes: 16384},
+		Instances:    [2]string{"node-a", "node-b"},
+		MountOptions: []string{"noatime", "discard"},
+		FSGroup:      &gid,
+		Benchmark:    &benchmarkSpec{Patterns: []string{"randread"}, BlockSizes: []string{"4k"}, QueueDepths: []int{32}, RuntimeSeconds: 30, FileSize: "1G"},
+	}
+	st := newRunState(s, generatePdName())
+	st.CompletedSteps = 7
+	st.Created = true
+	st.Attachments["node-a"] = "ro"
+	st.addMount("node-a", getDeviceGlobalMountPath(st.PdName))
+	st.addMount("node-a", getFinalMountPath(st.PdName))
+	st.Mappings["node-a"] = "luks-" + st.PdName
+	st.save()
+	t.Cleanup(st.remove)
+
+	if _, err := os.Stat(st.path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("save left its temporary file behind: %v", err)
+	}
+	loaded, err := loadRunState(st.path)
+	if err != nil {
+		t.Fatalf("loadRunState failed: %v", err)
+	}
+	if !loaded.UpdatedAt.Equal(st.UpdatedAt) {
+		t.Errorf("UpdatedAt = %v, expected %v", loaded.UpdatedAt, st.UpdatedAt)
+	}
+	loaded.UpdatedAt = st.UpdatedAt
+	if !reflect.DeepEqual(loaded, st) {
+		t.Errorf("loaded state %+v, expected %+v", loaded, st)
+	}
+
+	st.removeMount("node-a", getFinalMountPath(st.PdName))
+	st.removeMount("node-a", getDeviceGlobalMountPath(st.PdName))
+	if _, ok := st.Mounts["node-a"]; ok {
+		t.Errorf("removing every mount left %v", st.Mounts)
+	}
+}
+
+func TestLoadRunStateAdoptsRunID(t *testing.T) {
+	t.Cleanup(func() { setRunID("test-run") })
+	st := newRunState(scenario{FSType: testFSType, Disk: diskSpec{SizeGB: 10}}, generatePdName())
+	st.RunID = "test-earlier-run"
+	st.save()
+	t.Cleanup(st.remove)
+
+	loaded, err := loadRunState(st.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runID != "test-earlier-run" {
+		t.Errorf("run ID is %q after loading, expected the state's test-earlier-run", runID)
+	}
+	if loaded.Attachments == nil || loaded.Mounts == nil || loaded.Mappings == nil {
+		t.Errorf("loaded state has nil maps: %+v", loaded)
+	}
+	if _, err := loadRunState(""); err == nil {
+		t.Errorf("loadRunState accepted an empty path")
+	}
+}
+
+func TestRunTeardown(t *testing.T) {
+	ctx := context.Background()
+	p := newFakeProvider()
+	useProvider(t, p)
+	st := newRunState(scenario{FSType: testFSType, Disk: diskSpec{SizeGB: 10}}, generatePdName())
+	globalPath := getDeviceGlobalMountPath(st.PdName)
+
+	if err := p.CreateVolume(ctx, st.PdName, st.Scenario.Disk); err != nil {
+		t.Fatal(err)
+	}
+	st.Created = true
+	if err := p.format(st.PdName, testFSType); err != nil {
+		t.Fatal(err)
+	}
+	for _, instanceName := range []string{"node-a", "node-b"} {
+		if err := p.AttachVolume(ctx, st.PdName, instanceName, true /* readOnly */); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The attach to node-b went through but the process died before its
+	// step was recorded.
+	st.Attachments["node-a"] = "ro"
+	if err := mountDevice(ctx, getPDDevPath(st.PdName), globalPath, "node-a", testFSType, true /* readOnly */, nil); err != nil {
+		t.Fatal(err)
+	}
+	st.addMount("node-a", globalPath)
+	st.save()
+
+	if err := runTeardown(ctx, st.path); err != nil {
+		t.Fatalf("runTeardown failed: %v", err)
+	}
+	if _, ok := p.volumes[st.PdName]; ok {
+		t.Errorf("teardown left the PD behind")
+	}
+	if mounts := p.instance("node-a").mounts; len(mounts) != 0 {
+		t.Errorf("teardown left mounts behind: %v", mounts)
+	}
+	if _, err := os.Stat(st.path); !os.IsNotExist(err) {
+		t.Errorf("teardown kept the state file: %v", err)
+	}
+}
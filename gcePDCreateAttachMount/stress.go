@@ -0,0 +1,398 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	stressDisks       = flag.Int("stress-disks", 4, "Number of disks the stress subcommand drives concurrently.")
+	stressInstances   = flag.String("stress-instances", testInstance0Name+","+testInstance1Name, "Comma separated instances the stress subcommand attaches disks to.")
+	stressDuration    = flag.Duration("stress-duration", 10*time.Minute, "How long the stress subcommand keeps starting new attach/detach cycles.")
+	stressOpTimeout   = flag.Duration("stress-op-timeout", 5*time.Minute, "Timeout for a single operation in the stress subcommand.")
+	stressSeed        = flag.Int64("stress-seed", 0, "Seed for the stress interleaving. 0 picks one from the clock.")
+	stressSerializeVM = flag.Bool("stress-serialize-instance-ops", true, "Serialize attach and detach calls per instance, as the GCE API requires.")
+)
+
+// stressStats accumulates operation outcomes across all stress workers.
+type stressStats struct {
+	mu        sync.Mutex
+	succeeded map[string]int
+	failed    map[string]map[string]int // operation -> error class -> count
+	cycles    int
+}
+
+func newStressStats() *stressStats {
+	return &stressStats{
+		succeeded: make(map[string]int),
+		failed:    make(map[string]map[string]int),
+	}
+}
+
+func (s *stressStats) record(operation string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		s.succeeded[operation]++
+		return
+	}
+	if s.failed[operation] == nil {
+		s.failed[operation] = make(map[string]int)
+	}
+	s.failed[operation][classifyError(err)]++
+}
+
+func (s *stressStats) cycleDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycles++
+}
+
+// stressRunner drives a set of disks through attach/mount/write/unmount/detach
+// cycles on randomly chosen instances.
+type stressRunner struct {
+	instances []string
+	stats     *stressStats
+	deadline  time.Time
+
+	// instanceLocks serialize attach and detach per instance.
+	instanceLocks map[string]*sync.Mutex
+	// poisoned are the instances with an attach or detach that timed out
+	// but is still running, and holding the instance lock, in the
+	// background. New cycles skip them until it returns.
+	poisonMu sync.Mutex
+	poisoned map[string]bool
+	// steps records every operation for the history file.
+	steps stepRecorder
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+func runStress(ctx context.Context) error {
+	instances := strings.Split(*stressInstances, ",")
+	seed := *stressSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("***Stressing %d disks across %d instances for %v (seed %d)\r\n", *stressDisks, len(instances), *stressDuration, seed)
+
+	r := &stressRunner{
+		instances:     instances,
+		stats:         newStressStats(),
+		deadline:      time.Now().Add(*stressDuration),
+		instanceLocks: make(map[string]*sync.Mutex),
+		rand:          rand.New(rand.NewSource(seed)),
+	}
+	for _, instance := range instances {
+		r.instanceLocks[instance] = &sync.Mutex{}
+	}
+
+	baseName := generatePdName()
+	var pdNames []string
+	for i := 0; i < *stressDisks; i++ {
+		pdName := fmt.Sprintf("%s-stress-%d", baseName, i)
+		if _, err := createPDWithRetry(ctx, pdName, defaultScenario().Disk); err != nil {
+			r.deleteDisks(ctx, pdNames)
+			return err
+		}
+		pdNames = append(pdNames, pdName)
+	}
+
+	start := time.Now()
+	var err error
+	defer func() {
+		recordHistory("stress", fmt.Sprintf("seed %d", seed), baseName, start, r.steps.results(), err, false /* resumed */)
+	}()
+	var wg sync.WaitGroup
+	for _, pdName := range pdNames {
+		wg.Add(1)
+		go func(pdName string) {
+			defer wg.Done()
+			r.worker(ctx, pdName)
+		}(pdName)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	leaked := r.deleteDisks(ctx, pdNames)
+	printStressSummary(os.Stdout, r.stats, elapsed, seed)
+	if leaked > 0 {
+		err = fmt.Errorf("%d stress disks could not be deleted", leaked)
+	}
+	return err
+}
+
+func (r *stressRunner) worker(ctx context.Context, pdName string) {
+	devGlobalMountPath := getDeviceGlobalMountPath(pdName)
+	for cycle := 0; time.Now().Before(r.deadline); {
+		instanceName, ok := r.pickInstance()
+		if !ok {
+			// Every instance is poisoned: wait for an operation to return.
+			time.Sleep(time.Second)
+			continue
+		}
+		cycleName := fmt.Sprintf("cycle %d", cycle)
+		cycleStart := time.Now()
+		cycleCtx, sp := startSpan(ctx, cycleName, spanAttrs{Instance: instanceName, Disk: pdName})
+		attached, err := r.cycle(cycleCtx, pdName, devGlobalMountPath, instanceName, cycle)
+		if err != nil {
+			log.Printf("Stress cycle %d of PD %q on %q failed: %v\r\n", cycle, pdName, instanceName, err)
+			collectArtifacts(cycleCtx, pdName, cycle, cycleName+" on "+instanceName, []string{instanceName}, cycleStart)
+			if attached {
+				r.recover(cycleCtx, pdName, devGlobalMountPath, instanceName)
+			}
+		} else {
+			r.stats.cycleDone()
+		}
+		sp.end(err)
+		cycle++
+	}
+}
+
+// cycle runs one attach/mount/write/unmount/detach cycle and reports whether
+// the disk may still be attached to instanceName when it returns.
+func (r *stressRunner) cycle(ctx context.Context, pdName, devGlobalMountPath, instanceName string, cycle int) (bool, error) {
+	if err := r.doLocked(ctx, "attach", instanceName, func(ctx context.Context) error {
+		return provider.AttachVolume(ctx, pdName, instanceName, false /* readonly */)
+	}); err != nil {
+		// A timed out attach may still complete in the background.
+		return classifyError(err) == errClassTimeout, err
+	}
+	r.jitter()
+
+	if err := r.do(ctx, "mount", func(ctx context.Context) error {
+		devPath, err := provider.DevicePath(pdName, instanceName)
+		if err != nil {
+			return err
+		}
+		return mountDevice(ctx, devPath, devGlobalMountPath, instanceName, testFSType, false /* readOnly */, nil /* mountOptions */)
+	}); err != nil {
+		return true, err
+	}
+	r.jitter()
+
+	content := fmt.Sprintf("%s cycle %d on %s", pdName, cycle, instanceName)
+	filePath := path.Join(devGlobalMountPath, testFileName)
+	if err := r.do(ctx, "write", func(ctx context.Context) error {
+		_, err := WriteContentToFile(ctx, content, filePath, instanceName)
+		return err
+	}); err != nil {
+		return true, err
+	}
+	if err := r.do(ctx, "read", func(ctx context.Context) error {
+		readContent, err := ReadContentsFromFile(ctx, filePath, instanceName)
+		if err == nil && readContent != content {
+			err = fmt.Errorf("read file content differs. Expected: <%s> Actual: <%s>", content, readContent)
+		}
+		return err
+	}); err != nil {
+		return true, err
+	}
+	r.jitter()
+
+	if err := r.do(ctx, "unmount", func(ctx context.Context) error {
+		_, err := unmountDevice(ctx, devGlobalMountPath, instanceName)
+		return err
+	}); err != nil {
+		return true, err
+	}
+	r.jitter()
+
+	err := r.doLocked(ctx, "detach", instanceName, func(ctx context.Context) error {
+		return provider.DetachVolume(ctx, pdName, instanceName)
+	})
+	return err != nil, err
+}
+
+// recover brings a disk back to the detached state after a failed cycle so
+// the next cycle can start from scratch. After a timed out attach it waits
+// for the attach to release the instance lock.
+func (r *stressRunner) recover(ctx context.Context, pdName, devGlobalMountPath, instanceName string) {
+	unmountDevice(ctx, devGlobalMountPath, instanceName)
+	r.withInstanceLock(instanceName, func() error {
+		return detachDiskWithRetry(ctx, pdName, instanceName)
+	})
+}
+
+// do runs op in a span with the stress operation timeout and records its
+// outcome. An operation that times out keeps running in the background.
+func (r *stressRunner) do(ctx context.Context, operation string, op func(ctx context.Context) error) (err error) {
+	ctx, sp := startSpan(ctx, operation, spanAttrs{})
+	defer func() { sp.end(err) }()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- op(ctx) }()
+
+	select {
+	case err = <-done:
+	case <-time.After(*stressOpTimeout):
+		err = fmt.Errorf("%s: %w", operation, errOperationTimeout)
+	}
+	r.stats.record(operation, err)
+	r.steps.record(operation, operation, start, err)
+	return err
+}
+
+// doLocked runs op with do under the lock of instanceName. An op that times
+// out keeps the lock until it returns, so the instance is poisoned until then
+// instead of stalling every worker that picks it.
+func (r *stressRunner) doLocked(ctx context.Context, operation, instanceName string, op func(ctx context.Context) error) error {
+	released := make(chan struct{})
+	err := r.do(ctx, operation, func(ctx context.Context) error {
+		defer close(released)
+		return r.withInstanceLock(instanceName, func() error { return op(ctx) })
+	})
+	if errors.Is(err, errOperationTimeout) {
+		select {
+		case <-released:
+		default:
+			log.Printf("Poisoning instance %q until its timed out %s returns\r\n", instanceName, operation)
+			r.setPoisoned(instanceName, true)
+			go func() {
+				<-released
+				r.setPoisoned(instanceName, false)
+			}()
+		}
+	}
+	return err
+}
+
+func (r *stressRunner) setPoisoned(instanceName string, poisoned bool) {
+	r.poisonMu.Lock()
+	defer r.poisonMu.Unlock()
+	if r.poisoned == nil {
+		r.poisoned = make(map[string]bool)
+	}
+	r.poisoned[instanceName] = poisoned
+}
+
+func (r *stressRunner) withInstanceLock(instanceName string, op func() error) error {
+	if *stressSerializeVM {
+		lock := r.instanceLocks[instanceName]
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	return op()
+}
+
+// pickInstance picks one of the instances that are not poisoned. It returns
+// false if they all are.
+func (r *stressRunner) pickInstance() (string, bool) {
+	r.poisonMu.Lock()
+	var healthy []string
+	for _, instance := range r.instances {
+		if !r.poisoned[instance] {
+			healthy = append(healthy, instance)
+		}
+	}
+	r.poisonMu.Unlock()
+	if len(healthy) == 0 {
+		return "", false
+	}
+
+	r.randMu.Lock()
+	defer r.randMu.Unlock()
+	return healthy[r.rand.Intn(len(healthy))], true
+}
+
+// jitter sleeps for up to two seconds to vary the interleaving of operations
+// across workers.
+func (r *stressRunner) jitter() {
+	r.randMu.Lock()
+	d := time.Duration(r.rand.Int63n(int64(2 * time.Second)))
+	r.randMu.Unlock()
+	time.Sleep(d)
+}
+
+func (r *stressRunner) deleteDisks(ctx context.Context, pdNames []string) int {
+	leaked := 0
+	for _, pdName := range pdNames {
+		if err := deletePDWithRetry(ctx, pdName); err != nil {
+			log.Println(err)
+			leaked++
+		}
+	}
+	return leaked
+}
+
+func printStressSummary(w io.Writer, stats *stressStats, elapsed time.Duration, seed int64) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	operations := make(map[string]bool)
+	for operation := range stats.succeeded {
+		operations[operation] = true
+	}
+	for operation := range stats.failed {
+		operations[operation] = true
+	}
+	names := make([]string, 0, len(operations))
+	for operation := range operations {
+		names = append(names, operation)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tOK\tCONTENTION\tTIMEOUT\tOTHER")
+	totalOK := 0
+	for _, operation := range names {
+		failed := stats.failed[operation]
+		// OTHER counts every class without a column of its own.
+		other := 0
+		for class, n := range failed {
+			if class != errClassContention && class != errClassTimeout {
+				other += n
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n",
+			operation,
+			stats.succeeded[operation],
+			failed[errClassContention],
+			failed[errClassTimeout],
+			other)
+		totalOK += stats.succeeded[operation]
+	}
+	tw.Flush()
+
+	minutes := elapsed.Minutes()
+	if minutes == 0 {
+		minutes = 1
+	}
+	fmt.Fprintf(w, "Completed %d cycles and %d operations in %v: %.1f ops/min, %.1f cycles/min (seed %d)\n",
+		stats.cycles,
+		totalOK,
+		elapsed.Round(time.Second),
+		float64(totalOK)/minutes,
+		float64(stats.cycles)/minutes,
+		seed)
+}
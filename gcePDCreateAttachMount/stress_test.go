@@ -0,0 +1,96 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stuckAttachProvider blocks attaches to one instance until release is
+// closed.
+type stuckAttachProvider struct {
+	*fakeProvider
+	stuckInstance string
+	release       chan struct{}
+}
+
+func (p *stuckAttachProvider) AttachVolume(ctx context.Context, name, instanceName string, readOnly bool) error {
+	if instanceName == p.stuckInstance {
+		<-p.release
+	}
+	return p.fakeProvider.AttachVolume(ctx, name, instanceName, readOnly)
+}
+
+func TestStressTimedOutAttachPoisonsInstance(t *testing.T) {
+	ctx := context.Background()
+	p := &stuckAttachProvider{fakeProvider: newFakeProvider(), stuckInstance: "node-a", release: make(chan struct{})}
+	useProvider(t, p)
+	saved := *stressOpTimeout
+	*stressOpTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { *stressOpTimeout = saved })
+
+	r := &stressRunner{
+		instances:     []string{"node-a", "node-b"},
+		stats:         newStressStats(),
+		instanceLocks: map[string]*sync.Mutex{"node-a": {}, "node-b": {}},
+		rand:          rand.New(rand.NewSource(1)),
+	}
+	for _, pdName := range []string{"pd-1", "pd-2"} {
+		if err := p.CreateVolume(ctx, pdName, diskSpec{SizeGB: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attach := func(pdName, instanceName string) error {
+		return r.doLocked(ctx, "attach", instanceName, func(ctx context.Context) error {
+			return provider.AttachVolume(ctx, pdName, instanceName, false /* readonly */)
+		})
+	}
+	if err := attach("pd-1", "node-a"); classifyError(err) != errClassTimeout {
+		t.Fatalf("stuck attach returned %v, expected a timeout", err)
+	}
+	for i := 0; i < 20; i++ {
+		if instanceName, ok := r.pickInstance(); !ok || instanceName != "node-b" {
+			t.Fatalf("picked %q, %v with node-a poisoned, expected node-b", instanceName, ok)
+		}
+	}
+	if err := attach("pd-2", "node-b"); err != nil {
+		t.Errorf("attach to the healthy instance failed: %v", err)
+	}
+
+	close(p.release)
+	// The background attach returns, releases the lock and clears the
+	// poison.
+	r.withInstanceLock("node-a", func() error { return nil })
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.poisonMu.Lock()
+		poisoned := r.poisoned["node-a"]
+		r.poisonMu.Unlock()
+		if !poisoned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node-a is still poisoned after its attach returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
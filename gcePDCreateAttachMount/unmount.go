@@ -0,0 +1,131 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	unmountRetries  = flag.Int("unmount-retries", 3, "Times to retry an unmount that failed because the target is busy.")
+	unmountBackoff  = flag.Duration("unmount-backoff", 2*time.Second, "Initial wait between busy unmount retries. Doubles after every retry.")
+	unmountFallback = flag.String("unmount-fallback", "none", "What to do once busy unmount retries are exhausted: none, lazy (umount -l) or force (umount -f).")
+)
+
+// findMountHoldersScript prints one line per process whose cwd, root,
+// executable, open files or memory mappings are under the mount point.
+const findMountHoldersScript = `m=%[1]s
+for p in /proc/[0-9]*; do
+  pid=${p#/proc/}
+  for l in $p/cwd $p/root $p/exe $p/fd/*; do
+    t=$(readlink $l 2>/dev/null) || continue
+    case "$t" in
+      "$m"|"$m"/*) echo "$pid $(cat $p/comm 2>/dev/null) ${l#$p/} -> $t"; continue 2;;
+    esac
+  done
+  if grep -q " $m/" $p/maps 2>/dev/null; then
+    echo "$pid $(cat $p/comm 2>/dev/null) maps"
+  fi
+done`
+
+// findMountHolders lists the processes on instanceName that keep mountPath
+// busy, as "pid comm reason" lines.
+func findMountHolders(ctx context.Context, mountPath, instanceName string) ([]string, error) {
+	log.Printf("Looking for processes holding %q on %q\r\n", mountPath, instanceName)
+	defer fmt.Println("------------")
+
+	script := fmt.Sprintf(findMountHoldersScript, strings.TrimSuffix(mountPath, "/"))
+	outputBytes, cmdErr := runOnInstance(ctx, script, instanceName)
+	if cmdErr != nil {
+		log.Printf(
+			"Failed to list processes holding %q on %q. error: %v\r\n",
+			mountPath,
+			instanceName,
+			cmdErr)
+		return nil, cmdErr
+	}
+
+	var holders []string
+	for _, line := range strings.Split(string(outputBytes), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			holders = append(holders, line)
+		}
+	}
+	return holders, nil
+}
+
+// validateUnmountFallback rejects an unknown -unmount-fallback before any disk
+// is created, rather than once an unmount has already retried.
+func validateUnmountFallback() error {
+	switch *unmountFallback {
+	case "none", "lazy", "force":
+		return nil
+	}
+	return fmt.Errorf("unknown -unmount-fallback %q, must be none, lazy or force", *unmountFallback)
+}
+
+// unmountBusy handles an unmount that failed with EBUSY: it looks up the
+// processes holding the mount, retries with backoff while they go away, and
+// finally falls back to a lazy or forced unmount if configured to. The report
+// names the holders and how the mount was unmounted, for the step result.
+func unmountBusy(ctx context.Context, mountPath, instanceName string, outputBytes []byte, err error) ([]byte, string, error) {
+	holders, _ := findMountHolders(ctx, mountPath, instanceName)
+	log.Printf("%q on %q is busy, held by %d processes: %v\r\n", mountPath, instanceName, len(holders), holders)
+	report := func(outcome string) string {
+		return fmt.Sprintf("busy, held by [%s]; %s", strings.Join(holders, "; "), outcome)
+	}
+
+	backoff := *unmountBackoff
+	for retry := 1; retry <= *unmountRetries; retry++ {
+		log.Printf("Retrying busy unmount of %q on %q in %v (%d/%d)\r\n", mountPath, instanceName, backoff, retry, *unmountRetries)
+		time.Sleep(backoff)
+		backoff *= 2
+
+		outputBytes, err = doUnmount(ctx, mountPath, instanceName, "" /* flags */)
+		if err == nil {
+			return outputBytes, report(fmt.Sprintf("unmounted after %d retries", retry)), nil
+		}
+		if classifyError(err) != errClassBusy {
+			return outputBytes, report(fmt.Sprintf("retry %d failed", retry)), err
+		}
+		if current, listErr := findMountHolders(ctx, mountPath, instanceName); listErr == nil {
+			holders = current
+		}
+	}
+
+	var flags string
+	switch *unmountFallback {
+	case "lazy":
+		flags = "-l"
+	case "force":
+		flags = "-f"
+	default:
+		return outputBytes, report(fmt.Sprintf("still busy after %d retries", *unmountRetries)), fmt.Errorf("unmount of %q on %q still busy after %d retries, held by %v: %w", mountPath, instanceName, *unmountRetries, holders, err)
+	}
+
+	log.Printf("Falling back to %s unmount of %q on %q, still held by %v\r\n", *unmountFallback, mountPath, instanceName, holders)
+	outputBytes, err = doUnmount(ctx, mountPath, instanceName, flags)
+	if err != nil {
+		return outputBytes, report(*unmountFallback + " unmount failed"), fmt.Errorf("%s unmount of %q on %q held by %v failed: %w", *unmountFallback, mountPath, instanceName, holders, err)
+	}
+	return outputBytes, report(fmt.Sprintf("%s unmount after %d retries", *unmountFallback, *unmountRetries)), nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useUnmountFlags sets the busy unmount flags for the rest of the test.
+func useUnmountFlags(t *testing.T, retries int, fallback string) {
+	t.Helper()
+	savedRetries, savedBackoff, savedFallback := *unmountRetries, *unmountBackoff, *unmountFallback
+	*unmountRetries, *unmountBackoff, *unmountFallback = retries, time.Millisecond, fallback
+	t.Cleanup(func() { *unmountRetries, *unmountBackoff, *unmountFallback = savedRetries, savedBackoff, savedFallback })
+}
+
+// busyMount mounts a disk on node-a whose first busyUnmounts plain unmounts
+// fail with EBUSY while a shell and a tail hold it.
+func busyMount(t *testing.T, busyUnmounts int) (*fakeInstance, string) {
+	t.Helper()
+	ctx := context.Background()
+	p := newFakeProvider()
+	useProvider(t, p)
+	pdName := generatePdName()
+	mountPath := getDeviceGlobalMountPath(pdName)
+	if err := p.CreateVolume(ctx, pdName, diskSpec{SizeGB: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AttachVolume(ctx, pdName, "node-a", false /* readOnly */); err != nil {
+		t.Fatal(err)
+	}
+	if err := mountDevice(ctx, getPDDevPath(pdName), mountPath, "node-a", testFSType, false /* readOnly */, nil); err != nil {
+		t.Fatal(err)
+	}
+	inst := p.instance("node-a")
+	inst.busy = map[string]int{mountPath: busyUnmounts}
+	inst.holders = map[string][]string{mountPath: {
+		"4242 bash cwd -> " + mountPath,
+		"4243 tail fd/3 -> " + mountPath + "/log",
+	}}
+	return inst, mountPath
+}
+
+func TestUnmountBusy(t *testing.T) {
+	// The reports are formats of the mount path.
+	holders := "held by [4242 bash cwd -> %[1]s; 4243 tail fd/3 -> %[1]s/log]"
+	for _, tc := range []struct {
+		name         string
+		busyUnmounts int
+		retries      int
+		fallback     string
+		report       string
+		fails        string
+	}{
+		{
+			name:         "not busy",
+			busyUnmounts: 0,
+			retries:      3,
+			fallback:     "none",
+		},
+		{
+			name:         "retry",
+			busyUnmounts: 2,
+			retries:      3,
+			fallback:     "none",
+			report:       "busy, " + holders + "; unmounted after 2 retries",
+		},
+		{
+			name:         "retries exhausted",
+			busyUnmounts: 10,
+			retries:      2,
+			fallback:     "none",
+			report:       "busy, " + holders + "; still busy after 2 retries",
+			fails:        "still busy after 2 retries, held by [4242 bash",
+		},
+		{
+			name:         "lazy fallback",
+			busyUnmounts: 10,
+			retries:      1,
+			fallback:     "lazy",
+			report:       "busy, " + holders + "; lazy unmount after 1 retries",
+		},
+		{
+			name:         "force fallback",
+			busyUnmounts: 10,
+			retries:      0,
+			fallback:     "force",
+			report:       "busy, " + holders + "; force unmount after 0 retries",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useUnmountFlags(t, tc.retries, tc.fallback)
+			inst, mountPath := busyMount(t, tc.busyUnmounts)
+
+			report, err := unmountDevice(context.Background(), mountPath, "node-a")
+			expected := tc.report
+			if expected != "" {
+				expected = fmt.Sprintf(expected, mountPath)
+			}
+			if report != expected {
+				t.Errorf("unmountDevice reported %q, expected %q", report, expected)
+			}
+			_, mounted := inst.lookupMount(mountPath)
+			if tc.fails == "" {
+				if err != nil {
+					t.Errorf("unmountDevice failed: %v", err)
+				}
+				if mounted {
+					t.Errorf("%s is still mounted", mountPath)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.fails) || classifyError(err) != errClassBusy {
+				t.Errorf("unmountDevice returned %v, expected a busy error containing %q", err, tc.fails)
+			}
+			if !mounted {
+				t.Errorf("%s was unmounted without a fallback", mountPath)
+			}
+		})
+	}
+}
+
+func TestUnmountStepReportsHolders(t *testing.T) {
+	useUnmountFlags(t, 3, "none")
+	_, mountPath := busyMount(t, 1)
+	l := &lifecycle{devGlobalMountPath: mountPath}
+	st := l.unmount("node-a")
+	if err := st.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(l.detail, "4242 bash") || !strings.Contains(l.detail, "unmounted after 1 retries") {
+		t.Errorf("unmount step detail is %q, expected the holders and the retries", l.detail)
+	}
+}
+
+func TestValidateUnmountFallback(t *testing.T) {
+	for fallback, ok := range map[string]bool{
+		"none":  true,
+		"lazy":  true,
+		"force": true,
+		"":      false,
+		"Lazy":  false,
+		"-l":    false,
+	} {
+		useUnmountFlags(t, 3, fallback)
+		if err := validateUnmountFallback(); (err == nil) != ok {
+			t.Errorf("validateUnmountFallback() with %q = %v, expected ok=%v", fallback, err, ok)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+)
+
+// gceDisk is the subset of `gcloud compute disks describe` output the tool
+// inspects.
+type gceDisk struct {
+	Name   string            `json:"name"`
+	Status string            `json:"status"`
+	Users  []string          `json:"users"`
+	Labels map[string]string `json:"labels"`
+}
+
+// gceInstance is the subset of `gcloud compute instances describe` output the
+// tool inspects.
+type gceInstance struct {
+	Name   string            `json:"name"`
+	Status string            `json:"status"`
+	Zone   string            `json:"zone"`
+	Disks  []gceAttachedDisk `json:"disks"`
+}
+
+type gceAttachedDisk struct {
+	DeviceName string `json:"deviceName"`
+	Mode       string `json:"mode"`
+	Source     string `json:"source"`
+	Boot       bool   `json:"boot"`
+}
+
+func describeDisk(ctx context.Context, pdName string) (*gceDisk, error) {
+	cmdArgs := []string{
+		"compute",
+		"--project=" + testProjectID,
+		"disks",
+		"describe",
+		pdName,
+		diskLocationFlag(pdName),
+		"--format=json"}
+	outputBytes, cmdErr := executeGCloudCmdStdout(ctx, cmdArgs)
+	if cmdErr != nil {
+		return nil, cmdErr
+	}
+
+	disk := &gceDisk{}
+	if err := json.Unmarshal(outputBytes, disk); err != nil {
+		return nil, fmt.Errorf("parsing description of PD %q failed: %v\noutput: %s", pdName, err, string(outputBytes))
+	}
+	return disk, nil
+}
+
+func describeInstance(ctx context.Context, instanceName string) (*gceInstance, error) {
+	cmdArgs := []string{
+		"compute",
+		"--project=" + testProjectID,
+		"instances",
+		"describe",
+		instanceName,
+		"--zone=" + instanceZone(instanceName),
+		"--format=json"}
+	outputBytes, cmdErr := executeGCloudCmdStdout(ctx, cmdArgs)
+	if cmdErr != nil {
+		return nil, cmdErr
+	}
+
+	instance := &gceInstance{}
+	if err := json.Unmarshal(outputBytes, instance); err != nil {
+		return nil, fmt.Errorf("parsing description of instance %q failed: %v\noutput: %s", instanceName, err, string(outputBytes))
+	}
+	return instance, nil
+}
+
+// verifyAttachment checks that the cloud's view of pdName and instanceName
+// agrees with the intended state: attached in the given mode, or not attached
+// at all.
+func verifyAttachment(ctx context.Context, pdName, instanceName string, attached, readOnly bool) error {
+	log.Printf("Verifying PD %q attached=%v to %q in the cloud\r\n", pdName, attached, instanceName)
+
+	disk, err := describeDisk(ctx, pdName)
+	if err != nil {
+		return err
+	}
+	instance, err := describeInstance(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	inUsers := false
+	for _, user := range disk.Users {
+		if path.Base(user) == instanceName {
+			inUsers = true
+			break
+		}
+	}
+
+	var entry *gceAttachedDisk
+	for i := range instance.Disks {
+		if path.Base(instance.Disks[i].Source) == pdName {
+			entry = &instance.Disks[i]
+			break
+		}
+	}
+
+	if !attached {
+		if inUsers {
+			return fmt.Errorf("PD %q still lists %q as a user after detach (users: %v)", pdName, instanceName, disk.Users)
+		}
+		if entry != nil {
+			return fmt.Errorf("instance %q still lists PD %q as attached after detach", instanceName, pdName)
+		}
+		return nil
+	}
+
+	if !inUsers {
+		return fmt.Errorf("PD %q does not list %q as a user after attach (users: %v)", pdName, instanceName, disk.Users)
+	}
+	if entry == nil {
+		return fmt.Errorf("instance %q does not list PD %q as attached after attach", instanceName, pdName)
+	}
+	wantMode := "READ_WRITE"
+	if readOnly {
+		wantMode = "READ_ONLY"
+	}
+	if entry.Mode != wantMode {
+		return fmt.Errorf("PD %q is attached to %q in mode %q, expected %q", pdName, instanceName, entry.Mode, wantMode)
+	}
+	if entry.DeviceName != pdName {
+		return fmt.Errorf("PD %q is attached to %q with device name %q, expected %q", pdName, instanceName, entry.DeviceName, pdName)
+	}
+	return nil
+}
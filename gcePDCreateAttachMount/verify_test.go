@@ -0,0 +1,173 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDescribe answers gcloud compute disks and instances describe with
+// disk.json and instance.json from its state directory, after a warning on
+// stderr.
+const fakeDescribe = `echo "WARNING: The private SSH key file for gcloud does not exist." >&2
+case "$*" in
+*"disks describe"*) cat "$FAKE_GCLOUD_STATE/disk.json" ;;
+*"instances describe"*) cat "$FAKE_GCLOUD_STATE/instance.json" ;;
+*) exit 1 ;;
+esac
+`
+
+const (
+	describedPD       = "pd-1"
+	describedInstance = "node-a"
+	diskSelfLink      = "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/disks/pd-1"
+	instanceSelfLink  = "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/instances/node-a"
+)
+
+// describedDisk is the description of pd-1 used by users.
+func describedDisk(users ...string) string {
+	quoted := make([]string, 0, len(users))
+	for _, user := range users {
+		quoted = append(quoted, `"`+user+`"`)
+	}
+	return `{
+  "kind": "compute#disk",
+  "name": "pd-1",
+  "selfLink": "` + diskSelfLink + `",
+  "sizeGb": "10",
+  "status": "READY",
+  "type": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/diskTypes/pd-standard",
+  "users": [` + strings.Join(quoted, ", ") + `]
+}`
+}
+
+// describedInstanceWith is the description of node-a with its boot disk and
+// the given extra disks attached.
+func describedInstanceWith(disks ...string) string {
+	return `{
+  "kind": "compute#instance",
+  "name": "node-a",
+  "status": "RUNNING",
+  "zone": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b",
+  "disks": [
+    {"boot": true, "deviceName": "persistent-disk-0", "mode": "READ_WRITE", "source": "https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/disks/node-a"}` +
+		strings.Join(append([]string{""}, disks...), ",\n    ") + `
+  ]
+}`
+}
+
+func attachedDiskEntry(deviceName, mode string) string {
+	return `{"boot": false, "deviceName": "` + deviceName + `", "mode": "` + mode + `", "source": "` + diskSelfLink + `"}`
+}
+
+func TestVerifyAttachment(t *testing.T) {
+	state := useFakeGCloud(t, fakeDescribe)
+	for _, tc := range []struct {
+		name     string
+		disk     string
+		instance string
+		attached bool
+		readOnly bool
+		fails    string
+	}{
+		{
+			name:     "attached read-write",
+			disk:     describedDisk(instanceSelfLink),
+			instance: describedInstanceWith(attachedDiskEntry(describedPD, "READ_WRITE")),
+			attached: true,
+		},
+		{
+			name:     "attached read-only",
+			disk:     describedDisk(instanceSelfLink),
+			instance: describedInstanceWith(attachedDiskEntry(describedPD, "READ_ONLY")),
+			attached: true,
+			readOnly: true,
+		},
+		{
+			name:     "not in the disk users",
+			disk:     describedDisk("https://www.googleapis.com/compute/v1/projects/saads-vms2/zones/us-central1-b/instances/node-b"),
+			instance: describedInstanceWith(attachedDiskEntry(describedPD, "READ_WRITE")),
+			attached: true,
+			fails:    "as a user after attach",
+		},
+		{
+			name:     "not in the instance disks",
+			disk:     describedDisk(instanceSelfLink),
+			instance: describedInstanceWith(),
+			attached: true,
+			fails:    "does not list PD",
+		},
+		{
+			name:     "wrong mode",
+			disk:     describedDisk(instanceSelfLink),
+			instance: describedInstanceWith(attachedDiskEntry(describedPD, "READ_WRITE")),
+			attached: true,
+			readOnly: true,
+			fails:    `in mode "READ_WRITE", expected "READ_ONLY"`,
+		},
+		{
+			name:     "wrong device name",
+			disk:     describedDisk(instanceSelfLink),
+			instance: describedInstanceWith(attachedDiskEntry("persistent-disk-1", "READ_WRITE")),
+			attached: true,
+			fails:    `device name "persistent-disk-1"`,
+		},
+		{
+			name:     "detached",
+			disk:     describedDisk(),
+			instance: describedInstanceWith(),
+		},
+		{
+			name:     "still a disk user after detach",
+			disk:     describedDisk(instanceSelfLink),
+			instance: describedInstanceWith(),
+			fails:    "as a user after detach",
+		},
+		{
+			name:     "still in the instance disks after detach",
+			disk:     describedDisk(),
+			instance: describedInstanceWith(attachedDiskEntry(describedPD, "READ_WRITE")),
+			fails:    "still lists PD",
+		},
+		{
+			name:     "description does not parse",
+			disk:     `{"name": "pd-1", "users": `,
+			instance: describedInstanceWith(),
+			fails:    "parsing description",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(state, "disk.json"), []byte(tc.disk), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(state, "instance.json"), []byte(tc.instance), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := verifyAttachment(context.Background(), describedPD, describedInstance, tc.attached, tc.readOnly)
+			switch {
+			case tc.fails == "" && err != nil:
+				t.Errorf("verifyAttachment failed: %v", err)
+			case tc.fails != "" && (err == nil || !strings.Contains(err.Error(), tc.fails)):
+				t.Errorf("verifyAttachment returned %v, expected an error containing %q", err, tc.fails)
+			}
+		})
+	}
+}
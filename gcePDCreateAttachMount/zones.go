@@ -0,0 +1,146 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+var (
+	gceZone           = flag.String("zone", testProjectZone, "Zone of zonal PDs, and of instances not listed in -instance-zones.")
+	instanceZones     = flag.String("instance-zones", "", "Comma separated instance=zone pairs for instances outside -zone.")
+	replicaZones      = flag.String("replica-zones", "", "Two comma separated zones of one region. When set, the lifecycle creates regional PDs replicated across them.")
+	crossZoneInstance = flag.String("cross-zone-instance", "", "Instance outside -zone the cross-zone subcommand attaches a zonal PD to.")
+)
+
+// instanceZone returns the zone of instanceName from -instance-zones,
+// defaulting to -zone.
+func instanceZone(instanceName string) string {
+	for _, pair := range strings.Split(*instanceZones, ",") {
+		if name, zone, ok := strings.Cut(pair, "="); ok && name == instanceName {
+			return zone
+		}
+	}
+	return *gceZone
+}
+
+// zoneRegion returns the region of a zone, e.g. us-central1 for
+// us-central1-b.
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// replicaZonesFromFlags returns the replica zones set with -replica-zones, or
+// nil for zonal PDs.
+func replicaZonesFromFlags() ([]string, error) {
+	if *replicaZones == "" {
+		return nil, nil
+	}
+	zones := strings.Split(*replicaZones, ",")
+	return zones, validateReplicaZones(zones)
+}
+
+// validateReplicaZones checks that zones can hold a regional PD: exactly two
+// distinct zones of the same region.
+func validateReplicaZones(zones []string) error {
+	if len(zones) == 0 {
+		return nil
+	}
+	if len(zones) != 2 || zones[0] == zones[1] {
+		return fmt.Errorf("a regional PD needs two distinct replica zones, got %v", zones)
+	}
+	if zoneRegion(zones[0]) != zoneRegion(zones[1]) {
+		return fmt.Errorf("replica zones %v are in different regions", zones)
+	}
+	return nil
+}
+
+// regionalDisks maps the name of every regional PD the tool knows of to its
+// replica zones. GCE addresses regional PDs by region rather than zone, and
+// only the create call says which kind a PD is.
+var regionalDisks = struct {
+	sync.Mutex
+	m map[string][]string
+}{m: make(map[string][]string)}
+
+// registerDisk records that pdName was created with spec.
+func registerDisk(pdName string, spec diskSpec) {
+	if len(spec.ReplicaZones) == 0 {
+		return
+	}
+	regionalDisks.Lock()
+	defer regionalDisks.Unlock()
+	regionalDisks.m[pdName] = spec.ReplicaZones
+}
+
+// diskReplicaZones returns the replica zones of pdName, or nil if it is
+// zonal.
+func diskReplicaZones(pdName string) []string {
+	regionalDisks.Lock()
+	defer regionalDisks.Unlock()
+	return regionalDisks.m[pdName]
+}
+
+// diskLocationFlag returns the gcloud flag locating pdName: --region for
+// regional PDs, --zone otherwise.
+func diskLocationFlag(pdName string) string {
+	if zones := diskReplicaZones(pdName); zones != nil {
+		return "--region=" + zoneRegion(zones[0])
+	}
+	return "--zone=" + *gceZone
+}
+
+// runCrossZone checks that attaching a zonal PD to an instance in another
+// zone fails, with an error classified as a zone mismatch, and leaves the PD
+// unattached.
+func runCrossZone(ctx context.Context) error {
+	instanceName := *crossZoneInstance
+	if instanceName == "" {
+		return fmt.Errorf("-cross-zone-instance is required")
+	}
+	if zone := instanceZone(instanceName); zone == *gceZone {
+		return fmt.Errorf("instance %q is in zone %q, the same as -zone; set its zone with -instance-zones", instanceName, zone)
+	}
+
+	pdName := generatePdName()
+	if _, err := createPDWithRetry(ctx, pdName, diskSpec{SizeGB: 10}); err != nil {
+		return err
+	}
+	defer deletePDWithRetry(ctx, pdName)
+
+	attachErr := provider.AttachVolume(ctx, pdName, instanceName, false /* readOnly */)
+	if attachErr == nil {
+		detachDiskWithRetry(ctx, pdName, instanceName)
+		return fmt.Errorf("attaching PD %q in %q to %q in %q succeeded, expected a zone mismatch", pdName, *gceZone, instanceName, instanceZone(instanceName))
+	}
+	if class := classifyError(attachErr); class != errClassZoneMismatch {
+		return fmt.Errorf("attaching PD %q to %q failed with a %s error, expected %s: %v", pdName, instanceName, class, errClassZoneMismatch, attachErr)
+	}
+	if err := provider.VerifyAttachment(ctx, pdName, instanceName, false /* attached */, false /* readOnly */); err != nil {
+		return fmt.Errorf("rejected cross-zone attach left state behind: %v", err)
+	}
+	log.Printf("Cross-zone attach of PD %q to %q failed as expected: %v\r\n", pdName, instanceName, attachErr)
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// useZoneFlags sets -zone, -instance-zones and -replica-zones for the test.
+func useZoneFlags(t *testing.T, zone, instances, replicas string) {
+	t.Helper()
+	savedZone, savedInstances, savedReplicas := *gceZone, *instanceZones, *replicaZones
+	*gceZone, *instanceZones, *replicaZones = zone, instances, replicas
+	t.Cleanup(func() { *gceZone, *instanceZones, *replicaZones = savedZone, savedInstances, savedReplicas })
+}
+
+func TestZoneRegion(t *testing.T) {
+	for zone, expected := range map[string]string{
+		"us-central1-b":   "us-central1",
+		"europe-west4-a":  "europe-west4",
+		"asia-northeast1": "asia",
+		"local":           "local",
+		"":                "",
+	} {
+		if actual := zoneRegion(zone); actual != expected {
+			t.Errorf("zoneRegion(%q) = %q, expected %q", zone, actual, expected)
+		}
+	}
+}
+
+func TestInstanceZone(t *testing.T) {
+	useZoneFlags(t, "us-central1-b", "node-c=us-central1-c,node-e=europe-west4-a", "")
+	for instanceName, expected := range map[string]string{
+		"node-a": "us-central1-b",
+		"node-c": "us-central1-c",
+		"node-e": "europe-west4-a",
+		"node":   "us-central1-b",
+	} {
+		if actual := instanceZone(instanceName); actual != expected {
+			t.Errorf("instanceZone(%q) = %q, expected %q", instanceName, actual, expected)
+		}
+	}
+}
+
+func TestReplicaZonesFromFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		flag  string
+		zones []string
+		valid bool
+	}{
+		{name: "zonal", flag: "", valid: true},
+		{name: "two zones of a region", flag: "us-central1-b,us-central1-c", zones: []string{"us-central1-b", "us-central1-c"}, valid: true},
+		{name: "one zone", flag: "us-central1-b", zones: []string{"us-central1-b"}},
+		{name: "three zones", flag: "us-central1-a,us-central1-b,us-central1-c", zones: []string{"us-central1-a", "us-central1-b", "us-central1-c"}},
+		{name: "same zone twice", flag: "us-central1-b,us-central1-b", zones: []string{"us-central1-b", "us-central1-b"}},
+		{name: "different regions", flag: "us-central1-b,us-east1-b", zones: []string{"us-central1-b", "us-east1-b"}},
+		{name: "trailing comma", flag: "us-central1-b,", zones: []string{"us-central1-b", ""}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useZoneFlags(t, "us-central1-b", "", tc.flag)
+			zones, err := replicaZonesFromFlags()
+			if !reflect.DeepEqual(zones, tc.zones) {
+				t.Errorf("replicaZonesFromFlags() with -replica-zones=%q returned %q, expected %q", tc.flag, zones, tc.zones)
+			}
+			if (err == nil) != tc.valid {
+				t.Errorf("replicaZonesFromFlags() with -replica-zones=%q returned error %v, expected valid=%v", tc.flag, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestDiskLocationFlag(t *testing.T) {
+	useZoneFlags(t, "us-central1-b", "", "")
+	t.Cleanup(func() {
+		regionalDisks.Lock()
+		defer regionalDisks.Unlock()
+		delete(regionalDisks.m, "pd-regional")
+		delete(regionalDisks.m, "pd-zonal")
+	})
+
+	registerDisk("pd-regional", diskSpec{SizeGB: 200, ReplicaZones: []string{"europe-west4-a", "europe-west4-b"}})
+	registerDisk("pd-zonal", diskSpec{SizeGB: 10})
+	for pdName, expected := range map[string]string{
+		"pd-regional": "--region=europe-west4",
+		"pd-zonal":    "--zone=us-central1-b",
+		// PDs the tool did not create, e.g. from an older state file, are
+		// taken to be zonal.
+		"pd-unknown": "--zone=us-central1-b",
+	} {
+		if actual := diskLocationFlag(pdName); actual != expected {
+			t.Errorf("diskLocationFlag(%q) = %q, expected %q", pdName, actual, expected)
+		}
+	}
+	if zones := diskReplicaZones("pd-zonal"); zones != nil {
+		t.Errorf("zonal PD registered with replica zones %q", zones)
+	}
+}